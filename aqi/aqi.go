@@ -17,6 +17,8 @@ package aqi
 import (
 	"math"
 	"sync"
+
+	"github.com/openairtech/api"
 )
 
 var (
@@ -47,6 +49,32 @@ func (pm *PM) Aqi() int {
 	return iaqi25
 }
 
+// MeasurementAqi returns AQI of measurement m: provided AQI value, if it is set,
+// or AQI computed from PM values, if both of them are set, or nil otherwise.
+func MeasurementAqi(m api.Measurement) *int {
+	if m.Aqi != nil || m.Pm10 == nil || m.Pm25 == nil {
+		return m.Aqi
+	}
+	pm := PM{Pm25: *m.Pm25, Pm10: *m.Pm10}
+	a := pm.Aqi()
+	return &a
+}
+
+// Pm25Aqi returns AQI of PM2.5 concentration c.
+func Pm25Aqi(c float32) int {
+	return iaqi(c, pm25Bps, 0.1)
+}
+
+// Pm10Aqi returns AQI of PM10 concentration c.
+func Pm10Aqi(c float32) int {
+	return iaqi(c, pm10Bps, 1.0)
+}
+
+// Category returns category of AQI value a, from 0 (good) to 6 (the upper range of hazardous).
+func Category(a int) int {
+	return breakpoint(aqiVals, float32(a))
+}
+
 func iaqi(c float32, bps []float32, q float32) int {
 	c = float32(math.Floor(float64(c/q))) * q
 	bp := breakpoint(bps, c)
@@ -77,3 +105,9 @@ func constrain(x, a, b float32) float32 {
 	}
 	return x
 }
+
+// WHO 2021 air quality guideline levels of 24-hour mean PM concentrations, µg/m³
+const (
+	WhoPm25DailyGuideline = 15.0
+	WhoPm10DailyGuideline = 45.0
+)
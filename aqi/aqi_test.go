@@ -16,6 +16,8 @@ package aqi
 
 import (
 	"testing"
+
+	"github.com/openairtech/api"
 )
 
 func TestPM_Valid(t *testing.T) {
@@ -104,3 +106,46 @@ func Test_iaqi(t *testing.T) {
 		})
 	}
 }
+
+func TestCategory(t *testing.T) {
+	tests := []struct {
+		aqi  int
+		want int
+	}{
+		{aqi: 0, want: 0},
+		{aqi: 50, want: 0},
+		{aqi: 51, want: 1},
+		{aqi: 150, want: 2},
+		{aqi: 151, want: 3},
+		{aqi: 300, want: 4},
+		{aqi: 301, want: 5},
+		{aqi: 500, want: 6},
+	}
+	for _, tt := range tests {
+		if got := Category(tt.aqi); got != tt.want {
+			t.Errorf("Category(%d) = %v, want %v", tt.aqi, got, tt.want)
+		}
+	}
+}
+
+func TestMeasurementAqi(t *testing.T) {
+	f := func(v float32) *float32 { return &v }
+	a := 42
+	tests := []struct {
+		name string
+		m    api.Measurement
+		want *int
+	}{
+		{name: "provided", m: api.Measurement{Aqi: &a, Pm25: f(100), Pm10: f(100)}, want: &a},
+		{name: "computed", m: api.Measurement{Pm25: f(12), Pm10: f(20)}, want: &[]int{50}[0]},
+		{name: "no pm10", m: api.Measurement{Pm25: f(12)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := MeasurementAqi(tt.m)
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("MeasurementAqi() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import "math"
+
+// gridCellSize is the grid cell size in degrees
+const gridCellSize = 0.1
+
+type cell struct {
+	x, y int
+}
+
+// grid is a uniform grid spatial index of points identified by integer IDs.
+type grid struct {
+	cells map[cell]map[int]struct{}
+}
+
+func newGrid() *grid {
+	return &grid{cells: make(map[cell]map[int]struct{})}
+}
+
+func cellOf(x, y float64) cell {
+	return cell{int(math.Floor(x / gridCellSize)), int(math.Floor(y / gridCellSize))}
+}
+
+func (g *grid) insert(id int, x, y float64) {
+	c := cellOf(x, y)
+	ids, ok := g.cells[c]
+	if !ok {
+		ids = make(map[int]struct{})
+		g.cells[c] = ids
+	}
+	ids[id] = struct{}{}
+}
+
+func (g *grid) remove(id int, x, y float64) {
+	c := cellOf(x, y)
+	if ids, ok := g.cells[c]; ok {
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(g.cells, c)
+		}
+	}
+}
+
+// search calls f for IDs of points in grid cells intersecting with bounding box
+// [minX, minY, maxX, maxY]. Points must be checked against the bounding box by caller.
+func (g *grid) search(minX, minY, maxX, maxY float64, f func(id int)) {
+	lo, hi := cellOf(minX, minY), cellOf(maxX, maxY)
+	if hi.x < lo.x || hi.y < lo.y {
+		return
+	}
+
+	// Scan non-empty cells instead of bounding box cells if there are fewer of them
+	if n := float64(hi.x-lo.x+1) * float64(hi.y-lo.y+1); n > float64(len(g.cells)) {
+		for c, ids := range g.cells {
+			if c.x >= lo.x && c.x <= hi.x && c.y >= lo.y && c.y <= hi.y {
+				for id := range ids {
+					f(id)
+				}
+			}
+		}
+		return
+	}
+
+	for x := lo.x; x <= hi.x; x++ {
+		for y := lo.y; y <= hi.y; y++ {
+			for id := range g.cells[cell{x, y}] {
+				f(id)
+			}
+		}
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/openairtech/apiserver/db"
+)
+
+// Stations is an in-memory cache of stations along with their last measurements.
+// It is updated by station feeds and periodically reloaded from database
+// to catch up with station data changes made bypassing the API server.
+type Stations struct {
+	mu       sync.RWMutex
+	stations map[int]db.Station
+	index    *grid
+	ready    bool
+	// loading is set while stations are being reloaded, so feeds are kept to be applied to loaded ones
+	loading bool
+	pending []db.Feed
+}
+
+func NewStations() *Stations {
+	return &Stations{
+		stations: make(map[int]db.Station),
+		index:    newGrid(),
+	}
+}
+
+// Ready checks whether stations are loaded to cache.
+func (c *Stations) Ready() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ready
+}
+
+// Load loads all stations with their last measurements from database d replacing cached ones.
+func (c *Stations) Load(ctx context.Context, d *db.Db) error {
+	c.mu.Lock()
+	c.loading = true
+	c.mu.Unlock()
+
+	dss, err := d.Stations(ctx, nil, nil, nil, true)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.loading = false
+	pending := c.pending
+	c.pending = nil
+
+	if err != nil {
+		return err
+	}
+
+	c.stations = make(map[int]db.Station, len(dss))
+	c.index = newGrid()
+	for _, s := range dss {
+		c.stations[s.Id] = s
+		c.index.insert(s.Id, s.Location.X, s.Location.Y)
+	}
+
+	for _, f := range pending {
+		c.update(f)
+	}
+
+	c.ready = true
+
+	return nil
+}
+
+// Run warms up cache and then reloads it with given interval until ctx is done.
+func (c *Stations) Run(ctx context.Context, d *db.Db, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		if err := c.Load(ctx, d); err != nil && ctx.Err() == nil {
+			log.Errorf("can't load stations to cache: %v", err)
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Update updates cached station data and last measurement from feed f.
+func (c *Stations) Update(f db.Feed) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.loading {
+		c.pending = append(c.pending, f)
+	}
+
+	c.update(f)
+}
+
+func (c *Stations) update(f db.Feed) {
+	s, ok := c.stations[f.StationId]
+	if !ok {
+		// Station is not loaded yet, it will be loaded on next cache reload
+		return
+	}
+
+	if s.Seen == nil || f.Seen.After(*s.Seen) {
+		seen := f.Seen
+		s.Seen = &seen
+		s.Version = f.Version
+		// Feed without location (SRID is not set) doesn't move station
+		if f.Location.SRID != 0 && f.Location != s.Location {
+			c.index.remove(s.Id, s.Location.X, s.Location.Y)
+			c.index.insert(s.Id, f.Location.X, f.Location.Y)
+			s.Location = f.Location
+		}
+	}
+
+	for _, m := range f.Measurements {
+		if m.Timestamp == nil {
+			continue
+		}
+		if s.Measurement.Timestamp == nil || m.Timestamp.After(*s.Measurement.Timestamp) {
+			s.Measurement = m
+		}
+	}
+
+	c.stations[f.StationId] = s
+}
+
+// Stations gets slice of cached stations with their last measurements sorted by station ID.
+// bbox, if not empty, defines a bounding box [min_long, min_lat, max_long, max_lat] to get stations within it.
+// mlast, if not nil, defines the maximum age of last measurement to include in result.
+// sall, if true, will return all stations and their data, otherwise public stations only.
+func (c *Stations) Stations(bbox []float64, mlast *time.Duration, sall bool) []db.Station {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var ss []db.Station
+
+	add := func(s db.Station) {
+		if !sall && !s.IsPublic {
+			return
+		}
+		if mlast != nil && s.Measurement.Timestamp != nil &&
+			!s.Measurement.Timestamp.After(time.Now().Add(-*mlast)) {
+			s.Measurement = db.Measurement{}
+		}
+		ss = append(ss, s)
+	}
+
+	if len(bbox) == 4 {
+		c.index.search(bbox[0], bbox[1], bbox[2], bbox[3], func(id int) {
+			s := c.stations[id]
+			if s.Location.X >= bbox[0] && s.Location.X <= bbox[2] &&
+				s.Location.Y >= bbox[1] && s.Location.Y <= bbox[3] {
+				add(s)
+			}
+		})
+	} else {
+		for _, s := range c.stations {
+			add(s)
+		}
+	}
+
+	sort.Slice(ss, func(i, j int) bool { return ss[i].Id < ss[j].Id })
+
+	return ss
+}
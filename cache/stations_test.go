@@ -0,0 +1,122 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cridenour/go-postgis"
+
+	"github.com/openairtech/apiserver/db"
+)
+
+func testStations() *Stations {
+	c := NewStations()
+	for _, s := range []db.Station{
+		{Id: 1, IsPublic: true, Location: postgis.PointS{SRID: 4326, X: 44.50, Y: 48.70}},
+		{Id: 2, IsPublic: true, Location: postgis.PointS{SRID: 4326, X: 44.52, Y: 48.68}},
+		{Id: 3, IsPublic: false, Location: postgis.PointS{SRID: 4326, X: 44.55, Y: 48.72}},
+		{Id: 4, IsPublic: true, Location: postgis.PointS{SRID: 4326, X: 37.61, Y: 55.75}},
+		{Id: 5, IsPublic: true, Location: postgis.PointS{SRID: 4326, X: -0.12, Y: 51.50}},
+	} {
+		c.stations[s.Id] = s
+		c.index.insert(s.Id, s.Location.X, s.Location.Y)
+	}
+	c.ready = true
+	return c
+}
+
+func stationIds(ss []db.Station) []int {
+	ids := make([]int, 0, len(ss))
+	for _, s := range ss {
+		ids = append(ids, s.Id)
+	}
+	return ids
+}
+
+func TestStations_Stations(t *testing.T) {
+	tests := []struct {
+		name string
+		bbox []float64
+		sall bool
+		want []int
+	}{
+		{name: "all public", want: []int{1, 2, 4, 5}},
+		{name: "all", sall: true, want: []int{1, 2, 3, 4, 5}},
+		{name: "city", bbox: []float64{44.43, 48.65, 44.53, 48.71}, want: []int{1, 2}},
+		{name: "city all", bbox: []float64{44.43, 48.65, 44.60, 48.75}, sall: true, want: []int{1, 2, 3}},
+		{name: "world", bbox: []float64{-180, -90, 180, 90}, want: []int{1, 2, 4, 5}},
+		{name: "empty", bbox: []float64{10, 10, 11, 11}, want: []int{}},
+	}
+	c := testStations()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stationIds(c.Stations(tt.bbox, nil, tt.sall)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Stations() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStations_Update(t *testing.T) {
+	c := testStations()
+
+	now := time.Now()
+	old := now.Add(-2 * time.Hour)
+	c.Update(db.Feed{
+		StationId: 1,
+		Seen:      now,
+		Version:   sql.NullString{String: "1.0", Valid: true},
+		Measurements: []db.Measurement{
+			{Timestamp: &now, Pm25: sql.NullFloat64{Float64: 10, Valid: true}},
+			{Timestamp: &old, Pm25: sql.NullFloat64{Float64: 20, Valid: true}},
+		},
+	})
+
+	ss := c.Stations([]float64{44.49, 48.69, 44.51, 48.71}, nil, false)
+	if len(ss) != 1 {
+		t.Fatalf("Stations() = %v, want single station", stationIds(ss))
+	}
+	s := ss[0]
+	if s.Seen == nil || !s.Seen.Equal(now) || s.Version.String != "1.0" {
+		t.Errorf("station seen = %v, version = %v, want %v, 1.0", s.Seen, s.Version, now)
+	}
+	if s.Measurement.Pm25.Float64 != 10 {
+		t.Errorf("station last measurement PM2.5 = %v, want 10", s.Measurement.Pm25.Float64)
+	}
+
+	mlast := time.Hour
+	c.Update(db.Feed{StationId: 2, Seen: old, Measurements: []db.Measurement{{Timestamp: &old}}})
+	ss = c.Stations([]float64{44.51, 48.67, 44.53, 48.69}, &mlast, false)
+	if len(ss) != 1 || ss[0].Measurement.Timestamp != nil {
+		t.Errorf("Stations() with mlast = %+v, want station without last measurement", ss)
+	}
+}
+
+func TestStations_UpdateLocation(t *testing.T) {
+	c := testStations()
+
+	c.Update(db.Feed{StationId: 1, Seen: time.Now(), Location: postgis.PointS{SRID: 4326, X: 37.60, Y: 55.74}})
+
+	if ss := c.Stations([]float64{44.49, 48.69, 44.51, 48.71}, nil, false); len(ss) != 0 {
+		t.Errorf("Stations() at old location = %v, want none", stationIds(ss))
+	}
+	if ss := c.Stations([]float64{37.5, 55.7, 37.7, 55.8}, nil, false); !reflect.DeepEqual(stationIds(ss), []int{1, 4}) {
+		t.Errorf("Stations() at new location = %v, want [1 4]", stationIds(ss))
+	}
+}
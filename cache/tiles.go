@@ -0,0 +1,164 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"container/list"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/openairtech/apiserver/db"
+)
+
+type tile struct {
+	z, x, y int
+}
+
+type tileEntry struct {
+	tile    tile
+	variant string
+	data    []byte
+	created time.Time
+}
+
+// Tiles is an in-memory LRU cache of vector tiles. Tiles are invalidated by station feeds
+// and expire after given time since measurements included in tiles are getting outdated.
+// Tiles of the same coordinates can have several variants, e.g. for different request parameters.
+type Tiles struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	lru     *list.List
+	entries map[tile]map[string]*list.Element
+}
+
+// NewTiles creates tile cache keeping up to size tiles for ttl.
+func NewTiles(size int, ttl time.Duration) *Tiles {
+	return &Tiles{
+		size:    size,
+		ttl:     ttl,
+		lru:     list.New(),
+		entries: make(map[tile]map[string]*list.Element),
+	}
+}
+
+// Get gets variant of tile z/x/y, returns false if there is no such tile cached.
+func (c *Tiles) Get(z, x, y int, variant string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[tile{z, x, y}][variant]
+	if !ok {
+		return nil, false
+	}
+
+	te := e.Value.(*tileEntry)
+	if time.Since(te.created) > c.ttl {
+		c.remove(e)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(e)
+
+	return te.data, true
+}
+
+// Put puts variant of tile z/x/y data to cache evicting least recently used tiles if cache is full.
+func (c *Tiles) Put(z, x, y int, variant string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := tile{z, x, y}
+	if e, ok := c.entries[t][variant]; ok {
+		c.remove(e)
+	}
+
+	vs, ok := c.entries[t]
+	if !ok {
+		vs = make(map[string]*list.Element)
+		c.entries[t] = vs
+	}
+	vs[variant] = c.lru.PushFront(&tileEntry{tile: t, variant: variant, data: data, created: time.Now()})
+
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+// Len returns the number of cached tiles.
+func (c *Tiles) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Purge removes all cached tiles.
+func (c *Tiles) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lru.Init()
+	c.entries = make(map[tile]map[string]*list.Element)
+}
+
+// Update invalidates tiles containing location of station updated by feed f.
+func (c *Tiles) Update(f db.Feed) {
+	if len(f.Measurements) == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.lru.Len() == 0 {
+		return
+	}
+
+	// Tiles include features within buffer around their bounds
+	margin := float64(db.TileBuffer) / db.TileExtent
+	for z := 0; z <= db.MaxTileZoom; z++ {
+		fx, fy := tileCoords(f.Location.X, f.Location.Y, z)
+		n := 1 << uint(z)
+		for x := int(math.Floor(fx - margin)); x <= int(math.Floor(fx+margin)); x++ {
+			for y := int(math.Floor(fy - margin)); y <= int(math.Floor(fy+margin)); y++ {
+				if x < 0 || y < 0 || x >= n || y >= n {
+					continue
+				}
+				for _, e := range c.entries[tile{z, x, y}] {
+					c.remove(e)
+				}
+			}
+		}
+	}
+}
+
+func (c *Tiles) remove(e *list.Element) {
+	te := c.lru.Remove(e).(*tileEntry)
+	vs := c.entries[te.tile]
+	delete(vs, te.variant)
+	if len(vs) == 0 {
+		delete(c.entries, te.tile)
+	}
+}
+
+// tileCoords returns fractional coordinates of tile containing point (lon, lat) at zoom level z.
+func tileCoords(lon, lat float64, z int) (float64, float64) {
+	n := math.Exp2(float64(z))
+	lat = math.Max(math.Min(lat, 85.0511287798), -85.0511287798) * math.Pi / 180
+	x := (lon + 180) / 360 * n
+	y := (1 - math.Log(math.Tan(lat)+1/math.Cos(lat))/math.Pi) / 2 * n
+	return x, y
+}
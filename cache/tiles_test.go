@@ -0,0 +1,100 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cridenour/go-postgis"
+
+	"github.com/openairtech/apiserver/db"
+)
+
+func TestTiles_LRU(t *testing.T) {
+	c := NewTiles(2, time.Minute)
+	c.Put(1, 0, 0, "a", []byte{1})
+	c.Put(1, 0, 0, "b", []byte{2})
+	c.Get(1, 0, 0, "a")
+	c.Put(1, 1, 0, "a", []byte{3})
+
+	if _, ok := c.Get(1, 0, 0, "b"); ok {
+		t.Errorf("least recently used tile is not evicted")
+	}
+	if d, ok := c.Get(1, 0, 0, "a"); !ok || d[0] != 1 {
+		t.Errorf("Get() = %v, %v, want [1], true", d, ok)
+	}
+	if c.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", c.Len())
+	}
+}
+
+func TestTiles_TTL(t *testing.T) {
+	c := NewTiles(10, time.Millisecond)
+	c.Put(1, 0, 0, "", []byte{1})
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := c.Get(1, 0, 0, ""); ok || c.Len() != 0 {
+		t.Errorf("expired tile is not removed")
+	}
+}
+
+func TestTiles_Purge(t *testing.T) {
+	c := NewTiles(10, time.Minute)
+	c.Put(1, 0, 0, "a", []byte{1})
+	c.Put(2, 1, 1, "b", []byte{2})
+	c.Purge()
+	if _, ok := c.Get(1, 0, 0, "a"); ok || c.Len() != 0 {
+		t.Errorf("tiles are not removed by Purge(), Len() = %d", c.Len())
+	}
+	c.Put(1, 0, 0, "a", []byte{3})
+	if d, ok := c.Get(1, 0, 0, "a"); !ok || d[0] != 3 {
+		t.Errorf("Get() after Purge() = %v, %v, want [3], true", d, ok)
+	}
+}
+
+func TestTiles_Update(t *testing.T) {
+	c := NewTiles(100, time.Minute)
+
+	// Tiles containing Volgograd (44.5, 48.7) at zoom levels 0, 10 and some other tiles
+	c.Put(0, 0, 0, "", nil)
+	c.Put(10, 638, 352, "a", nil)
+	c.Put(10, 638, 352, "b", nil)
+	c.Put(10, 100, 100, "", nil)
+	c.Put(10, 640, 352, "", nil)
+
+	c.Update(db.Feed{
+		Location:     postgis.PointS{SRID: 4326, X: 44.5, Y: 48.7},
+		Measurements: []db.Measurement{{}},
+	})
+
+	for _, tt := range []struct {
+		z, x, y int
+		variant string
+		want    bool
+	}{
+		{0, 0, 0, "", false},
+		{10, 638, 352, "a", false},
+		{10, 638, 352, "b", false},
+		{10, 100, 100, "", true},
+		{10, 640, 352, "", true},
+	} {
+		if _, ok := c.Get(tt.z, tt.x, tt.y, tt.variant); ok != tt.want {
+			t.Errorf("tile %d/%d/%d variant %q cached = %v, want %v", tt.z, tt.x, tt.y, tt.variant, ok, tt.want)
+		}
+	}
+	if c.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", c.Len())
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	dbpkg "github.com/openairtech/apiserver/db"
+)
+
+const (
+	FlagAreasNameProperty = "name-property"
+	FlagAreasReplace      = "replace"
+)
+
+var (
+	areasNameProperty string
+	areasReplace      bool
+)
+
+func newAreasCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "areas",
+		Short: "Manage named areas used to filter stations",
+	}
+
+	importCmd := &cobra.Command{
+		Use:   "import FILE",
+		Short: "Import area boundaries from GeoJSON file",
+		Long: "Import area boundaries from GeoJSON feature collection file (\"-\" for standard input).\n" +
+			"Every feature must have Polygon or MultiPolygon geometry and name property, " +
+			"other feature properties are stored along with area.",
+		Args:         cobra.ExactArgs(1),
+		RunE:         runAreasImportCmd,
+		SilenceUsage: true,
+	}
+	f := importCmd.Flags()
+	f.StringVar(&areasNameProperty, FlagAreasNameProperty, "name", "feature property to get area name from")
+	f.BoolVar(&areasReplace, FlagAreasReplace, false, "remove areas that are not in imported file")
+
+	cmd.AddCommand(importCmd)
+
+	return cmd
+}
+
+func runAreasImportCmd(cmd *cobra.Command, args []string) error {
+	var r io.Reader = os.Stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	abs, err := readAreaBoundaries(r, areasNameProperty)
+	if err != nil {
+		return err
+	}
+
+	db, err := connectDb(cmd)
+	if err != nil {
+		return fmt.Errorf("can't connect to database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.ImportAreas(context.Background(), abs, areasReplace); err != nil {
+		return fmt.Errorf("can't import areas: %v", err)
+	}
+
+	log.Infof("imported %d area(s)", len(abs))
+
+	return nil
+}
+
+type areaFeature struct {
+	Type       string                     `json:"type"`
+	Geometry   json.RawMessage            `json:"geometry"`
+	Properties map[string]json.RawMessage `json:"properties"`
+}
+
+// readAreaBoundaries reads area boundaries from GeoJSON feature collection, area names are taken
+// from feature property nameProperty.
+func readAreaBoundaries(r io.Reader, nameProperty string) ([]dbpkg.AreaBoundary, error) {
+	var fc struct {
+		Type     string        `json:"type"`
+		Features []areaFeature `json:"features"`
+	}
+	if err := json.NewDecoder(r).Decode(&fc); err != nil {
+		return nil, fmt.Errorf("can't parse GeoJSON: %v", err)
+	}
+	if fc.Type != "FeatureCollection" {
+		return nil, fmt.Errorf("GeoJSON must be FeatureCollection, not %s", fc.Type)
+	}
+
+	names := make(map[string]bool)
+	var abs []dbpkg.AreaBoundary
+	for i, f := range fc.Features {
+		var name string
+		if err := json.Unmarshal(f.Properties[nameProperty], &name); err != nil || name == "" {
+			return nil, fmt.Errorf("feature #%d has no %q string property", i, nameProperty)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("feature #%d name %q is duplicated", i, name)
+		}
+		names[name] = true
+
+		var g struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(f.Geometry, &g); err != nil || (g.Type != "Polygon" && g.Type != "MultiPolygon") {
+			return nil, fmt.Errorf("feature %q geometry must be Polygon or MultiPolygon", name)
+		}
+
+		props, err := json.Marshal(f.Properties)
+		if err != nil {
+			return nil, err
+		}
+
+		abs = append(abs, dbpkg.AreaBoundary{Name: name, Properties: props, GeoJSON: f.Geometry})
+	}
+
+	return abs, nil
+}
@@ -0,0 +1,60 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadAreaBoundaries(t *testing.T) {
+	const polygon = `{"type": "Polygon", "coordinates": [[[44.4, 48.6], [44.6, 48.6], [44.6, 48.8], [44.4, 48.6]]]}`
+	tests := []struct {
+		name    string
+		geojson string
+		want    []string
+		wantErr bool
+	}{
+		{name: "valid", geojson: `{"type": "FeatureCollection", "features": [
+			{"type": "Feature", "geometry": ` + polygon + `, "properties": {"name": "Central", "okato": "18401"}},
+			{"type": "Feature", "geometry": ` + polygon + `, "properties": {"name": "Voroshilovsky"}}]}`,
+			want: []string{"Central", "Voroshilovsky"}},
+		{name: "no name", geojson: `{"type": "FeatureCollection", "features": [
+			{"type": "Feature", "geometry": ` + polygon + `, "properties": {"title": "Central"}}]}`, wantErr: true},
+		{name: "duplicate name", geojson: `{"type": "FeatureCollection", "features": [
+			{"type": "Feature", "geometry": ` + polygon + `, "properties": {"name": "Central"}},
+			{"type": "Feature", "geometry": ` + polygon + `, "properties": {"name": "Central"}}]}`, wantErr: true},
+		{name: "point", geojson: `{"type": "FeatureCollection", "features": [
+			{"type": "Feature", "geometry": {"type": "Point", "coordinates": [44.5, 48.7]},
+			"properties": {"name": "Central"}}]}`, wantErr: true},
+		{name: "not collection", geojson: polygon, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			abs, err := readAreaBoundaries(strings.NewReader(tt.geojson), "name")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readAreaBoundaries() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(abs) != len(tt.want) {
+				t.Fatalf("readAreaBoundaries() = %d areas, want %d", len(abs), len(tt.want))
+			}
+			for i, ab := range abs {
+				if ab.Name != tt.want[i] || !strings.Contains(string(ab.GeoJSON), "Polygon") {
+					t.Errorf("readAreaBoundaries() area #%d = %s, %s", i, ab.Name, ab.GeoJSON)
+				}
+			}
+		})
+	}
+}
@@ -25,8 +25,12 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 
+	"github.com/openairtech/apiserver/cache"
 	dbpkg "github.com/openairtech/apiserver/db"
+	"github.com/openairtech/apiserver/event"
 	"github.com/openairtech/apiserver/http"
+	"github.com/openairtech/apiserver/ingest"
+	"github.com/openairtech/apiserver/monitor"
 )
 
 const (
@@ -34,15 +38,50 @@ const (
 	FlagGracefulTimeout = "graceful-timeout"
 	FlagDebug           = "debug"
 
-	FlagDbHost     = "db-host"
-	FlagDbPort     = "db-port"
-	FlagDbUser     = "db-user"
-	FlagDbPassword = "db-pass"
-	FlagDbName     = "db-name"
-	FlagDbMaxConn  = "db-max-conn"
+	FlagDbUrl              = "db-url"
+	FlagDbHost             = "db-host"
+	FlagDbPort             = "db-port"
+	FlagDbUser             = "db-user"
+	FlagDbPassword         = "db-pass"
+	FlagDbName             = "db-name"
+	FlagDbSslMode          = "db-sslmode"
+	FlagDbSslRootCert      = "db-sslrootcert"
+	FlagDbSslCert          = "db-sslcert"
+	FlagDbSslKey           = "db-sslkey"
+	FlagDbAppName          = "db-app-name"
+	FlagDbConnectTimeout   = "db-connect-timeout"
+	FlagDbStatementTimeout = "db-statement-timeout"
+	FlagDbMaxConn          = "db-max-conn"
+	FlagDbMaxIdleConn      = "db-max-idle-conn"
+	FlagDbConnMaxLifetime  = "db-conn-max-lifetime"
+	FlagDbConnMaxIdleTime  = "db-conn-max-idle-time"
+	FlagDbQueryTimeout     = "db-query-timeout"
+	FlagDbWriteTimeout     = "db-write-timeout"
+
+	FlagDbReplica              = "db-replica"
+	FlagDbReplicaMaxLag        = "db-replica-max-lag"
+	FlagDbReplicaCheckInterval = "db-replica-check-interval"
+	FlagDbNotify               = "db-notify"
 
 	FlagHttpHost = "http-host"
 	FlagHttpPort = "http-port"
+
+	FlagAdminPort = "admin-port"
+
+	FlagIngestQueueSize      = "ingest-queue-size"
+	FlagIngestSpoolDir       = "ingest-spool-dir"
+	FlagIngestWorkers        = "ingest-workers"
+	FlagIngestBatchSize      = "ingest-batch-size"
+	FlagIngestFlushInterval  = "ingest-flush-interval"
+	FlagIngestEnqueueTimeout = "ingest-enqueue-timeout"
+
+	FlagStationsCacheRefresh = "stations-cache-refresh"
+	FlagTilesCacheSize       = "tiles-cache-size"
+	FlagTilesCacheTtl        = "tiles-cache-ttl"
+
+	FlagMonitorInterval      = "monitor-interval"
+	FlagMonitorLearnInterval = "monitor-learn-interval"
+	FlagMonitorHistory       = "monitor-history"
 )
 
 var (
@@ -51,11 +90,19 @@ var (
 )
 
 var (
-	debug                              bool
-	gracefulTimeout                    time.Duration
-	dbHost, dbUser, dbPassword, dbName string
-	httpHost                           string
-	dbPort, dbMaxConn, httpPort        int
+	debug           bool
+	gracefulTimeout time.Duration
+	dbCfg           dbpkg.Config
+	httpHost        string
+	httpPort        int
+	adminPort       int
+	ingestCfg       ingest.Config
+
+	stationsCacheRefresh time.Duration
+	tilesCacheSize       int
+	tilesCacheTtl        time.Duration
+
+	monitorCfg monitor.Config
 )
 
 func NewCmd() *cobra.Command {
@@ -65,6 +112,10 @@ func NewCmd() *cobra.Command {
 		Run:  runCmd,
 	}
 	initCmd(cmd)
+	cmd.AddCommand(newAreasCmd())
+	cmd.AddCommand(newExportCmd())
+	cmd.AddCommand(newImportCmd())
+	cmd.AddCommand(newStationsCmd())
 	return cmd
 }
 
@@ -73,17 +124,78 @@ func initCmd(cmd *cobra.Command) {
 	f := cmd.Flags()
 	f.BoolP(FlagVersion, "V", false, "display the build number and timestamp")
 	f.DurationVarP(&gracefulTimeout, FlagGracefulTimeout, "T", time.Second*15, "graceful shutdown timeout")
-	f.BoolVarP(&debug, FlagDebug, "d", false, "enable debug logging")
 
-	f.StringVarP(&dbHost, FlagDbHost, "H", "localhost", "database server host")
-	f.IntVarP(&dbPort, FlagDbPort, "P", 5432, "database server port")
-	f.StringVarP(&dbUser, FlagDbUser, "U", "openair", "database user name")
-	f.StringVarP(&dbPassword, FlagDbPassword, "W", "openair", "database user password")
-	f.StringVarP(&dbName, FlagDbName, "D", "openair", "database name to connect to")
-	f.IntVarP(&dbMaxConn, FlagDbMaxConn, "M", 0, "database maximum number of open connections (0 for unlimited)")
+	// Flags shared with subcommands
+	pf := cmd.PersistentFlags()
+	pf.BoolVarP(&debug, FlagDebug, "d", false, "enable debug logging")
+
+	pf.StringVar(&dbCfg.URL, FlagDbUrl, "", "database connection URL or DSN "+
+		"(other database connection flags override its parameters only if set explicitly)")
+	pf.StringVarP(&dbCfg.Host, FlagDbHost, "H", "localhost", "database server host")
+	pf.IntVarP(&dbCfg.Port, FlagDbPort, "P", 5432, "database server port")
+	pf.StringVarP(&dbCfg.User, FlagDbUser, "U", "openair", "database user name")
+	pf.StringVarP(&dbCfg.Password, FlagDbPassword, "W", "openair", "database user password")
+	pf.StringVarP(&dbCfg.Name, FlagDbName, "D", "openair", "database name to connect to")
+	pf.StringVar(&dbCfg.SSLMode, FlagDbSslMode, "disable",
+		"database connection SSL mode (disable, require, verify-ca or verify-full)")
+	pf.StringVar(&dbCfg.SSLRootCert, FlagDbSslRootCert, "", "database server root certificate file")
+	pf.StringVar(&dbCfg.SSLCert, FlagDbSslCert, "", "database client certificate file")
+	pf.StringVar(&dbCfg.SSLKey, FlagDbSslKey, "", "database client private key file")
+	pf.StringVar(&dbCfg.ApplicationName, FlagDbAppName, "openair-apiserver", "database connection application name")
+	pf.DurationVar(&dbCfg.ConnectTimeout, FlagDbConnectTimeout, 0, "database connection timeout (0 for no timeout)")
+	pf.DurationVar(&dbCfg.StatementTimeout, FlagDbStatementTimeout, 0,
+		"database statement timeout (0 for server default)")
+	pf.IntVarP(&dbCfg.MaxOpenConns, FlagDbMaxConn, "M", 0,
+		"database maximum number of open connections (0 for unlimited)")
+	pf.IntVar(&dbCfg.MaxIdleConns, FlagDbMaxIdleConn, 2,
+		"database maximum number of idle connections (0 to keep no idle connections)")
+	pf.DurationVar(&dbCfg.ConnMaxLifetime, FlagDbConnMaxLifetime, 0,
+		"database connection maximum lifetime (0 for unlimited)")
+	pf.DurationVar(&dbCfg.ConnMaxIdleTime, FlagDbConnMaxIdleTime, 0,
+		"database connection maximum idle time (0 for unlimited)")
+	pf.DurationVar(&dbCfg.QueryTimeout, FlagDbQueryTimeout, 30*time.Second,
+		"database query operation timeout (0 for no timeout)")
+	pf.DurationVar(&dbCfg.WriteTimeout, FlagDbWriteTimeout, 10*time.Second,
+		"database write operation timeout (0 for no timeout)")
+
+	pf.StringSliceVar(&dbCfg.Replicas, FlagDbReplica, nil, "database read replica connection URL or DSN "+
+		"to use for station and measurement queries (can be repeated)")
+	pf.DurationVar(&dbCfg.ReplicaMaxLag, FlagDbReplicaMaxLag, 30*time.Second,
+		"database read replica maximum replication lag to fall back to primary (0 for unlimited)")
+	pf.DurationVar(&dbCfg.ReplicaCheckInterval, FlagDbReplicaCheckInterval, 10*time.Second,
+		"database read replica health check interval")
+	pf.BoolVar(&dbCfg.Notify, FlagDbNotify, false, "notify other API server instances sharing the database "+
+		"of added measurements and listen for their notifications to update live streams and caches")
 
 	f.StringVarP(&httpHost, FlagHttpHost, "s", "localhost", "HTTP server host")
 	f.IntVarP(&httpPort, FlagHttpPort, "p", 8081, "HTTP server port")
+
+	f.IntVar(&adminPort, FlagAdminPort, 0,
+		"admin HTTP server port on localhost to publish server metrics at /debug/vars (0 to disable)")
+
+	f.IntVar(&ingestCfg.Size, FlagIngestQueueSize, 0,
+		"ingestion queue size to write station feeds asynchronously (0 to write them synchronously)")
+	f.StringVar(&ingestCfg.SpoolDir, FlagIngestSpoolDir, "",
+		"ingestion queue spool directory to keep queued feeds across restarts (in-memory queue if not set)")
+	f.IntVar(&ingestCfg.Workers, FlagIngestWorkers, 2, "ingestion queue database writer workers number")
+	f.IntVar(&ingestCfg.BatchSize, FlagIngestBatchSize, 100,
+		"ingestion queue maximum number of feeds written to database at once")
+	f.DurationVar(&ingestCfg.FlushInterval, FlagIngestFlushInterval, time.Second,
+		"ingestion queue maximum time to wait for a batch of feeds to fill up")
+	f.DurationVar(&ingestCfg.EnqueueTimeout, FlagIngestEnqueueTimeout, 5*time.Second,
+		"ingestion queue maximum time to wait for a free queue slot before rejecting a feed")
+
+	f.DurationVar(&stationsCacheRefresh, FlagStationsCacheRefresh, 5*time.Minute,
+		"stations cache reload interval (0 to disable stations cache)")
+	f.IntVar(&tilesCacheSize, FlagTilesCacheSize, 1000, "vector tiles cache size (0 to disable tiles cache)")
+	f.DurationVar(&tilesCacheTtl, FlagTilesCacheTtl, time.Minute, "vector tiles cache expiration time")
+
+	f.DurationVar(&monitorCfg.CheckInterval, FlagMonitorInterval, 0,
+		"stations status check interval, 0 to disable stations monitor (enable it on a single instance only)")
+	f.DurationVar(&monitorCfg.LearnInterval, FlagMonitorLearnInterval, time.Hour,
+		"stations reporting intervals update interval")
+	f.DurationVar(&monitorCfg.History, FlagMonitorHistory, 72*time.Hour,
+		"period of measurements to learn stations reporting intervals from")
 }
 
 func runCmd(cmd *cobra.Command, _ []string) {
@@ -93,23 +205,93 @@ func runCmd(cmd *cobra.Command, _ []string) {
 		return
 	}
 
-	if debug {
-		log.SetLevel(log.DebugLevel)
-	}
-
-	log.Debug("connecting to database...")
-	db, err := dbpkg.NewDb(dbHost, dbPort, dbUser, dbPassword, dbName, dbMaxConn)
+	db, err := connectDb(cmd)
 	if err != nil {
 		log.Errorf("can't connect to database: %v", err)
 		return
 	}
 	defer db.Close()
 
-	s := http.NewServer(BuildVersion, BuildTimestamp, fmt.Sprintf("%s:%d", httpHost, httpPort), db)
-
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	if ok, err := db.IsStationsTableMigrated(ctx); err != nil {
+		log.Warnf("can't check stations table: %v", err)
+	} else if !ok {
+		log.Warn("stations table is not migrated, station time zones are not available: " +
+			"run 'stations migrate' command")
+	}
+
+	bus := event.NewBus()
+
+	var sc *cache.Stations
+	if stationsCacheRefresh > 0 {
+		sc = cache.NewStations()
+		bus.Subscribe(sc.Update)
+		go sc.Run(ctx, db, stationsCacheRefresh)
+	}
+
+	var tc *cache.Tiles
+	if tilesCacheSize > 0 {
+		tc = cache.NewTiles(tilesCacheSize, tilesCacheTtl)
+		bus.Subscribe(tc.Update)
+	}
+
+	if dbCfg.Notify {
+		go func() {
+			var reload func()
+			if sc != nil || tc != nil {
+				// Notifications might be missed while connection was lost, so reload stations cache
+				// and drop tiles that might be invalidated by missed feeds
+				reload = func() {
+					if tc != nil {
+						tc.Purge()
+					}
+					if sc == nil {
+						return
+					}
+					if err := sc.Load(ctx, db); err != nil && ctx.Err() == nil {
+						log.Errorf("can't load stations to cache: %v", err)
+					}
+				}
+			}
+			if err := event.Relay(ctx, db, bus, reload); err != nil {
+				log.Errorf("can't listen for database notifications: %v", err)
+			}
+		}()
+	}
+
+	if monitorCfg.CheckInterval > 0 {
+		go monitor.NewMonitor(db, monitorCfg).Run(ctx)
+	}
+
+	var q *ingest.Queue
+	if ingestCfg.Size > 0 {
+		if q, err = ingest.NewQueue(db, ingestCfg, bus); err != nil {
+			log.Errorf("can't create ingestion queue: %v", err)
+			return
+		}
+		q.Start()
+		defer func() {
+			log.Info("stopping ingestion queue")
+			ctx, cancel := context.WithTimeout(context.Background(), gracefulTimeout)
+			defer cancel()
+			q.Close(ctx)
+		}()
+	}
+
+	if adminPort > 0 {
+		as := http.NewAdminServer(adminPort)
+		go func() {
+			if err := as.Run(); err != nil {
+				log.Errorf("admin server error: %v", err)
+			}
+		}()
+		defer as.Close()
+	}
+
+	s := http.NewServer(BuildVersion, BuildTimestamp, fmt.Sprintf("%s:%d", httpHost, httpPort), db, q, bus, sc, tc)
+
 	go func() {
 		log.Info("starting server")
 		if err := s.Run(); err != nil {
@@ -129,7 +311,8 @@ func runCmd(cmd *cobra.Command, _ []string) {
 	select {
 	case <-c:
 		log.Info("stopping server")
-		ctx, _ := context.WithTimeout(ctx, gracefulTimeout)
+		ctx, cancel := context.WithTimeout(ctx, gracefulTimeout)
+		defer cancel()
 		if err := s.Shutdown(ctx); err != nil {
 			log.Errorf("can't shutdown server: %v", err)
 		}
@@ -139,3 +322,42 @@ func runCmd(cmd *cobra.Command, _ []string) {
 
 	log.Info("server stopped")
 }
+
+// connectDb sets up logging and connects to database according to command flags.
+func connectDb(cmd *cobra.Command) (*dbpkg.Db, error) {
+	if debug {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	log.Debug("connecting to database...")
+	return dbpkg.NewDb(dbConfig(cmd))
+}
+
+// dbConfig returns database config from command flags. If database URL is set,
+// connection flags that are not set explicitly are cleared so that they don't override URL parameters.
+func dbConfig(cmd *cobra.Command) dbpkg.Config {
+	cfg := dbCfg
+	if cfg.URL == "" {
+		return cfg
+	}
+	f := cmd.Flags()
+	for _, fv := range []struct {
+		name string
+		s    *string
+	}{
+		{FlagDbHost, &cfg.Host},
+		{FlagDbUser, &cfg.User},
+		{FlagDbPassword, &cfg.Password},
+		{FlagDbName, &cfg.Name},
+		{FlagDbSslMode, &cfg.SSLMode},
+		{FlagDbAppName, &cfg.ApplicationName},
+	} {
+		if !f.Changed(fv.name) {
+			*fv.s = ""
+		}
+	}
+	if !f.Changed(FlagDbPort) {
+		cfg.Port = 0
+	}
+	return cfg
+}
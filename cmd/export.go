@@ -0,0 +1,382 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	dbpkg "github.com/openairtech/apiserver/db"
+	"github.com/openairtech/apiserver/parquet"
+)
+
+const (
+	FlagExportFrom     = "from"
+	FlagExportTo       = "to"
+	FlagExportStations = "stations"
+	FlagExportOut      = "out"
+
+	exportManifestFile = "manifest.json"
+	exportDataFile     = "measurements.parquet"
+)
+
+var (
+	exportFrom     string
+	exportTo       string
+	exportStations []int
+	exportOut      string
+)
+
+// exportColumns are columns of exported measurements Parquet files. Station ID and month are
+// not stored in files since they are Hive-style partition keys of file paths.
+var exportColumns = []parquet.Column{
+	{Name: "timestamp", Type: parquet.Timestamp},
+	{Name: "temperature", Type: parquet.Double, Optional: true},
+	{Name: "humidity", Type: parquet.Double, Optional: true},
+	{Name: "pressure", Type: parquet.Double, Optional: true},
+	{Name: "pm25", Type: parquet.Double, Optional: true},
+	{Name: "pm10", Type: parquet.Double, Optional: true},
+	{Name: "aqi", Type: parquet.Int32, Optional: true},
+	{Name: "station_description", Type: parquet.String, Optional: true},
+	{Name: "longitude", Type: parquet.Double},
+	{Name: "latitude", Type: parquet.Double},
+}
+
+func newExportCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export measurements for offline analysis",
+	}
+
+	parquetCmd := &cobra.Command{
+		Use:   "parquet",
+		Short: "Export measurements to Parquet files partitioned by month and station",
+		Long: "Export measurements along with station metadata to Parquet files partitioned by month and station\n" +
+			"(OUT/month=YYYY-MM/station_id=ID/" + exportDataFile + "), readable by DuckDB or Spark as Hive-partitioned\n" +
+			"dataset. Exported partitions are listed in " + exportManifestFile + " file, so interrupted export\n" +
+			"is resumed by running it again with the same parameters.",
+		Args:         cobra.NoArgs,
+		RunE:         runExportParquetCmd,
+		SilenceUsage: true,
+	}
+	f := parquetCmd.Flags()
+	f.StringVar(&exportFrom, FlagExportFrom, "", "start time of measurements (YYYY-MM, YYYY-MM-DD or RFC 3339)")
+	f.StringVar(&exportTo, FlagExportTo, "", "end time of measurements, exclusive (default now)")
+	f.IntSliceVar(&exportStations, FlagExportStations, nil, "IDs of stations to export (default all)")
+	f.StringVarP(&exportOut, FlagExportOut, "o", "export", "output directory")
+	_ = parquetCmd.MarkFlagRequired(FlagExportFrom)
+
+	cmd.AddCommand(parquetCmd)
+
+	return cmd
+}
+
+// exportManifest describes exported dataset and its partitions.
+type exportManifest struct {
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Stations []int     `json:"stations,omitempty"`
+	// Complete is set when all partitions are exported
+	Complete   bool              `json:"complete"`
+	Updated    time.Time         `json:"updated"`
+	Partitions []exportPartition `json:"partitions"`
+}
+
+// exportPartition is an exported partition of measurements of single station within single month.
+type exportPartition struct {
+	StationId int    `json:"station_id"`
+	Month     string `json:"month"`
+	// Path is a partition file path relative to output directory, empty if there are no measurements
+	Path string `json:"path,omitempty"`
+	Rows int64  `json:"rows"`
+	Size int64  `json:"size"`
+}
+
+func (p exportPartition) key() string {
+	return fmt.Sprintf("%s/%d", p.Month, p.StationId)
+}
+
+// exportMonth is a month time range [from, to) of exported partitions.
+type exportMonth struct {
+	name     string
+	from, to time.Time
+}
+
+func runExportParquetCmd(cmd *cobra.Command, args []string) error {
+	from, err := parseExportTime(exportFrom)
+	if err != nil {
+		return err
+	}
+	var to *time.Time
+	if exportTo != "" {
+		t, err := parseExportTime(exportTo)
+		if err != nil {
+			return err
+		}
+		to = &t
+	}
+
+	if err := os.MkdirAll(exportOut, 0755); err != nil {
+		return err
+	}
+
+	m, err := loadExportManifest(filepath.Join(exportOut, exportManifestFile), from, to, exportStations)
+	if err != nil {
+		return err
+	}
+	if !m.From.Before(m.To) {
+		return errors.New("export start time must be before end time")
+	}
+	done := make(map[string]bool, len(m.Partitions))
+	for _, p := range m.Partitions {
+		done[p.key()] = true
+	}
+
+	db, err := connectDb(cmd)
+	if err != nil {
+		return fmt.Errorf("can't connect to database: %v", err)
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ss, err := db.StationsById(ctx, exportStations)
+	if err != nil {
+		return fmt.Errorf("can't get stations: %v", err)
+	}
+	if ids := missingStations(exportStations, ss); len(ids) > 0 {
+		return fmt.Errorf("stations not found: %v", ids)
+	}
+
+	for _, s := range ss {
+		for _, em := range exportMonths(m.From, m.To) {
+			p := exportPartition{StationId: s.Id, Month: em.name}
+			if done[p.key()] {
+				continue
+			}
+
+			if p, err = exportStationMonth(ctx, db, s, em, exportOut); err != nil {
+				return fmt.Errorf("can't export station %d measurements of %s: %v", s.Id, em.name, err)
+			}
+			if p.Rows > 0 {
+				log.Infof("exported %d measurement(s) to %s", p.Rows, p.Path)
+			}
+
+			m.Partitions = append(m.Partitions, p)
+			if err := saveExportManifest(filepath.Join(exportOut, exportManifestFile), m); err != nil {
+				return err
+			}
+		}
+	}
+
+	m.Complete = true
+	if err := saveExportManifest(filepath.Join(exportOut, exportManifestFile), m); err != nil {
+		return err
+	}
+
+	log.Infof("exported %d partition(s) to %s", len(m.Partitions), exportOut)
+
+	return nil
+}
+
+// exportStationMonth exports measurements of station s within month em to partition file in directory out.
+// missingStations returns IDs of ids that are not IDs of stations ss.
+func missingStations(ids []int, ss []dbpkg.Station) []int {
+	found := make(map[int]bool, len(ss))
+	for _, s := range ss {
+		found[s.Id] = true
+	}
+	var missing []int
+	for _, id := range ids {
+		if !found[id] {
+			missing = append(missing, id)
+		}
+	}
+	return missing
+}
+
+// Partition file is written under temporary name and renamed when complete, so incomplete files
+// of interrupted export are never taken as exported ones.
+func exportStationMonth(ctx context.Context, db *dbpkg.Db, s dbpkg.Station, em exportMonth,
+	out string) (exportPartition, error) {
+	p := exportPartition{
+		StationId: s.Id,
+		Month:     em.name,
+		Path:      filepath.Join(fmt.Sprintf("month=%s", em.name), fmt.Sprintf("station_id=%d", s.Id), exportDataFile),
+	}
+	path := filepath.Join(out, p.Path)
+	tmp := path + ".tmp"
+
+	var f *os.File
+	var bw *bufio.Writer
+	var pw *parquet.Writer
+	defer func() {
+		if f != nil {
+			_ = f.Close()
+			_ = os.Remove(tmp)
+		}
+	}()
+
+	var description interface{}
+	if s.Description.Valid {
+		description = s.Description.String
+	}
+
+	// Measurements range is inclusive, so measurements taken at the start of the next month are skipped
+	err := db.EachMeasurement(ctx, s.Id, em.from, em.to, nil, func(m *dbpkg.Measurement) error {
+		if m.Timestamp == nil || !m.Timestamp.Before(em.to) {
+			return nil
+		}
+		if pw == nil {
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			var err error
+			if f, err = os.Create(tmp); err != nil {
+				return err
+			}
+			bw = bufio.NewWriter(f)
+			if pw, err = parquet.NewWriter(bw, exportColumns); err != nil {
+				return err
+			}
+		}
+		var aqi interface{}
+		if m.Aqi.Valid {
+			aqi = int32(m.Aqi.Int64)
+		}
+		return pw.Write(*m.Timestamp, nullFloat(m.Temperature), nullFloat(m.Humidity), nullFloat(m.Pressure),
+			nullFloat(m.Pm25), nullFloat(m.Pm10), aqi, description, s.Location.X, s.Location.Y)
+	})
+	if err != nil {
+		return p, err
+	}
+
+	if pw == nil {
+		// No measurements
+		p.Path = ""
+		return p, nil
+	}
+
+	p.Rows = pw.NumRows()
+	if err := pw.Close(); err != nil {
+		return p, err
+	}
+	if err := bw.Flush(); err != nil {
+		return p, err
+	}
+	if err := f.Sync(); err != nil {
+		return p, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return p, err
+	}
+	p.Size = fi.Size()
+	if err := f.Close(); err != nil {
+		return p, err
+	}
+	f = nil
+
+	return p, os.Rename(tmp, path)
+}
+
+func nullFloat(nf sql.NullFloat64) interface{} {
+	if !nf.Valid {
+		return nil
+	}
+	return nf.Float64
+}
+
+// exportMonths splits time range [from, to) into UTC calendar months.
+func exportMonths(from, to time.Time) []exportMonth {
+	var ems []exportMonth
+	from, to = from.UTC(), to.UTC()
+	for t := from; t.Before(to); {
+		next := time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		if next.After(to) {
+			next = to
+		}
+		ems = append(ems, exportMonth{name: t.Format("2006-01"), from: t, to: next})
+		t = next
+	}
+	return ems
+}
+
+// parseExportTime parses time in YYYY-MM, YYYY-MM-DD (both UTC) or RFC 3339 format.
+func parseExportTime(s string) (time.Time, error) {
+	for _, l := range []string{"2006-01", "2006-01-02", time.RFC3339} {
+		if t, err := time.Parse(l, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time: %s", s)
+}
+
+// loadExportManifest loads manifest of previous export from file path, if it exists, or creates a new one.
+// Previous export must have the same parameters to resume it, except for end time to: if it is nil,
+// end time of previous export or current time for a new one is used.
+func loadExportManifest(path string, from time.Time, to *time.Time, stations []int) (*exportManifest, error) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		m := &exportManifest{From: from, To: time.Now().UTC(), Stations: stations}
+		if to != nil {
+			m.To = *to
+		}
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var pm exportManifest
+	if err := json.Unmarshal(b, &pm); err != nil {
+		return nil, fmt.Errorf("can't parse export manifest %s: %v", path, err)
+	}
+	if !pm.From.Equal(from) || (to != nil && !pm.To.Equal(*to)) || !reflect.DeepEqual(pm.Stations, stations) {
+		return nil, fmt.Errorf("output directory contains export with other parameters "+
+			"(from %s to %s, stations %v)", pm.From.Format(time.RFC3339), pm.To.Format(time.RFC3339), pm.Stations)
+	}
+	pm.Complete = false
+
+	return &pm, nil
+}
+
+// saveExportManifest atomically writes manifest m to file path.
+func saveExportManifest(path string, m *exportManifest) error {
+	m.Updated = time.Now().UTC()
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,108 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	dbpkg "github.com/openairtech/apiserver/db"
+)
+
+func TestExportMonths(t *testing.T) {
+	from := time.Date(2019, 11, 15, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	ems := exportMonths(from, to)
+	want := []string{"2019-11", "2019-12", "2020-01"}
+	if len(ems) != len(want) {
+		t.Fatalf("exportMonths() = %d months, want %d", len(ems), len(want))
+	}
+	for i, em := range ems {
+		if em.name != want[i] {
+			t.Errorf("month #%d = %s, want %s", i, em.name, want[i])
+		}
+	}
+	if !ems[0].from.Equal(from) || !ems[0].to.Equal(time.Date(2019, 12, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("first month range = [%v, %v)", ems[0].from, ems[0].to)
+	}
+	if !ems[2].to.Equal(to) {
+		t.Errorf("last month end = %v, want %v", ems[2].to, to)
+	}
+}
+
+func TestMissingStations(t *testing.T) {
+	ss := []dbpkg.Station{{Id: 1}, {Id: 3}}
+	if got := missingStations([]int{1, 2, 3, 4}, ss); !reflect.DeepEqual(got, []int{2, 4}) {
+		t.Errorf("missingStations() = %v, want [2 4]", got)
+	}
+	if got := missingStations([]int{3, 1}, ss); got != nil {
+		t.Errorf("missingStations() = %v, want none", got)
+	}
+}
+
+func TestParseExportTime(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    time.Time
+		wantErr bool
+	}{
+		{s: "2019-05", want: time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)},
+		{s: "2019-05-12", want: time.Date(2019, 5, 12, 0, 0, 0, 0, time.UTC)},
+		{s: "2019-05-12T10:00:00+03:00", want: time.Date(2019, 5, 12, 7, 0, 0, 0, time.UTC)},
+		{s: "12.05.2019", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := parseExportTime(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseExportTime() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("parseExportTime() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadExportManifest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), exportManifestFile)
+	from := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	m, err := loadExportManifest(path, from, &to, []int{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Partitions = append(m.Partitions, exportPartition{StationId: 1, Month: "2019-01", Rows: 10})
+	if err := saveExportManifest(path, m); err != nil {
+		t.Fatal(err)
+	}
+
+	// Resume without end time set
+	m, err = loadExportManifest(path, from, nil, []int{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.To.Equal(to) || len(m.Partitions) != 1 || m.Partitions[0].key() != "2019-01/1" {
+		t.Errorf("unexpected resumed manifest: %+v", m)
+	}
+
+	if _, err := loadExportManifest(path, from, &to, []int{1}); err == nil {
+		t.Error("export with other stations is resumed")
+	}
+}
@@ -0,0 +1,422 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/openairtech/api"
+	"github.com/openairtech/apiserver/aqi"
+	dbpkg "github.com/openairtech/apiserver/db"
+)
+
+const (
+	FlagImportStation    = "station"
+	FlagImportToken      = "token"
+	FlagImportColumn     = "column"
+	FlagImportDelimiter  = "delimiter"
+	FlagImportTimeFormat = "time-format"
+	FlagImportTimezone   = "timezone"
+	FlagImportBatchSize  = "batch-size"
+
+	timeFormatAuto   = "auto"
+	timeFormatUnix   = "unix"
+	timeFormatUnixMs = "unixms"
+
+	// importMaxInvalidWarnings is the maximum number of invalid rows to warn about
+	importMaxInvalidWarnings = 10
+)
+
+var (
+	importStation    int
+	importToken      string
+	importColumns    map[string]string
+	importDelimiter  string
+	importTimeFormat string
+	importTimezone   string
+	importBatchSize  int
+)
+
+// importVars are names of measurement variables that can be imported.
+var importVars = []string{"timestamp", "temperature", "humidity", "pressure", "pm25", "pm10", "aqi"}
+
+// importTimeLayouts are layouts of timestamps tried in auto time format after Unix time.
+var importTimeLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02 15:04"}
+
+func newImportCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "Import historical measurements",
+	}
+
+	csvCmd := &cobra.Command{
+		Use:   "csv FILE",
+		Short: "Import station measurements from CSV file",
+		Long: "Import station measurements from CSV file (\"-\" for standard input) with header row.\n" +
+			"Columns are mapped to measurement variables (" + strings.Join(importVars, ", ") + ") by their names,\n" +
+			"unless mapped explicitly by --" + FlagImportColumn + " flags. AQI is computed from PM values if it is absent.\n" +
+			"Measurements that are already added are skipped.",
+		Args:         cobra.ExactArgs(1),
+		RunE:         runImportCsvCmd,
+		SilenceUsage: true,
+	}
+	f := csvCmd.Flags()
+	f.IntVar(&importStation, FlagImportStation, 0, "ID of station to import measurements of")
+	f.StringVar(&importToken, FlagImportToken, "", "token ID of station to import measurements of")
+	f.StringToStringVar(&importColumns, FlagImportColumn, nil,
+		"mapping of measurement variable to CSV column name, like pm25=\"PM2.5\" (can be repeated)")
+	f.StringVar(&importDelimiter, FlagImportDelimiter, ",", "CSV field delimiter")
+	f.StringVar(&importTimeFormat, FlagImportTimeFormat, timeFormatAuto,
+		"timestamp format: auto, unix (seconds), unixms (milliseconds) or Go time layout")
+	f.StringVar(&importTimezone, FlagImportTimezone, "UTC", "time zone of timestamps without zone offset")
+	f.IntVar(&importBatchSize, FlagImportBatchSize, 1000, "number of measurements to add in a single transaction")
+
+	cmd.AddCommand(csvCmd)
+
+	return cmd
+}
+
+func runImportCsvCmd(cmd *cobra.Command, args []string) error {
+	if (importStation == 0) == (importToken == "") {
+		return fmt.Errorf("either --%s or --%s flag must be set", FlagImportStation, FlagImportToken)
+	}
+	if importBatchSize <= 0 {
+		return fmt.Errorf("invalid batch size: %d", importBatchSize)
+	}
+	delimiter, n := utf8.DecodeRuneInString(importDelimiter)
+	if n == 0 || n != len(importDelimiter) {
+		return fmt.Errorf("delimiter must be a single character: %q", importDelimiter)
+	}
+	loc, err := time.LoadLocation(importTimezone)
+	if err != nil {
+		return fmt.Errorf("invalid time zone: %v", err)
+	}
+
+	var r io.Reader = os.Stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	mr, err := newCsvMeasurementReader(r, delimiter, importColumns, importTimeFormat, loc)
+	if err != nil {
+		return err
+	}
+
+	db, err := connectDb(cmd)
+	if err != nil {
+		return fmt.Errorf("can't connect to database: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+
+	s, err := importStationOf(ctx, db, importStation, importToken)
+	if err != nil {
+		return err
+	}
+
+	var inserted, skipped, invalid int
+	batch := make([]dbpkg.Measurement, 0, importBatchSize)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		n, err := db.AddMeasurements(ctx, s, batch)
+		if err != nil {
+			return fmt.Errorf("can't add measurements: %v", err)
+		}
+		inserted += n
+		skipped += len(batch) - n
+		log.Debugf("station [%d]: added %d measurement(s) of %d", s.Id, n, len(batch))
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		am, err := mr.Read()
+		if err == io.EOF {
+			break
+		}
+		var re *csvRowError
+		if errors.As(err, &re) {
+			invalid++
+			if invalid <= importMaxInvalidWarnings {
+				log.Warn(re)
+			} else {
+				log.Debug(re)
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		// Use provided AQI value or compute it from PM values
+		am.Aqi = aqi.MeasurementAqi(*am)
+
+		batch = append(batch, dbpkg.NewMeasurement(s, *am))
+		if len(batch) >= importBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("station [%d]: inserted %d measurement(s), skipped %d duplicate(s), %d invalid row(s)\n",
+		s.Id, inserted, skipped, invalid)
+
+	return nil
+}
+
+// importStationOf gets station to import measurements of by its ID, if it is set, or by its token ID.
+func importStationOf(ctx context.Context, db *dbpkg.Db, id int, tokenId string) (*dbpkg.Station, error) {
+	if id == 0 {
+		s, err := db.StationByTokenId(ctx, tokenId)
+		if err != nil {
+			return nil, fmt.Errorf("can't get station by token id [%s]: %v", tokenId, err)
+		}
+		if s == nil {
+			return nil, fmt.Errorf("station with token id [%s] not found", tokenId)
+		}
+		return s, nil
+	}
+
+	ss, err := db.StationsById(ctx, []int{id})
+	if err != nil {
+		return nil, fmt.Errorf("can't get station [%d]: %v", id, err)
+	}
+	if len(ss) == 0 {
+		return nil, fmt.Errorf("station [%d] not found", id)
+	}
+	return &ss[0], nil
+}
+
+// csvRowError is an error of invalid CSV row that can be skipped.
+type csvRowError struct {
+	line int
+	err  error
+}
+
+func (e *csvRowError) Error() string {
+	return fmt.Sprintf("invalid row at line %d: %v", e.line, e.err)
+}
+
+// csvMeasurementReader reads measurements from CSV rows.
+type csvMeasurementReader struct {
+	r *csv.Reader
+	// columns maps measurement variable names to CSV column indexes
+	columns    map[string]int
+	timeFormat string
+	loc        *time.Location
+}
+
+// newCsvMeasurementReader creates reader of measurements from CSV data with header row read from r.
+// Column names are mapped to variables by mapping of variable names to column names, columns with
+// names of variables are used for variables that are not mapped.
+func newCsvMeasurementReader(r io.Reader, delimiter rune, mapping map[string]string, timeFormat string,
+	loc *time.Location) (*csvMeasurementReader, error) {
+	for v := range mapping {
+		if !isImportVar(v) {
+			return nil, fmt.Errorf("unknown measurement variable: %s", v)
+		}
+	}
+
+	cr := csv.NewReader(r)
+	cr.Comma = delimiter
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("no CSV header")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't read CSV header: %v", err)
+	}
+
+	indexes := make(map[string]int, len(header))
+	for i, h := range header {
+		indexes[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+
+	columns := make(map[string]int)
+	for _, v := range importVars {
+		c, ok := mapping[v]
+		if !ok {
+			c = v
+		}
+		if i, ok := indexes[strings.ToLower(c)]; ok {
+			columns[v] = i
+		} else if _, ok := mapping[v]; ok {
+			return nil, fmt.Errorf("CSV column %q of %s not found", c, v)
+		}
+	}
+	if _, ok := columns["timestamp"]; !ok {
+		return nil, errors.New("CSV timestamp column not found")
+	}
+	if len(columns) < 2 {
+		return nil, errors.New("CSV has no measurement variable columns")
+	}
+
+	return &csvMeasurementReader{
+		r:          cr,
+		columns:    columns,
+		timeFormat: timeFormat,
+		loc:        loc,
+	}, nil
+}
+
+func isImportVar(v string) bool {
+	for _, iv := range importVars {
+		if v == iv {
+			return true
+		}
+	}
+	return false
+}
+
+// Read reads next measurement. It returns io.EOF if there are no more rows,
+// or *csvRowError if row is invalid and reading may be continued.
+func (mr *csvMeasurementReader) Read() (*api.Measurement, error) {
+	row, err := mr.r.Read()
+	if err == io.EOF {
+		return nil, err
+	}
+	var pe *csv.ParseError
+	if errors.As(err, &pe) {
+		return nil, &csvRowError{line: pe.Line, err: pe.Err}
+	}
+	if err != nil {
+		return nil, err
+	}
+	line, _ := mr.r.FieldPos(0)
+
+	field := func(v string) string {
+		if i, ok := mr.columns[v]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	ts, err := parseImportTime(field("timestamp"), mr.timeFormat, mr.loc)
+	if err != nil {
+		return nil, &csvRowError{line: line, err: err}
+	}
+	ut := api.UnixTime(ts)
+	am := api.Measurement{Timestamp: &ut}
+
+	var values int
+	for _, fv := range []struct {
+		name string
+		v    **float32
+	}{
+		{"temperature", &am.Temperature},
+		{"humidity", &am.Humidity},
+		{"pressure", &am.Pressure},
+		{"pm25", &am.Pm25},
+		{"pm10", &am.Pm10},
+	} {
+		s := field(fv.name)
+		if s == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(s, 32)
+		if err != nil {
+			return nil, &csvRowError{line: line, err: fmt.Errorf("invalid %s value: %s", fv.name, s)}
+		}
+		if math.IsNaN(f) {
+			continue
+		}
+		v := float32(f)
+		*fv.v = &v
+		values++
+	}
+
+	if s := field("aqi"); s != "" {
+		a, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, &csvRowError{line: line, err: fmt.Errorf("invalid aqi value: %s", s)}
+		}
+		am.Aqi = &a
+		values++
+	}
+
+	if values == 0 {
+		return nil, &csvRowError{line: line, err: errors.New("no measurement values")}
+	}
+
+	return &am, nil
+}
+
+// parseImportTime parses timestamp s in time format tf. Timestamps without zone offset are in location loc.
+func parseImportTime(s, tf string, loc *time.Location) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, errors.New("no timestamp")
+	}
+
+	switch tf {
+	case timeFormatUnix, timeFormatUnixMs:
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid Unix timestamp: %s", s)
+		}
+		if tf == timeFormatUnixMs {
+			return time.UnixMilli(i), nil
+		}
+		return time.Unix(i, 0), nil
+	case timeFormatAuto:
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			// Unix time in milliseconds is too large for seconds of reasonable dates
+			if i > 1e11 {
+				return time.UnixMilli(i), nil
+			}
+			return time.Unix(i, 0), nil
+		}
+		for _, l := range importTimeLayouts {
+			if t, err := time.ParseInLocation(l, s, loc); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("unknown timestamp format: %s", s)
+	default:
+		t, err := time.ParseInLocation(tf, s, loc)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid timestamp: %v", err)
+		}
+		return t, nil
+	}
+}
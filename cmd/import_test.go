@@ -0,0 +1,118 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCsvMeasurementReader(t *testing.T) {
+	const data = `Time;PM2.5;PM10;Temp
+2019-05-01 12:00:00;10.5;20;15
+2019-05-01 12:01:00;;;16
+bad;1;2;3
+2019-05-01 12:02:00;x;2;3
+2019-05-01 12:03:00;;;
+`
+	mr, err := newCsvMeasurementReader(strings.NewReader(data), ';',
+		map[string]string{"timestamp": "time", "pm25": "PM2.5", "pm10": "pm10", "temperature": "Temp"},
+		timeFormatAuto, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	am, err := mr.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Time(*am.Timestamp).Unix() != time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC).Unix() ||
+		*am.Pm25 != 10.5 || *am.Pm10 != 20 || *am.Temperature != 15 || am.Humidity != nil {
+		t.Errorf("unexpected measurement: %+v", am)
+	}
+
+	if am, err = mr.Read(); err != nil || am.Pm25 != nil || *am.Temperature != 16 {
+		t.Errorf("unexpected measurement: %+v, %v", am, err)
+	}
+
+	for _, line := range []int{4, 5, 6} {
+		var re *csvRowError
+		if _, err := mr.Read(); !errors.As(err, &re) || re.line != line {
+			t.Errorf("row error = %v, want error at line %d", err, line)
+		}
+	}
+
+	if _, err := mr.Read(); err != io.EOF {
+		t.Errorf("error = %v, want EOF", err)
+	}
+}
+
+func TestNewCsvMeasurementReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		mapping map[string]string
+		wantErr bool
+	}{
+		{name: "default", header: "timestamp,pm25,pm10"},
+		{name: "no timestamp", header: "time,pm25,pm10", wantErr: true},
+		{name: "no variables", header: "timestamp,foo", wantErr: true},
+		{name: "unknown variable", header: "timestamp,pm25", mapping: map[string]string{"pm1": "pm25"}, wantErr: true},
+		{name: "mapped column not found", header: "timestamp,pm25", mapping: map[string]string{"pm10": "PM10"},
+			wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newCsvMeasurementReader(strings.NewReader(tt.header+"\n"), ',', tt.mapping,
+				timeFormatAuto, time.UTC)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newCsvMeasurementReader() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseImportTime(t *testing.T) {
+	msk := time.FixedZone("MSK", 3*60*60)
+	want := time.Date(2019, 5, 1, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		s, tf   string
+		wantErr bool
+	}{
+		{s: "1556701200", tf: timeFormatAuto},
+		{s: "1556701200000", tf: timeFormatAuto},
+		{s: "2019-05-01T12:00:00+03:00", tf: timeFormatAuto},
+		{s: "2019-05-01 12:00:00", tf: timeFormatAuto},
+		{s: "1556701200", tf: timeFormatUnix},
+		{s: "1556701200000", tf: timeFormatUnixMs},
+		{s: "01.05.2019 12:00", tf: "02.01.2006 15:04"},
+		{s: "01.05.2019", tf: timeFormatAuto, wantErr: true},
+		{s: "", tf: timeFormatAuto, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := parseImportTime(tt.s, tt.tf, msk)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseImportTime() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !got.Equal(want) {
+				t.Errorf("parseImportTime() = %v, want %v", got, want)
+			}
+		})
+	}
+}
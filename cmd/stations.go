@@ -0,0 +1,112 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/openairtech/apiserver/util"
+)
+
+func newStationsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "stations",
+		Short: "Manage stations",
+	}
+
+	timezoneCmd := &cobra.Command{
+		Use:   "timezone ID [TZ]",
+		Short: "Set station time zone",
+		Long: "Set time zone of station with given ID to IANA time zone TZ (e.g. Europe/Moscow), or clear it\n" +
+			"if TZ is not set. Station time zone is used to compute daily, weekly and monthly aggregates of\n" +
+			"its measurements, time zone approximated by station longitude is used if it is not set.",
+		Args:         cobra.RangeArgs(1, 2),
+		RunE:         runStationsTimezoneCmd,
+		SilenceUsage: true,
+	}
+
+	migrateCmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Migrate stations table",
+		Long: "Add stations table columns missing in database created by older versions. It requires\n" +
+			"stations table ownership and takes exclusive table lock if table isn't migrated yet.",
+		Args:         cobra.NoArgs,
+		RunE:         runStationsMigrateCmd,
+		SilenceUsage: true,
+	}
+
+	cmd.AddCommand(timezoneCmd)
+	cmd.AddCommand(migrateCmd)
+
+	return cmd
+}
+
+func runStationsTimezoneCmd(cmd *cobra.Command, args []string) error {
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid station id: %s", args[0])
+	}
+
+	var tz string
+	if len(args) > 1 {
+		if _, err := util.ParseTimezone(args[1]); err != nil {
+			return err
+		}
+		tz = args[1]
+	}
+
+	db, err := connectDb(cmd)
+	if err != nil {
+		return fmt.Errorf("can't connect to database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.MigrateStationsTable(context.Background()); err != nil {
+		return fmt.Errorf("can't migrate stations table: %v", err)
+	}
+
+	if err := db.SetStationTimezone(context.Background(), id, tz); err != nil {
+		return fmt.Errorf("can't set station time zone: %v", err)
+	}
+
+	if tz == "" {
+		log.Infof("station %d time zone cleared", id)
+	} else {
+		log.Infof("station %d time zone set to %s", id, tz)
+	}
+
+	return nil
+}
+
+func runStationsMigrateCmd(cmd *cobra.Command, _ []string) error {
+	db, err := connectDb(cmd)
+	if err != nil {
+		return fmt.Errorf("can't connect to database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.MigrateStationsTable(context.Background()); err != nil {
+		return fmt.Errorf("can't migrate stations table: %v", err)
+	}
+
+	log.Info("stations table migrated")
+
+	return nil
+}
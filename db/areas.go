@@ -0,0 +1,250 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	gq "github.com/doug-martin/goqu/v7"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"github.com/openairtech/apiserver/util"
+)
+
+// ErrAreaNotFound is returned when named area doesn't exist.
+var ErrAreaNotFound = errors.New("area not found")
+
+// ErrInvalidRegion is returned when region polygon can't be parsed or is not a valid geometry.
+var ErrInvalidRegion = errors.New("invalid region")
+
+// createAreasTable creates table of named areas (e.g. administrative boundaries) used to filter stations.
+const createAreasTable = `CREATE TABLE IF NOT EXISTS areas (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL UNIQUE,
+		properties JSONB,
+		geom GEOMETRY(MultiPolygon, 4326) NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS areas_geom_idx ON areas USING GIST (geom)`
+
+// Region is a region to filter stations. Only one of its fields is expected to be set.
+type Region struct {
+	// Area is a name of area from areas table
+	Area string
+	// AreaId is an ID of area from areas table
+	AreaId int
+	// WKT is a polygon in Well-Known Text format
+	WKT string
+	// GeoJSON is a polygon geometry in GeoJSON format
+	GeoJSON string
+}
+
+// expression returns expression checking whether location column col is within region.
+func (r Region) expression(col string) gq.Expression {
+	switch {
+	case r.Area != "":
+		return gq.L("EXISTS (SELECT 1 FROM areas a WHERE a.name = ? AND a.geom && "+col+
+			" AND ST_Covers(a.geom, "+col+"))", r.Area)
+	case r.AreaId != 0:
+		return gq.L("EXISTS (SELECT 1 FROM areas a WHERE a.id = ? AND a.geom && "+col+
+			" AND ST_Covers(a.geom, "+col+"))", r.AreaId)
+	case r.WKT != "":
+		return gq.L("ST_Covers(ST_GeomFromText(?, 4326), "+col+")", r.WKT)
+	default:
+		return gq.L("ST_Covers(ST_SetSRID(ST_GeomFromGeoJSON(?), 4326), "+col+")", r.GeoJSON)
+	}
+}
+
+// Area is a named area.
+type Area struct {
+	Id   int
+	Name string
+	// Properties is a JSON object of area properties
+	Properties []byte
+	// BBox is an area bounding box [min_long, min_lat, max_long, max_lat]
+	BBox []float64
+}
+
+// AreaBoundary is an area with its boundary geometry to import.
+type AreaBoundary struct {
+	Name       string
+	Properties []byte
+	// GeoJSON is a Polygon or MultiPolygon boundary geometry in GeoJSON format
+	GeoJSON []byte
+}
+
+// ImportAreas adds areas with their boundaries to database in a single transaction, creating areas table
+// if it doesn't exist. Areas with the same name are updated. If replace is true, all other areas are removed.
+func (db *Db) ImportAreas(ctx context.Context, abs []AreaBoundary, replace bool) error {
+	return db.inTx(ctx, func(ctx context.Context, tx *sqlx.Tx) error {
+		if _, err := tx.ExecContext(ctx, createAreasTable); err != nil {
+			return err
+		}
+
+		if replace {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM areas"); err != nil {
+				return err
+			}
+		}
+
+		for _, ab := range abs {
+			var props interface{}
+			if len(ab.Properties) > 0 {
+				props = string(ab.Properties)
+			}
+			if _, err := tx.ExecContext(ctx, `INSERT INTO areas(name, properties, geom)
+				VALUES ($1, $2, ST_Multi(ST_SetSRID(ST_GeomFromGeoJSON($3), 4326)))
+				ON CONFLICT (name) DO UPDATE SET properties = EXCLUDED.properties, geom = EXCLUDED.geom`,
+				ab.Name, props, string(ab.GeoJSON)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Areas gets slice of areas sorted by name.
+func (db *Db) Areas(ctx context.Context) ([]Area, error) {
+	ctx, cancel := withTimeout(ctx, db.queryTimeout)
+	defer cancel()
+
+	rows, err := db.reader().QueryContext(ctx, `SELECT id, name, properties,
+		ST_XMin(geom), ST_YMin(geom), ST_XMax(geom), ST_YMax(geom) FROM areas ORDER BY name`)
+	if err != nil {
+		if isUndefinedTable(err) {
+			// No areas are imported yet
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer util.CloseQuietly(rows)
+
+	var as []Area
+	for rows.Next() {
+		a := Area{BBox: make([]float64, 4)}
+		if err := rows.Scan(&a.Id, &a.Name, &a.Properties, &a.BBox[0], &a.BBox[1], &a.BBox[2],
+			&a.BBox[3]); err != nil {
+			return nil, err
+		}
+		as = append(as, a)
+	}
+
+	return as, rows.Err()
+}
+
+// Area gets area by its ID, returns nil if there is no such area.
+func (db *Db) Area(ctx context.Context, id int) (*Area, error) {
+	ctx, cancel := withTimeout(ctx, db.queryTimeout)
+	defer cancel()
+
+	a := Area{BBox: make([]float64, 4)}
+	err := db.reader().QueryRowContext(ctx, `SELECT id, name, properties,
+		ST_XMin(geom), ST_YMin(geom), ST_XMax(geom), ST_YMax(geom) FROM areas WHERE id = $1`, id).
+		Scan(&a.Id, &a.Name, &a.Properties, &a.BBox[0], &a.BBox[1], &a.BBox[2], &a.BBox[3])
+	if err == sql.ErrNoRows || (err != nil && isUndefinedTable(err)) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+// AreaStationMedians gets public stations within area with given ID along with median values
+// of their measurements taken within time range [from, to]. Station measurement timestamp is
+// the time of its last measurement within time range.
+func (db *Db) AreaStationMedians(ctx context.Context, id int, from, to time.Time) ([]Station, error) {
+	ctx, cancel := withTimeout(ctx, db.queryTimeout)
+	defer cancel()
+
+	var ss []Station
+	err := db.reader().SelectContext(ctx, &ss, `SELECT s.*, MAX(m.tstamp) "m.tstamp",
+			PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY m.temperature) "m.temperature",
+			PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY m.humidity) "m.humidity",
+			PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY m.pressure) "m.pressure",
+			PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY m.pm25) "m.pm25",
+			PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY m.pm10) "m.pm10",
+			ROUND(PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY m.aqi))::INT "m.aqi"
+		FROM stations s JOIN measurements m ON m.station_id = s.id
+		JOIN areas a ON a.geom && s.location AND ST_Covers(a.geom, s.location)
+		WHERE a.id = $1 AND s.is_public AND m.tstamp >= $2 AND m.tstamp <= $3
+		GROUP BY s.id ORDER BY s.id`, id, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return ss, nil
+}
+
+func (db *Db) areaExists(ctx context.Context, name string) (bool, error) {
+	ctx, cancel := withTimeout(ctx, db.queryTimeout)
+	defer cancel()
+
+	var ok bool
+	err := db.reader().GetContext(ctx, &ok, "SELECT EXISTS (SELECT 1 FROM areas WHERE name = $1)", name)
+	if err != nil && isUndefinedTable(err) {
+		return false, nil
+	}
+	return ok, err
+}
+
+// checkRegion checks whether region r polygon, if it is set, is a valid geometry.
+// It returns error wrapping ErrInvalidRegion if it isn't.
+func (db *Db) checkRegion(ctx context.Context, r Region) error {
+	var geom, g string
+	switch {
+	case r.WKT != "":
+		geom, g = "ST_GeomFromText($1, 4326)", r.WKT
+	case r.GeoJSON != "":
+		geom, g = "ST_SetSRID(ST_GeomFromGeoJSON($1), 4326)", r.GeoJSON
+	default:
+		return nil
+	}
+
+	ctx, cancel := withTimeout(ctx, db.queryTimeout)
+	defer cancel()
+
+	var reason string
+	err := db.reader().GetContext(ctx, &reason, "SELECT ST_IsValidReason("+geom+")", g)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && isGeometryError(pqErr) {
+		return fmt.Errorf("%w: %s", ErrInvalidRegion, pqErr.Message)
+	}
+	if err != nil {
+		return err
+	}
+	if reason != "Valid Geometry" {
+		return fmt.Errorf("%w: %s", ErrInvalidRegion, reason)
+	}
+	return nil
+}
+
+// isGeometryError checks whether database error err is caused by geometry that can't be parsed:
+// PostGIS reports such errors as internal errors or invalid parameter values.
+func isGeometryError(err *pq.Error) bool {
+	return err.Code == "XX000" || err.Code == "22023"
+}
+
+// isUndefinedTable checks whether err is caused by query of table that doesn't exist.
+func isUndefinedTable(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "42P01"
+}
@@ -0,0 +1,120 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Config holds database connection and connection pool parameters.
+type Config struct {
+	// URL is a full connection string, either in URL (postgres://...) or in key=value format.
+	// Non-zero connection fields below override the corresponding URL parameters.
+	URL string
+
+	Host     string
+	Port     int
+	User     string
+	Password string
+	Name     string
+
+	SSLMode     string
+	SSLRootCert string
+	SSLCert     string
+	SSLKey      string
+
+	ApplicationName  string
+	ConnectTimeout   time.Duration
+	StatementTimeout time.Duration
+
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+
+	// QueryTimeout and WriteTimeout limit duration of single read and write database operations (0 for no limit).
+	QueryTimeout time.Duration
+	WriteTimeout time.Duration
+
+	// Replicas is a list of read replica connection strings used for query operations.
+	// Replicas inherit pool and timeout settings only, their connection options come from connection strings.
+	Replicas []string
+	// ReplicaMaxLag is the replication lag above which replica is not used (0 for no limit).
+	ReplicaMaxLag time.Duration
+	// ReplicaCheckInterval is the interval of replica health checks.
+	ReplicaCheckInterval time.Duration
+
+	// Notify enables publishing of feed notifications on commit and listening for notifications
+	// of other instances sharing the database.
+	Notify bool
+}
+
+// DSN builds the key=value connection string for lib/pq driver from config connection fields.
+func (c Config) DSN() (string, error) {
+	var kvs []string
+
+	if c.URL != "" {
+		u := c.URL
+		if strings.HasPrefix(u, "postgres://") || strings.HasPrefix(u, "postgresql://") {
+			var err error
+			if u, err = pq.ParseURL(u); err != nil {
+				return "", fmt.Errorf("invalid database URL: %v", err)
+			}
+		}
+		kvs = append(kvs, u)
+	}
+
+	add := func(k, v string) {
+		if v != "" {
+			kvs = append(kvs, k+"="+dsnValue(v))
+		}
+	}
+
+	add("host", c.Host)
+	if c.Port != 0 {
+		add("port", strconv.Itoa(c.Port))
+	}
+	add("user", c.User)
+	add("password", c.Password)
+	add("dbname", c.Name)
+	add("sslmode", c.SSLMode)
+	add("sslrootcert", c.SSLRootCert)
+	add("sslcert", c.SSLCert)
+	add("sslkey", c.SSLKey)
+	add("application_name", c.ApplicationName)
+	if c.ConnectTimeout > 0 {
+		// Driver expects timeout in whole seconds, so round it up
+		add("connect_timeout", strconv.Itoa(int((c.ConnectTimeout+time.Second-1)/time.Second)))
+	}
+	if c.StatementTimeout > 0 {
+		add("statement_timeout", strconv.FormatInt(c.StatementTimeout.Milliseconds(), 10))
+	}
+
+	return strings.Join(kvs, " "), nil
+}
+
+// dsnValue quotes connection string value v if it is empty or contains spaces, quotes or backslashes.
+func dsnValue(v string) string {
+	if v != "" && !strings.ContainsAny(v, ` '\`) {
+		return v
+	}
+	r := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	return "'" + r.Replace(v) + "'"
+}
@@ -0,0 +1,58 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfig_DSN(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		want    string
+		wantErr bool
+	}{
+		{name: "empty", cfg: Config{}, want: ""},
+		{name: "fields", cfg: Config{Host: "localhost", Port: 5432, User: "openair", Password: "openair",
+			Name: "openair", SSLMode: "disable"},
+			want: "host=localhost port=5432 user=openair password=openair dbname=openair sslmode=disable"},
+		{name: "quoting", cfg: Config{Password: `a b'c\`, ApplicationName: "openair"},
+			want: `password='a b\'c\\' application_name=openair`},
+		{name: "tls", cfg: Config{SSLMode: "verify-full", SSLRootCert: "/etc/ca.pem", SSLCert: "/etc/c.pem",
+			SSLKey: "/etc/c.key"},
+			want: "sslmode=verify-full sslrootcert=/etc/ca.pem sslcert=/etc/c.pem sslkey=/etc/c.key"},
+		{name: "timeouts", cfg: Config{ConnectTimeout: 1500 * time.Millisecond, StatementTimeout: 30 * time.Second},
+			want: "connect_timeout=2 statement_timeout=30000"},
+		{name: "url", cfg: Config{URL: "postgres://u:p@db.example.com:6432/air?sslmode=require", SSLMode: "verify-ca"},
+			want: "dbname='air' host='db.example.com' password='p' port='6432' sslmode='require' user='u' sslmode=verify-ca"},
+		{name: "dsn", cfg: Config{URL: "host=db user=u", Port: 6432},
+			want: "host=db user=u port=6432"},
+		{name: "invalid url", cfg: Config{URL: "postgres://%zz"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.cfg.DSN()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DSN() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("DSN() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
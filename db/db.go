@@ -15,14 +15,17 @@
 package db
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"math"
+	"strings"
 	"time"
 
 	gq "github.com/doug-martin/goqu/v7"
 	_ "github.com/doug-martin/goqu/v7/dialect/postgres"
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 
 	"github.com/openairtech/apiserver/util"
 )
@@ -30,35 +33,87 @@ import (
 var d = gq.Dialect("postgres")
 
 type Db struct {
-	sqlx *sqlx.DB
+	sqlx         *sqlx.DB
+	replicas     *replicaSet
+	queryTimeout time.Duration
+	writeTimeout time.Duration
+	// dsn is the primary database connection string used to listen for notifications
+	dsn string
+	// notify, if true, enables feed notifications
+	notify bool
+	// instance identifies this instance in feed notifications
+	instance string
 }
 
-func NewDb(host string, port int, user, password, name string, maxConn int) (*Db, error) {
+// NewDb opens database connection pool according to given config.
+func NewDb(cfg Config) (*Db, error) {
 	var db *sqlx.DB
-	var err error
 
-	if db, err = sqlx.Open("postgres", fmt.Sprintf("host=%s port=%d user=%s password=%s "+
-		"dbname=%s sslmode=disable", host, port, user, password, name)); err != nil {
+	dsn, err := cfg.DSN()
+	if err != nil {
+		return nil, err
+	}
+
+	if db, err = sqlx.Open("postgres", dsn); err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	rs, err := openReplicas(cfg)
+	if err != nil {
+		_ = db.Close()
 		return nil, err
 	}
 
-	db.SetMaxOpenConns(maxConn)
+	if len(rs.replicas) > 0 {
+		rs.watch(cfg.ReplicaCheckInterval, cfg.QueryTimeout)
+	}
 
 	return &Db{
-		sqlx: db,
+		sqlx:         db,
+		replicas:     rs,
+		queryTimeout: cfg.QueryTimeout,
+		writeTimeout: cfg.WriteTimeout,
+		dsn:          dsn,
+		notify:       cfg.Notify,
+		instance:     newInstanceId(),
 	}, nil
 }
 
 func (db *Db) Close() {
+	db.replicas.close()
 	_ = db.sqlx.Close()
 }
 
+// reader returns connection pool for query operations: a healthy read replica if any, or the primary otherwise.
+func (db *Db) reader() *sqlx.DB {
+	if r := db.replicas.pick(); r != nil {
+		return r
+	}
+	return db.sqlx
+}
+
+// withTimeout returns context derived from ctx that is canceled after timeout t elapses, if it is set.
+func withTimeout(ctx context.Context, t time.Duration) (context.Context, context.CancelFunc) {
+	if t <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, t)
+}
+
 // StationByTokenId finds station by its tokenId.
 // It returns reference to Station struct or nil if no station with given token ID was found,
 // or error if something went wrong.
-func (db *Db) StationByTokenId(tokenId string) (*Station, error) {
+func (db *Db) StationByTokenId(ctx context.Context, tokenId string) (*Station, error) {
+	ctx, cancel := withTimeout(ctx, db.queryTimeout)
+	defer cancel()
+
 	s := Station{}
-	if err := db.sqlx.Get(&s, "SELECT * FROM stations WHERE token_id = $1", tokenId); err != nil {
+	if err := db.sqlx.GetContext(ctx, &s, "SELECT * FROM stations WHERE token_id = $1", tokenId); err != nil {
 		return nil, err
 	}
 	return &s, nil
@@ -70,9 +125,24 @@ func (db *Db) StationByTokenId(tokenId string) (*Station, error) {
 // mlast, if not nil, defines the lower time limit for last measurement to include in result,
 // and it is computed as (mfrom - mlast).
 // sall, if true, will return all stations and their data, otherwise public stations only.
-func (db *Db) Stations(bbox []float64, mfrom *time.Time, mlast *time.Duration, sall bool) ([]Station, error) {
+func (db *Db) Stations(ctx context.Context, bbox []float64, mfrom *time.Time, mlast *time.Duration,
+	sall bool) ([]Station, error) {
+	return db.StationsWithin(ctx, bbox, nil, mfrom, mlast, sall)
+}
+
+// StationsWithin gets slice of stations within region r, if it is not nil, with their last measurements.
+// Other parameters have the same meaning as for Stations.
+// It returns ErrAreaNotFound if region is a named area that doesn't exist, or error wrapping ErrInvalidRegion
+// if region polygon is not valid.
+func (db *Db) StationsWithin(ctx context.Context, bbox []float64, r *Region, mfrom *time.Time,
+	mlast *time.Duration, sall bool) ([]Station, error) {
 	var s []Station
 
+	w, err := db.stationsWhere(ctx, bbox, r, sall)
+	if err != nil {
+		return nil, err
+	}
+
 	lj := []gq.Expression{gq.I("s.id").Eq(gq.I("m.station_id"))}
 
 	if mfrom != nil {
@@ -87,6 +157,188 @@ func (db *Db) Stations(bbox []float64, mfrom *time.Time, mlast *time.Duration, s
 		}
 	}
 
+	q := d.From(gq.T("stations").As("s")).
+		Select(gq.L(`DISTINCT ON (s.id) s.*, m.id "m.id", m.tstamp "m.tstamp", m.temperature "m.temperature", 
+			m.pressure "m.pressure", m.humidity "m.humidity", m.pm25 "m.pm25", m.pm10 "m.pm10", m.aqi "m.aqi"`)).
+		LeftJoin(gq.T("measurements").As("m"), gq.On(lj...))
+
+	if len(w) > 0 {
+		q = q.Where(w...)
+	}
+
+	q = q.Order(gq.I("s.id").Asc(), gq.I("m.tstamp").Desc())
+
+	query, args, err := q.Prepared(true).ToSQL()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, db.queryTimeout)
+	defer cancel()
+
+	if err := db.reader().SelectContext(ctx, &s, query, args...); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// StationIdsWithin gets sorted slice of IDs of stations within bounding box bbox, if it is set, and region r,
+// if it is not nil. sall has the same meaning as for Stations.
+func (db *Db) StationIdsWithin(ctx context.Context, bbox []float64, r *Region, sall bool) ([]int, error) {
+	var ids []int
+
+	w, err := db.stationsWhere(ctx, bbox, r, sall)
+	if err != nil {
+		return nil, err
+	}
+
+	q := d.From(gq.T("stations").As("s")).Select(gq.I("s.id"))
+
+	if len(w) > 0 {
+		q = q.Where(w...)
+	}
+
+	q = q.Order(gq.I("s.id").Asc())
+
+	query, args, err := q.Prepared(true).ToSQL()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, db.queryTimeout)
+	defer cancel()
+
+	if err := db.reader().SelectContext(ctx, &ids, query, args...); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// StationsById gets slice of stations with given IDs, or all stations if ids is empty, sorted by ID.
+// Stations are returned without measurements.
+func (db *Db) StationsById(ctx context.Context, ids []int) ([]Station, error) {
+	var s []Station
+
+	q := d.From("stations")
+
+	if len(ids) > 0 {
+		q = q.Where(gq.C("id").In(ids))
+	}
+
+	q = q.Order(gq.I("id").Asc())
+
+	query, args, err := q.Prepared(true).ToSQL()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, db.queryTimeout)
+	defer cancel()
+
+	if err := db.reader().SelectContext(ctx, &s, query, args...); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Station gets station with given ID along with its last measurement, returns nil if there is no such station.
+func (db *Db) Station(ctx context.Context, id int) (*Station, error) {
+	ctx, cancel := withTimeout(ctx, db.queryTimeout)
+	defer cancel()
+
+	var ss []Station
+	err := db.reader().SelectContext(ctx, &ss, `SELECT s.*, m.id "m.id", m.tstamp "m.tstamp",
+			m.temperature "m.temperature", m.pressure "m.pressure", m.humidity "m.humidity",
+			m.pm25 "m.pm25", m.pm10 "m.pm10", m.aqi "m.aqi"
+		FROM stations s LEFT JOIN LATERAL (
+			SELECT * FROM measurements WHERE station_id = s.id ORDER BY tstamp DESC LIMIT 1
+		) m ON TRUE
+		WHERE s.id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(ss) == 0 {
+		return nil, nil
+	}
+
+	return &ss[0], nil
+}
+
+// StationActivity is a reporting activity of station.
+type StationActivity struct {
+	// Hours are numbers of hours with measurements since corresponding times
+	Hours []int
+	// Counts are numbers of measurement variable values since the earliest time keyed by column name
+	Counts map[string]int
+}
+
+// StationActivity gets reporting activity of station with given ID since times since.
+func (db *Db) StationActivity(ctx context.Context, id int, since []time.Time) (*StationActivity, error) {
+	if len(since) == 0 {
+		return nil, errors.New("no activity times")
+	}
+
+	vars := []string{"temperature", "humidity", "pressure", "pm25", "pm10"}
+	sa := StationActivity{
+		Hours:  make([]int, len(since)),
+		Counts: make(map[string]int, len(vars)),
+	}
+
+	earliest := since[0]
+	args := []interface{}{id}
+	var cols []string
+	var dest []interface{}
+	for i, t := range since {
+		if t.Before(earliest) {
+			earliest = t
+		}
+		args = append(args, t)
+		cols = append(cols, fmt.Sprintf("COUNT(DISTINCT DATE_TRUNC('hour', tstamp)) FILTER (WHERE tstamp >= $%d)",
+			len(args)))
+		dest = append(dest, &sa.Hours[i])
+	}
+	counts := make([]int, len(vars))
+	for i, v := range vars {
+		cols = append(cols, fmt.Sprintf("COUNT(%s)", v))
+		dest = append(dest, &counts[i])
+	}
+	args = append(args, earliest)
+
+	ctx, cancel := withTimeout(ctx, db.queryTimeout)
+	defer cancel()
+
+	err := db.reader().QueryRowContext(ctx, fmt.Sprintf("SELECT %s FROM measurements "+
+		"WHERE station_id = $1 AND tstamp >= $%d", strings.Join(cols, ", "), len(args)), args...).Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range vars {
+		sa.Counts[v] = counts[i]
+	}
+
+	return &sa, nil
+}
+
+// stationsWhere returns conditions of stations query for bounding box bbox, region r and sall parameters.
+// It returns ErrAreaNotFound if region named area doesn't exist, or error wrapping ErrInvalidRegion
+// if region polygon is not valid.
+func (db *Db) stationsWhere(ctx context.Context, bbox []float64, r *Region, sall bool) ([]gq.Expression, error) {
+	if r != nil && r.Area != "" {
+		if ok, err := db.areaExists(ctx, r.Area); err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, ErrAreaNotFound
+		}
+	}
+	if r != nil {
+		if err := db.checkRegion(ctx, *r); err != nil {
+			return nil, err
+		}
+	}
+
 	var w []gq.Expression
 
 	if len(bbox) == 4 {
@@ -94,35 +346,129 @@ func (db *Db) Stations(bbox []float64, mfrom *time.Time, mlast *time.Duration, s
 			bbox[0], bbox[1], bbox[2], bbox[3]))
 	}
 
+	if r != nil {
+		w = append(w, r.expression("s.location"))
+	}
+
 	if !sall {
 		w = append(w, gq.L("s.is_public"))
 	}
 
-	q := d.From(gq.T("stations").As("s")).
-		Select(gq.L(`DISTINCT ON (s.id) s.*, m.id "m.id", m.tstamp "m.tstamp", m.temperature "m.temperature", 
-			m.pressure "m.pressure", m.humidity "m.humidity", m.pm25 "m.pm25", m.pm10 "m.pm10", m.aqi "m.aqi"`)).
-		LeftJoin(gq.T("measurements").As("m"), gq.On(lj...))
+	return w, nil
+}
 
-	if len(w) > 0 {
-		q = q.Where(w...)
+const (
+	// nearPointGeog is the point of near stations query with longitude $1 and latitude $2
+	nearPointGeog = "ST_SetSRID(ST_MakePoint($1, $2), 4326)::GEOGRAPHY"
+
+	// metersPerLatDegree and metersPerLonDegree are lower bounds of WGS 84 degree lengths
+	// (the latter is to be multiplied by cosine of latitude) with a safety margin
+	metersPerLatDegree = 110574 * 0.99
+	metersPerLonDegree = 111319 * 0.99
+)
+
+// nearBox returns bounding box in degrees of circle with given radius in meters around point (lon, lat),
+// which is used to filter stations by geometry index before computing geography distances.
+// Box spans all longitudes if circle is close to a pole or crosses antimeridian.
+func nearBox(lon, lat, radius float64) (minLon, minLat, maxLon, maxLat float64) {
+	dLat := radius / metersPerLatDegree
+	minLat, maxLat = math.Max(lat-dLat, -90), math.Min(lat+dLat, 90)
+	minLon, maxLon = -180, 180
+	if maxLat >= 90 || minLat <= -90 {
+		return
+	}
+	dLon := radius / (metersPerLonDegree * math.Cos(math.Max(math.Abs(minLat), math.Abs(maxLat))*math.Pi/180))
+	if lon-dLon >= -180 && lon+dLon <= 180 {
+		minLon, maxLon = lon-dLon, lon+dLon
 	}
+	return
+}
 
-	q = q.Order(gq.I("s.id").Asc(), gq.I("m.tstamp").Desc())
+// StationsNear gets slice of stations ordered by distance from point (lon, lat) along with their
+// last measurements and distances in meters.
+// radius, if positive, defines the maximum distance in meters of stations to include in result.
+// k, if positive, defines the maximum number of nearest stations to include in result.
+// mfrom, mlast and sall have the same meaning as for Stations.
+func (db *Db) StationsNear(ctx context.Context, lon, lat, radius float64, k int, mfrom *time.Time,
+	mlast *time.Duration, sall bool) ([]Station, error) {
+	var s []Station
 
-	query, args, err := q.Prepared(true).ToSQL()
-	if err != nil {
-		return nil, err
+	args := []interface{}{lon, lat}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	var w []string
+	if !sall {
+		w = append(w, "s.is_public")
+	}
+	if radius > 0 {
+		minLon, minLat, maxLon, maxLat := nearBox(lon, lat, radius)
+		w = append(w, fmt.Sprintf("s.location && ST_MakeEnvelope(%s, %s, %s, %s, 4326)",
+			arg(minLon), arg(minLat), arg(maxLon), arg(maxLat)))
+		w = append(w, "ST_DWithin(s.location::GEOGRAPHY, "+nearPointGeog+", "+arg(radius)+")")
 	}
 
-	if err := db.sqlx.Select(&s, query, args...); err != nil {
+	var mw []string
+	if mfrom != nil {
+		mw = append(mw, "m.tstamp <= "+arg(*mfrom))
+	}
+	if mlast != nil {
+		if mfrom != nil {
+			mw = append(mw, fmt.Sprintf("m.tstamp > %s::TIMESTAMP - %s * INTERVAL '1 SECOND'",
+				arg(*mfrom), arg(int(mlast.Seconds()))))
+		} else {
+			mw = append(mw, fmt.Sprintf("m.tstamp > NOW() - %s * INTERVAL '1 SECOND'", arg(int(mlast.Seconds()))))
+		}
+	}
+
+	query := `SELECT s.*, ST_Distance(s.location::GEOGRAPHY, ` + nearPointGeog + `) AS distance,
+			m.id "m.id", m.tstamp "m.tstamp", m.temperature "m.temperature", m.pressure "m.pressure",
+			m.humidity "m.humidity", m.pm25 "m.pm25", m.pm10 "m.pm10", m.aqi "m.aqi"
+		FROM stations s
+		LEFT JOIN LATERAL (
+			SELECT * FROM measurements m WHERE m.station_id = s.id` + andWhere(mw) + `
+			ORDER BY m.tstamp DESC LIMIT 1
+		) m ON TRUE`
+	if len(w) > 0 {
+		query += " WHERE " + strings.Join(w, " AND ")
+	}
+	// Stations are sorted by geography distance before limiting their number, since geometry distance
+	// in degrees doesn't preserve order of real distances. Radius filter is assisted by stations location
+	// index, nearest stations without radius are found by stations table scan.
+	query += " ORDER BY distance, s.id"
+	if k > 0 {
+		query += " LIMIT " + arg(k)
+	}
+
+	ctx, cancel := withTimeout(ctx, db.queryTimeout)
+	defer cancel()
+
+	if err := db.reader().SelectContext(ctx, &s, query, args...); err != nil {
 		return nil, err
 	}
 
 	return s, nil
 }
 
+// andWhere joins conditions cs to be appended to WHERE clause having conditions already.
+func andWhere(cs []string) string {
+	if len(cs) == 0 {
+		return ""
+	}
+	return " AND " + strings.Join(cs, " AND ")
+}
+
 // UpdateStation updates station s data by the differences found while comparing it with updated data su
-func (db *Db) UpdateStation(s, su *Station) error {
+func (db *Db) UpdateStation(ctx context.Context, s, su *Station) error {
+	ctx, cancel := withTimeout(ctx, db.writeTimeout)
+	defer cancel()
+
+	return updateStation(ctx, db.sqlx, s, su)
+}
+
+func updateStation(ctx context.Context, e sqlx.ExecerContext, s, su *Station) error {
 	if s == su {
 		// No fields to update
 		return nil
@@ -150,6 +496,9 @@ func (db *Db) UpdateStation(s, su *Station) error {
 	if s.Location != su.Location {
 		r["location"] = su.Location
 	}
+	if s.Timezone != su.Timezone {
+		r["timezone"] = su.Timezone
+	}
 
 	if len(r) == 0 {
 		return errors.New(fmt.Sprintf("station objects are different "+
@@ -161,11 +510,80 @@ func (db *Db) UpdateStation(s, su *Station) error {
 		return err
 	}
 
-	_, err = db.sqlx.Exec(query, args...)
+	_, err = e.ExecContext(ctx, query, args...)
+
+	return err
+}
+
+// migrateStationsTable adds stations table columns missing in databases created by older versions.
+const migrateStationsTable = `ALTER TABLE stations ADD COLUMN IF NOT EXISTS timezone TEXT`
+
+// IsStationsTableMigrated checks stations table has all columns added by MigrateStationsTable.
+func (db *Db) IsStationsTableMigrated(ctx context.Context) (bool, error) {
+	ctx, cancel := withTimeout(ctx, db.queryTimeout)
+	defer cancel()
+
+	var ok bool
+	err := db.sqlx.QueryRowxContext(ctx, `SELECT EXISTS (SELECT 1 FROM information_schema.columns
+		WHERE table_schema = ANY (current_schemas(FALSE)) AND table_name = 'stations' AND column_name = 'timezone')`).
+		Scan(&ok)
+	return ok, err
+}
+
+// MigrateStationsTable adds missing columns to stations table. Table is altered only if it isn't
+// migrated yet, since altering takes exclusive table lock and requires table ownership.
+func (db *Db) MigrateStationsTable(ctx context.Context) error {
+	if ok, err := db.IsStationsTableMigrated(ctx); err != nil || ok {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, db.writeTimeout)
+	defer cancel()
 
+	_, err := db.sqlx.ExecContext(ctx, migrateStationsTable)
 	return err
 }
 
+// SetStationTimezone sets time zone of station with given ID to IANA time zone name tz, or clears it
+// if tz is empty.
+func (db *Db) SetStationTimezone(ctx context.Context, id int, tz string) error {
+	return db.inTx(ctx, func(ctx context.Context, tx *sqlx.Tx) error {
+		r, err := tx.ExecContext(ctx, "UPDATE stations SET timezone = NULLIF($2, '') WHERE id = $1", id, tz)
+		if err != nil {
+			return err
+		}
+		n, err := r.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("station not found: %d", id)
+		}
+		return nil
+	})
+}
+
+// FeedStation adds station measurements and updates station s data to su in a single transaction,
+// so either both of them are stored or none.
+// It returns inserted measurements, measurements that are already added are skipped.
+func (db *Db) FeedStation(ctx context.Context, s, su *Station, measurements []Measurement) ([]Measurement, error) {
+	var ms []Measurement
+	err := db.inTx(ctx, func(ctx context.Context, tx *sqlx.Tx) error {
+		var err error
+		if ms, err = copyMeasurements(ctx, tx, stationMeasurements(s, measurements)); err != nil {
+			return err
+		}
+		if err = updateStation(ctx, tx, s, su); err != nil {
+			return err
+		}
+		return db.notifyFeeds(ctx, tx, ms)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
+
 // AddMeasurement adds station measurement to database.
 // Returns added measurement in case of success,
 // or nil if station measurement with given timestamp is already added.
@@ -176,8 +594,8 @@ func (db *Db) UpdateStation(s, su *Station) error {
 // pm25 specifies measured PM2.5 value
 // pm10 specifies measured PM10 value
 // aqi specifies air quality index (AQI) value
-func (db *Db) AddMeasurement(station *Station, timestamp time.Time, temperature, humidity, pressure,
-	pm25, pm10 *float32, aqi *int) (*Measurement, error) {
+func (db *Db) AddMeasurement(ctx context.Context, station *Station, timestamp time.Time,
+	temperature, humidity, pressure, pm25, pm10 *float32, aqi *int) (*Measurement, error) {
 
 	m := Measurement{
 		StationId:   toNullInt64(&station.Id),
@@ -194,7 +612,11 @@ func (db *Db) AddMeasurement(station *Station, timestamp time.Time, temperature,
 		VALUES (:station_id, :tstamp, :temperature, :humidity, :pressure, :pm25, :pm10, :aqi) 
 		ON CONFLICT("station_id", "tstamp") DO NOTHING 
 		RETURNING id`
-	rows, err := db.sqlx.NamedQuery(query, m)
+
+	ctx, cancel := withTimeout(ctx, db.writeTimeout)
+	defer cancel()
+
+	rows, err := db.sqlx.NamedQueryContext(ctx, query, m)
 
 	if err != nil {
 		return nil, err
@@ -213,36 +635,130 @@ func (db *Db) AddMeasurement(station *Station, timestamp time.Time, temperature,
 	return &m, nil
 }
 
+// FeedStations adds measurements of several stations and updates their seen time and firmware version
+// in a single transaction. It returns inserted measurements,
+// measurements that are already added are skipped.
+func (db *Db) FeedStations(ctx context.Context, feeds []Feed) ([]Measurement, error) {
+	var ims []Measurement
+	err := db.inTx(ctx, func(ctx context.Context, tx *sqlx.Tx) error {
+		var ms []Measurement
+		for _, f := range feeds {
+			ms = append(ms, stationMeasurements(&Station{Id: f.StationId}, f.Measurements)...)
+		}
+
+		var err error
+		if ims, err = copyMeasurements(ctx, tx, ms); err != nil {
+			return err
+		}
+
+		for _, f := range feeds {
+			if _, err := tx.ExecContext(ctx, "UPDATE stations SET seen = GREATEST(seen, $1), version = $2 "+
+				"WHERE id = $3", f.Seen, f.Version, f.StationId); err != nil {
+				return err
+			}
+		}
+
+		return db.notifyFeeds(ctx, tx, ims)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ims, nil
+}
+
 // AddMeasurements does bulk add station measurements to database.
 // station is reference to station object
 // measurements is slice of measurement data to add
-func (db *Db) AddMeasurements(station *Station, measurements []Measurement) error {
-	q := d.From("measurements").Prepared(true)
-
-	var gm []gq.Record
-
-	for _, dm := range measurements {
-		gm = append(gm, gq.Record{
-			"station_id":  station.Id,
-			"tstamp":      dm.Timestamp,
-			"temperature": dm.Temperature,
-			"humidity":    dm.Humidity,
-			"pressure":    dm.Pressure,
-			"pm25":        dm.Pm25,
-			"pm10":        dm.Pm10,
-			"aqi":         dm.Aqi})
+// It returns the number of inserted measurements, measurements that are already added are skipped.
+// Bulk added measurements, like imported historical ones, are not live feeds, so other instances
+// are not notified of them.
+func (db *Db) AddMeasurements(ctx context.Context, station *Station, measurements []Measurement) (int, error) {
+	var ms []Measurement
+	err := db.inTx(ctx, func(ctx context.Context, tx *sqlx.Tx) error {
+		var err error
+		ms, err = copyMeasurements(ctx, tx, stationMeasurements(station, measurements))
+		return err
+	})
+	if err != nil {
+		return 0, err
 	}
+	return len(ms), nil
+}
+
+// inTx runs f within a database transaction that is committed if f succeeds and rolled back otherwise.
+func (db *Db) inTx(ctx context.Context, f func(ctx context.Context, tx *sqlx.Tx) error) error {
+	ctx, cancel := withTimeout(ctx, db.writeTimeout)
+	defer cancel()
 
-	query, args, err := q.ToInsertConflictSQL(gq.DoNothing(), gm)
+	tx, err := db.sqlx.BeginTxx(ctx, nil)
 	if err != nil {
 		return err
 	}
 
-	if _, err := db.sqlx.Exec(query, args...); err != nil {
+	if err := f(ctx, tx); err != nil {
+		_ = tx.Rollback()
 		return err
 	}
 
-	return nil
+	return tx.Commit()
+}
+
+// stationMeasurements returns copy of measurements bound to given station.
+func stationMeasurements(station *Station, measurements []Measurement) []Measurement {
+	ms := make([]Measurement, len(measurements))
+	for i, m := range measurements {
+		m.StationId = toNullInt64(&station.Id)
+		ms[i] = m
+	}
+	return ms
+}
+
+// copyMeasurements bulk loads measurements into a temporary staging table using COPY
+// and then merges them into measurements table skipping already added ones.
+// It returns inserted measurements.
+func copyMeasurements(ctx context.Context, tx *sqlx.Tx, measurements []Measurement) ([]Measurement, error) {
+	if len(measurements) == 0 {
+		return nil, nil
+	}
+
+	cols := []string{"station_id", "tstamp", "temperature", "humidity", "pressure", "pm25", "pm10", "aqi"}
+	cl := strings.Join(cols, ", ")
+
+	if _, err := tx.ExecContext(ctx, `CREATE TEMPORARY TABLE IF NOT EXISTS measurements_staging
+		ON COMMIT DROP AS SELECT `+cl+` FROM measurements WITH NO DATA`); err != nil {
+		return nil, err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("measurements_staging", cols...))
+	if err != nil {
+		return nil, err
+	}
+	defer util.CloseQuietly(stmt)
+
+	for _, m := range measurements {
+		if _, err := stmt.ExecContext(ctx, m.StationId, m.Timestamp, m.Temperature, m.Humidity, m.Pressure,
+			m.Pm25, m.Pm10, m.Aqi); err != nil {
+			return nil, err
+		}
+	}
+
+	// Flush buffered rows
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return nil, err
+	}
+
+	var ms []Measurement
+	if err := tx.SelectContext(ctx, &ms, `INSERT INTO measurements(`+cl+`) SELECT `+cl+` FROM measurements_staging
+		ON CONFLICT("station_id", "tstamp") DO NOTHING RETURNING id, `+cl); err != nil {
+		return nil, err
+	}
+
+	// Clear staging table in case of copying more measurements within the same transaction
+	if _, err := tx.ExecContext(ctx, "TRUNCATE measurements_staging"); err != nil {
+		return nil, err
+	}
+
+	return ms, nil
 }
 
 // Measurements gets slice of station measurements sorted by timestamp according to given time interval.
@@ -250,38 +766,183 @@ func (db *Db) AddMeasurements(station *Station, measurements []Measurement) erro
 // timeFrom specifies the start time of interval to get measurements.
 // timeEnd specifies the end time of interval to get measurements.
 // vars specifies measurement variable names to return if not empty, otherwise return all variables. Timestamp is always returned.
-func (db *Db) Measurements(stationId int, timeFrom time.Time, timeTo time.Time, vars []string) ([]Measurement, error) {
+func (db *Db) Measurements(ctx context.Context, stationId int, timeFrom time.Time, timeTo time.Time,
+	vars []string) ([]Measurement, error) {
+	var m []Measurement
+
+	query, args, err := measurementsQuery(stationId, timeFrom, timeTo, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, db.queryTimeout)
+	defer cancel()
+
+	if err := db.reader().SelectContext(ctx, &m, query, args...); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Calendar resampling interval units
+const (
+	ResampleDay   = "day"
+	ResampleWeek  = "week"
+	ResampleMonth = "month"
+)
+
+// Resampling is a measurements resampling interval: either fixed duration step aligned to Unix epoch,
+// or calendar day, week (starting on Monday) or month in given time zone.
+type Resampling struct {
+	Step time.Duration
+	// Unit is a calendar interval unit, Step is ignored if it is set
+	Unit string
+	// Location is a time zone of calendar intervals, UTC if nil
+	Location *time.Location
+}
+
+// IsCalendarResampleUnit checks u is a calendar resampling interval unit.
+func IsCalendarResampleUnit(u string) bool {
+	return u == ResampleDay || u == ResampleWeek || u == ResampleMonth
+}
+
+// timestampColumn returns tstamp column expression of resampling interval start of measurement timestamp.
+func (rs Resampling) timestampColumn() gq.Expression {
+	sql, args := rs.timestampSql()
+	return gq.L(sql, args...).As("tstamp")
+}
+
+// timestampSql returns SQL literal and its arguments of resampling interval start of measurement timestamp.
+// Calendar intervals boundaries are local time midnights, so they follow DST transitions.
+func (rs Resampling) timestampSql() (string, []interface{}) {
+	if rs.Unit != "" {
+		tz := "UTC"
+		if rs.Location != nil {
+			tz = rs.Location.String()
+		}
+		return "DATE_TRUNC(?, TO_TIMESTAMP(EXTRACT(EPOCH FROM tstamp)) AT TIME ZONE ?) AT TIME ZONE ?",
+			[]interface{}{rs.Unit, tz, tz}
+	}
+	sec := int(rs.Step.Seconds())
+	return "TO_TIMESTAMP(FLOOR(EXTRACT(EPOCH FROM tstamp) / ?) * ?)", []interface{}{sec, sec}
+}
+
+// StationsMeasurements gets slice of measurements of stations with given IDs sorted by station ID
+// and timestamp according to given time interval. If resampling rs is set, measurements are resampled
+// to its intervals: measurement values are averaged over each interval, and measurement timestamp
+// is the start of interval. vars has the same meaning as for Measurements.
+func (db *Db) StationsMeasurements(ctx context.Context, stationIds []int, timeFrom time.Time, timeTo time.Time,
+	vars []string, rs *Resampling) ([]Measurement, error) {
 	var m []Measurement
 	if timeFrom.After(timeTo) {
 		timeFrom, timeTo = timeTo, timeFrom
 	}
 
+	if len(vars) == 0 {
+		vars = []string{"temperature", "humidity", "pressure", "pm25", "pm10", "aqi"}
+	}
+	c, err := MeasurementDbColumns(vars)
+	if err != nil {
+		return nil, err
+	}
+
 	q := d.From("measurements")
 
-	if vars != nil && len(vars) > 0 {
-		c, err := MeasurementDbColumns(vars)
-		if err != nil {
-			return nil, err
+	sc := []interface{}{gq.C("station_id")}
+	if rs != nil && (rs.Unit != "" || rs.Step > 0) {
+		sc = append(sc, rs.timestampColumn())
+		for _, v := range c {
+			switch v {
+			case "tstamp":
+			case "aqi":
+				sc = append(sc, gq.L("ROUND(AVG(aqi))::INT").As("aqi"))
+			default:
+				sc = append(sc, gq.AVG(v).As(v))
+			}
 		}
+		q = q.GroupBy(gq.L("1"), gq.L("2"))
+	} else {
 		if !util.StringInSlice("tstamp", c) {
 			c = append(c, "tstamp")
 		}
-		q = q.Select(c...)
+		sc = append(sc, c...)
 	}
+	q = q.Select(sc...)
 
-	q = q.Where(gq.C("station_id").Eq(stationId))
+	q = q.Where(gq.C("station_id").In(stationIds))
 	q = q.Where(gq.C("tstamp").Between(gq.Range(timeFrom, timeTo)))
 
-	q = q.Order(gq.I("tstamp").Asc())
+	q = q.Order(gq.I("station_id").Asc(), gq.I("tstamp").Asc())
 
 	query, args, err := q.Prepared(true).ToSQL()
 	if err != nil {
 		return nil, err
 	}
 
-	if err := db.sqlx.Select(&m, query, args...); err != nil {
+	ctx, cancel := withTimeout(ctx, db.queryTimeout)
+	defer cancel()
+
+	if err := db.reader().SelectContext(ctx, &m, query, args...); err != nil {
 		return nil, err
 	}
 
 	return m, nil
 }
+
+// EachMeasurement calls f for every station measurement within given time range, reading measurements
+// one by one from database, so it can be used to export large amount of data. Query timeout is not applied,
+// so query lasts until all measurements are read, ctx is canceled or f returns error.
+func (db *Db) EachMeasurement(ctx context.Context, stationId int, timeFrom time.Time, timeTo time.Time,
+	vars []string, f func(m *Measurement) error) error {
+	query, args, err := measurementsQuery(stationId, timeFrom, timeTo, vars)
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.reader().QueryxContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer util.CloseQuietly(rows)
+
+	for rows.Next() {
+		var m Measurement
+		if err := rows.StructScan(&m); err != nil {
+			return err
+		}
+		if err := f(&m); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// measurementsQuery builds query of station measurements within given time range ordered by timestamp.
+func measurementsQuery(stationId int, timeFrom time.Time, timeTo time.Time,
+	vars []string) (string, []interface{}, error) {
+	if timeFrom.After(timeTo) {
+		timeFrom, timeTo = timeTo, timeFrom
+	}
+
+	q := d.From("measurements")
+
+	if vars != nil && len(vars) > 0 {
+		c, err := MeasurementDbColumns(vars)
+		if err != nil {
+			return "", nil, err
+		}
+		if !util.StringInSlice("tstamp", c) {
+			c = append(c, "tstamp")
+		}
+		q = q.Select(c...)
+	}
+
+	q = q.Where(gq.C("station_id").Eq(stationId))
+	q = q.Where(gq.C("tstamp").Between(gq.Range(timeFrom, timeTo)))
+
+	q = q.Order(gq.I("tstamp").Asc())
+
+	return q.Prepared(true).ToSQL()
+}
@@ -33,6 +33,10 @@ type Station struct {
 	Seen        *time.Time
 	IsPublic    bool `db:"is_public"`
 	Location    postgis.PointS
+	// Timezone is an IANA time zone name of station location used for calendar aggregations, if set
+	Timezone sql.NullString
+	// Distance is the distance in meters from the point of interest, set by distance queries only
+	Distance    *float64
 	Measurement `db:"m"`
 }
 
@@ -111,6 +115,17 @@ func (m Measurement) ApiMeasurement() api.Measurement {
 	}
 }
 
+// Feed is a station data feed: station measurements along with station data updates.
+// Station location and visibility are not updated by feed, they are provided for feed consumers.
+type Feed struct {
+	StationId    int
+	Version      sql.NullString
+	Seen         time.Time
+	Measurements []Measurement
+	Location     postgis.PointS
+	IsPublic     bool
+}
+
 func MeasurementDbColumns(amv []string) ([]interface{}, error) {
 	s := make(map[interface{}]struct{})
 	// FIXME Use reflection with API/DB measurement structs
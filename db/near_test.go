@@ -0,0 +1,73 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"math"
+	"testing"
+)
+
+// haversine returns great-circle distance in meters between points (lon1, lat1) and (lon2, lat2)
+// on sphere with mean Earth radius, which is within 0.5% of WGS 84 geodesic distance.
+func haversine(lon1, lat1, lon2, lat2 float64) float64 {
+	const r = 6371008.8
+	rad := math.Pi / 180
+	dLat, dLon := (lat2-lat1)*rad, (lon2-lon1)*rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * r * math.Asin(math.Sqrt(a))
+}
+
+func TestNearBox(t *testing.T) {
+	tests := []struct {
+		name     string
+		lon, lat float64
+		radius   float64
+		// allLon is set if box must span all longitudes
+		allLon bool
+	}{
+		{name: "equator", lon: 30, lat: 0, radius: 10000},
+		{name: "north", lon: 44.5, lat: 48.7, radius: 25000},
+		{name: "south", lon: -70.6, lat: -33.4, radius: 50000},
+		{name: "far north", lon: 18.9, lat: 78.2, radius: 100000},
+		{name: "antimeridian", lon: 179.9, lat: -16.5, radius: 30000, allLon: true},
+		{name: "pole", lon: 0, lat: 89.9, radius: 20000, allLon: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			minLon, minLat, maxLon, maxLat := nearBox(tt.lon, tt.lat, tt.radius)
+			if tt.allLon != (minLon == -180 && maxLon == 180) {
+				t.Fatalf("nearBox() longitudes = [%v, %v], want all longitudes %v", minLon, maxLon, tt.allLon)
+			}
+			// Box must contain points of circle with a margin exceeding sphere distance error
+			r := tt.radius * 1.005
+			for a := 0.0; a < 360; a += 5 {
+				// Point at distance r and bearing a from center
+				rad := math.Pi / 180
+				d := r / 6371008.8
+				lat1, lon1, b := tt.lat*rad, tt.lon*rad, a*rad
+				lat2 := math.Asin(math.Sin(lat1)*math.Cos(d) + math.Cos(lat1)*math.Sin(d)*math.Cos(b))
+				lon2 := lon1 + math.Atan2(math.Sin(b)*math.Sin(d)*math.Cos(lat1), math.Cos(d)-math.Sin(lat1)*math.Sin(lat2))
+				plon, plat := math.Remainder(lon2/rad, 360), lat2/rad
+				if math.Abs(haversine(tt.lon, tt.lat, plon, plat)-r) > 1 {
+					t.Fatalf("circle point (%v, %v) is not at distance %v", plon, plat, r)
+				}
+				if plon < minLon || plon > maxLon || plat < minLat || plat > maxLat {
+					t.Errorf("nearBox() = [%v, %v, %v, %v] doesn't contain circle point (%v, %v)",
+						minLon, minLat, maxLon, maxLat, plon, plat)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// feedsChannel is the name of notification channel of station feeds
+	feedsChannel = "openair_feeds"
+	// maxNotificationIds is the maximum number of measurement IDs in single notification
+	// to keep its payload well below PostgreSQL limit of 8000 bytes
+	maxNotificationIds = 400
+
+	listenerMinReconnectInterval = time.Second
+	listenerMaxReconnectInterval = time.Minute
+	// listenerPingInterval is the interval of listener connection checks when there are no notifications
+	listenerPingInterval = 90 * time.Second
+)
+
+// FeedNotification is a notification of station measurements added to database.
+type FeedNotification struct {
+	// Instance identifies API server instance that added measurements
+	Instance  string  `json:"instance"`
+	StationId int     `json:"station_id"`
+	Ids       []int64 `json:"ids"`
+}
+
+// newInstanceId returns unique ID of API server instance.
+func newInstanceId() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	h, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d-%s", h, os.Getpid(), hex.EncodeToString(b))
+}
+
+// feedNotifications groups inserted measurements ms by station into notifications of given instance.
+func feedNotifications(instance string, ms []Measurement) []FeedNotification {
+	sids := make(map[int][]int64)
+	for _, m := range ms {
+		id := int(m.StationId.Int64)
+		sids[id] = append(sids[id], m.Id.Int64)
+	}
+
+	var ns []FeedNotification
+	for sid, ids := range sids {
+		for len(ids) > 0 {
+			n := len(ids)
+			if n > maxNotificationIds {
+				n = maxNotificationIds
+			}
+			ns = append(ns, FeedNotification{Instance: instance, StationId: sid, Ids: ids[:n]})
+			ids = ids[n:]
+		}
+	}
+
+	sort.SliceStable(ns, func(i, j int) bool { return ns[i].StationId < ns[j].StationId })
+
+	return ns
+}
+
+// notifyFeeds sends notifications of inserted measurements ms within transaction tx,
+// so they are delivered to listeners on transaction commit only.
+func (db *Db) notifyFeeds(ctx context.Context, tx *sqlx.Tx, ms []Measurement) error {
+	if !db.notify {
+		return nil
+	}
+	for _, n := range feedNotifications(db.instance, ms) {
+		p, err := json.Marshal(n)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "SELECT pg_notify($1, $2)", feedsChannel, string(p)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListenFeeds listens for feed notifications of other API server instances and calls f on every one received
+// until ctx is done. Lost database connection is reestablished automatically, and reconnected is called
+// after that, if set, since notifications sent while connection was lost are missed.
+func (db *Db) ListenFeeds(ctx context.Context, f func(n FeedNotification), reconnected func()) error {
+	if !db.notify {
+		return nil
+	}
+
+	l := pq.NewListener(db.dsn, listenerMinReconnectInterval, listenerMaxReconnectInterval,
+		func(ev pq.ListenerEventType, err error) {
+			switch ev {
+			case pq.ListenerEventDisconnected:
+				log.Warnf("feed notification listener disconnected: %v", err)
+			case pq.ListenerEventConnectionAttemptFailed:
+				log.Debugf("feed notification listener connection attempt failed: %v", err)
+			case pq.ListenerEventReconnected:
+				log.Infof("feed notification listener reconnected")
+			}
+		})
+	defer func() {
+		_ = l.Close()
+	}()
+
+	if err := l.Listen(feedsChannel); err != nil {
+		return err
+	}
+
+	t := time.NewTicker(listenerPingInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case pn := <-l.Notify:
+			if pn == nil {
+				// Connection has been reestablished
+				if reconnected != nil {
+					reconnected()
+				}
+				continue
+			}
+			var n FeedNotification
+			if err := json.Unmarshal([]byte(pn.Extra), &n); err != nil {
+				log.Errorf("can't parse feed notification: %v", err)
+				continue
+			}
+			if n.Instance == db.instance {
+				// Feed is already published by this instance
+				continue
+			}
+			f(n)
+		case <-t.C:
+			go func() {
+				if err := l.Ping(); err != nil {
+					log.Debugf("feed notification listener ping failed: %v", err)
+				}
+			}()
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// NotifiedFeed gets feed of station measurements added by another instance according to notification n.
+func (db *Db) NotifiedFeed(ctx context.Context, n FeedNotification) (*Feed, error) {
+	ctx, cancel := withTimeout(ctx, db.queryTimeout)
+	defer cancel()
+
+	// Notification is sent on commit to primary, so query it to not miss measurements not replicated yet
+	s := Station{}
+	if err := db.sqlx.GetContext(ctx, &s, "SELECT * FROM stations WHERE id = $1", n.StationId); err != nil {
+		return nil, err
+	}
+
+	var ms []Measurement
+	if err := db.sqlx.SelectContext(ctx, &ms, "SELECT id, station_id, tstamp, temperature, humidity, "+
+		"pressure, pm25, pm10, aqi FROM measurements WHERE id = ANY($1) ORDER BY tstamp",
+		pq.Array(n.Ids)); err != nil {
+		return nil, err
+	}
+
+	f := &Feed{
+		StationId:    s.Id,
+		Version:      s.Version,
+		Location:     s.Location,
+		IsPublic:     s.IsPublic,
+		Measurements: ms,
+	}
+	if s.Seen != nil {
+		f.Seen = *s.Seen
+	}
+
+	return f, nil
+}
@@ -0,0 +1,133 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func notifyTestMeasurements(stationId int, ids ...int64) []Measurement {
+	var ms []Measurement
+	for _, id := range ids {
+		ms = append(ms, Measurement{
+			Id:        sql.NullInt64{Int64: id, Valid: true},
+			StationId: sql.NullInt64{Int64: int64(stationId), Valid: true},
+		})
+	}
+	return ms
+}
+
+func TestFeedNotifications(t *testing.T) {
+	ms := append(notifyTestMeasurements(2, 10, 11), notifyTestMeasurements(1, 12)...)
+	var ids []int64
+	for i := int64(0); i < maxNotificationIds+1; i++ {
+		ids = append(ids, 100+i)
+	}
+	ms = append(ms, notifyTestMeasurements(3, ids...)...)
+
+	ns := feedNotifications("test", ms)
+	if len(ns) != 4 {
+		t.Fatalf("feedNotifications() = %d notifications, want 4", len(ns))
+	}
+	if !reflect.DeepEqual(ns[0], FeedNotification{Instance: "test", StationId: 1, Ids: []int64{12}}) ||
+		!reflect.DeepEqual(ns[1], FeedNotification{Instance: "test", StationId: 2, Ids: []int64{10, 11}}) {
+		t.Errorf("feedNotifications() = %+v, %+v, want notifications grouped by station", ns[0], ns[1])
+	}
+	if len(ns[2].Ids) != maxNotificationIds || len(ns[3].Ids) != 1 || ns[3].Ids[0] != ids[maxNotificationIds] {
+		t.Errorf("feedNotifications() ids = %d, %d, want %d, 1", len(ns[2].Ids), len(ns[3].Ids),
+			maxNotificationIds)
+	}
+}
+
+// TestListenFeeds requires PostgreSQL server, its connection string is set
+// by OPENAIR_TEST_DB_URL environment variable.
+func TestListenFeeds(t *testing.T) {
+	url := os.Getenv("OPENAIR_TEST_DB_URL")
+	if url == "" {
+		t.Skip("OPENAIR_TEST_DB_URL is not set")
+	}
+
+	cfg := Config{URL: url, Notify: true, QueryTimeout: 5 * time.Second, WriteTimeout: 5 * time.Second}
+	d1, err := NewDb(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d1.Close()
+	d2, err := NewDb(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d2.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	listen := func(d *Db) chan FeedNotification {
+		c := make(chan FeedNotification, 10)
+		go func() {
+			_ = d.ListenFeeds(ctx, func(n FeedNotification) { c <- n }, nil)
+		}()
+		return c
+	}
+	c1, c2 := listen(d1), listen(d2)
+
+	// Give listeners time to start listening
+	time.Sleep(500 * time.Millisecond)
+
+	notify := func(ms []Measurement, commit bool) {
+		errRollback := errors.New("rollback")
+		err := d1.inTx(ctx, func(ctx context.Context, tx *sqlx.Tx) error {
+			if err := d1.notifyFeeds(ctx, tx, ms); err != nil {
+				return err
+			}
+			if !commit {
+				return errRollback
+			}
+			return nil
+		})
+		if err != nil && err != errRollback {
+			t.Fatal(err)
+		}
+	}
+
+	notify(notifyTestMeasurements(1, 1), false)
+	notify(notifyTestMeasurements(2, 2, 3), true)
+
+	select {
+	case n := <-c2:
+		want := FeedNotification{Instance: d1.instance, StationId: 2, Ids: []int64{2, 3}}
+		if !reflect.DeepEqual(n, want) {
+			t.Errorf("received notification %+v, want %+v", n, want)
+		}
+	case <-ctx.Done():
+		t.Fatal("notification is not received")
+	}
+
+	select {
+	case n := <-c1:
+		t.Errorf("received own notification %+v", n)
+	case n := <-c2:
+		t.Errorf("received unexpected notification %+v", n)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
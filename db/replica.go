@@ -0,0 +1,219 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultReplicaCheckInterval = 10 * time.Second
+
+// replica is a read-only database replica connection pool with its health state.
+type replica struct {
+	name string
+	sqlx *sqlx.DB
+
+	mu      sync.RWMutex
+	healthy bool
+}
+
+func (r *replica) isHealthy() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.healthy
+}
+
+func (r *replica) setHealthy(healthy bool) (changed bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	changed = r.healthy != healthy
+	r.healthy = healthy
+	return
+}
+
+// replicaSet is a set of read replicas with round-robin selection of healthy ones.
+type replicaSet struct {
+	replicas []*replica
+	maxLag   time.Duration
+	next     uint32
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// openReplicas opens connection pools to read replicas listed in cfg.
+// Every replica is connected with its own connection string and primary pool and timeout settings.
+func openReplicas(cfg Config) (*replicaSet, error) {
+	rs := &replicaSet{
+		maxLag: cfg.ReplicaMaxLag,
+		stop:   make(chan struct{}),
+	}
+
+	for i, url := range cfg.Replicas {
+		dsn, err := replicaConfig(cfg, url).DSN()
+		if err != nil {
+			rs.close()
+			return nil, fmt.Errorf("replica #%d: %v", i+1, err)
+		}
+
+		db, err := sqlx.Open("postgres", dsn)
+		if err != nil {
+			rs.close()
+			return nil, fmt.Errorf("replica #%d: %v", i+1, err)
+		}
+
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+		db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+		db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+		rs.replicas = append(rs.replicas, &replica{
+			name: fmt.Sprintf("#%d", i+1),
+			sqlx: db,
+		})
+	}
+
+	return rs, nil
+}
+
+// replicaConfig returns config of read replica with connection string url. Replica connection options
+// come from url alone, so primary credentials, database name and TLS mode don't override them,
+// only pool and timeout settings are inherited from primary config cfg.
+func replicaConfig(cfg Config, url string) Config {
+	return Config{
+		URL:              url,
+		ConnectTimeout:   cfg.ConnectTimeout,
+		StatementTimeout: cfg.StatementTimeout,
+		MaxOpenConns:     cfg.MaxOpenConns,
+		MaxIdleConns:     cfg.MaxIdleConns,
+		ConnMaxLifetime:  cfg.ConnMaxLifetime,
+		ConnMaxIdleTime:  cfg.ConnMaxIdleTime,
+		QueryTimeout:     cfg.QueryTimeout,
+		WriteTimeout:     cfg.WriteTimeout,
+	}
+}
+
+// pick returns next healthy replica connection pool in round-robin order, or nil if there are no healthy replicas.
+func (rs *replicaSet) pick() *sqlx.DB {
+	n := len(rs.replicas)
+	for i := 0; i < n; i++ {
+		r := rs.replicas[int(atomic.AddUint32(&rs.next, 1)-1)%n]
+		if r.isHealthy() {
+			return r.sqlx
+		}
+	}
+	return nil
+}
+
+// watch starts replicas health checking with given interval until replica set is closed.
+func (rs *replicaSet) watch(interval, timeout time.Duration) {
+	if interval <= 0 {
+		interval = defaultReplicaCheckInterval
+	}
+
+	rs.wg.Add(1)
+	go func() {
+		defer rs.wg.Done()
+
+		t := time.NewTicker(interval)
+		defer t.Stop()
+
+		for {
+			for _, r := range rs.replicas {
+				rs.check(r, timeout)
+			}
+			select {
+			case <-t.C:
+			case <-rs.stop:
+				return
+			}
+		}
+	}()
+}
+
+// replicaState is a replication state reported by replica.
+type replicaState struct {
+	InRecovery bool `db:"in_recovery"`
+	// Streaming is set if WAL receiver is connected to primary and streams WAL
+	Streaming bool
+	// CaughtUp is set if replica has replayed all received WAL
+	CaughtUp bool `db:"caught_up"`
+	// ReplayAge is the time in seconds since the last replayed transaction was committed on primary
+	ReplayAge float64 `db:"replay_age"`
+}
+
+// lag returns replication lag of replica state s, or error if replica can't be up to date.
+// Replica that has replayed all received WAL is considered up to date even if primary had no recent
+// transactions to replay, but only if its WAL receiver is streaming: replica disconnected from primary
+// has replayed all it received too.
+func (s replicaState) lag() (time.Duration, error) {
+	if !s.InRecovery {
+		return 0, nil
+	}
+	if !s.Streaming {
+		return 0, errors.New("WAL receiver is not streaming")
+	}
+	if s.CaughtUp {
+		return 0, nil
+	}
+	return time.Duration(s.ReplayAge * float64(time.Second)), nil
+}
+
+// check updates health state of replica r by querying its replication state. WAL receiver status
+// is visible to roles with pg_read_all_stats privileges only, so receiver process is assumed
+// to be streaming for other roles.
+func (rs *replicaSet) check(r *replica, timeout time.Duration) {
+	ctx, cancel := withTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var s replicaState
+	err := r.sqlx.QueryRowxContext(ctx, `SELECT pg_is_in_recovery() AS in_recovery,
+		EXISTS (SELECT 1 FROM pg_stat_wal_receiver WHERE COALESCE(status, 'streaming') = 'streaming') AS streaming,
+		COALESCE(pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn(), FALSE) AS caught_up,
+		COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0) AS replay_age`).
+		StructScan(&s)
+
+	var d time.Duration
+	if err == nil {
+		d, err = s.lag()
+	}
+	if err == nil && rs.maxLag > 0 && d > rs.maxLag {
+		err = fmt.Errorf("replication lag %v exceeds %v", d, rs.maxLag)
+	}
+
+	if r.setHealthy(err == nil) {
+		if err != nil {
+			log.Warnf("database replica %s is unhealthy: %v", r.name, err)
+		} else {
+			log.Infof("database replica %s is healthy, replication lag %v", r.name, d)
+		}
+	}
+}
+
+func (rs *replicaSet) close() {
+	close(rs.stop)
+	rs.wg.Wait()
+	for _, r := range rs.replicas {
+		_ = r.sqlx.Close()
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestResampling_TimestampSql(t *testing.T) {
+	const (
+		calendarSql = "DATE_TRUNC(?, TO_TIMESTAMP(EXTRACT(EPOCH FROM tstamp)) AT TIME ZONE ?) AT TIME ZONE ?"
+		stepSql     = "TO_TIMESTAMP(FLOOR(EXTRACT(EPOCH FROM tstamp) / ?) * ?)"
+	)
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	if err != nil {
+		t.Skipf("no time zone database: %v", err)
+	}
+	tests := []struct {
+		name string
+		rs   Resampling
+		sql  string
+		args []interface{}
+	}{
+		{"day utc", Resampling{Unit: ResampleDay}, calendarSql, []interface{}{"day", "UTC", "UTC"}},
+		{"week zone", Resampling{Unit: ResampleWeek, Location: moscow}, calendarSql,
+			[]interface{}{"week", "Europe/Moscow", "Europe/Moscow"}},
+		{"month ignores step", Resampling{Unit: ResampleMonth, Step: time.Hour, Location: time.UTC}, calendarSql,
+			[]interface{}{"month", "UTC", "UTC"}},
+		{"step", Resampling{Step: 15 * time.Minute}, stepSql, []interface{}{900, 900}},
+		{"step ignores zone", Resampling{Step: time.Hour, Location: moscow}, stepSql, []interface{}{3600, 3600}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sql, args := tt.rs.timestampSql()
+			if sql != tt.sql {
+				t.Errorf("timestampSql() sql = %q, want %q", sql, tt.sql)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("timestampSql() args = %v, want %v", args, tt.args)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// StatsPercentiles are percentiles of measurement variable values computed by StationStats.
+var StatsPercentiles = []float64{0.05, 0.25, 0.5, 0.75, 0.95}
+
+// statsVariables are database columns of measurement variables StationStats are computed for.
+var statsVariables = []string{"temperature", "humidity", "pressure", "pm25", "pm10", "aqi"}
+
+// minDailyMeanHours is the minimum number of hourly means to compute valid daily mean (75% coverage).
+const minDailyMeanHours = 18
+
+// VariableStats are statistics of measurement variable values.
+type VariableStats struct {
+	Count          int
+	Min, Max, Mean sql.NullFloat64
+	// Percentiles are values of StatsPercentiles percentiles, empty if there are no values
+	Percentiles []float64
+}
+
+// Exceedances are numbers of hourly and daily mean values exceeding a limit value.
+type Exceedances struct {
+	Hours int
+	Days  int
+	// ValidDays is the number of days with valid daily mean, i.e. at least 18 hourly means
+	ValidDays int
+}
+
+// StationStats are statistics of station measurements taken within time range.
+type StationStats struct {
+	// Count is the number of measurements
+	Count int
+	// Hours is the number of hours with measurements
+	Hours int
+	// Variables are statistics of measurement variables keyed by column name
+	Variables  map[string]VariableStats
+	Pm25, Pm10 Exceedances
+}
+
+// StationStats gets statistics of measurements of station with given ID taken within time range [from, to).
+// Hourly and daily mean PM values are checked for exceedances of pm25Limit and pm10Limit values,
+// hours and days are local ones of time zone tz.
+func (db *Db) StationStats(ctx context.Context, stationId int, from, to time.Time,
+	pm25Limit, pm10Limit float64, tz *time.Location) (*StationStats, error) {
+	ctx, cancel := withTimeout(ctx, db.queryTimeout)
+	defer cancel()
+
+	st := StationStats{Variables: make(map[string]VariableStats, len(statsVariables))}
+
+	cols := make([]string, 0, 1+5*len(statsVariables))
+	cols = append(cols, "COUNT(*)")
+	vss := make([]VariableStats, len(statsVariables))
+	dest := make([]interface{}, 0, cap(cols))
+	dest = append(dest, &st.Count)
+	for i, v := range statsVariables {
+		cols = append(cols, fmt.Sprintf("COUNT(%[1]s), MIN(%[1]s), MAX(%[1]s), AVG(%[1]s), "+
+			"PERCENTILE_CONT($4::FLOAT8[]) WITHIN GROUP (ORDER BY %[1]s)", v))
+		dest = append(dest, &vss[i].Count, &vss[i].Min, &vss[i].Max, &vss[i].Mean,
+			(*pq.Float64Array)(&vss[i].Percentiles))
+	}
+
+	err := db.reader().QueryRowContext(ctx, "SELECT "+strings.Join(cols, ", ")+
+		" FROM measurements WHERE station_id = $1 AND tstamp >= $2 AND tstamp < $3",
+		stationId, from, to, pq.Array(StatsPercentiles)).Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range statsVariables {
+		st.Variables[v] = vss[i]
+	}
+
+	err = db.reader().QueryRowContext(ctx, `WITH h AS (
+			SELECT DATE_TRUNC('hour', TO_TIMESTAMP(EXTRACT(EPOCH FROM tstamp)) AT TIME ZONE $7) hour,
+				AVG(pm25) pm25, AVG(pm10) pm10
+			FROM measurements WHERE station_id = $1 AND tstamp >= $2 AND tstamp < $3 GROUP BY 1
+		), d AS (
+			SELECT AVG(pm25) pm25, AVG(pm10) pm10, COUNT(pm25) pm25_hours, COUNT(pm10) pm10_hours
+			FROM h GROUP BY DATE_TRUNC('day', hour)
+		)
+		SELECT (SELECT COUNT(*) FROM h),
+			(SELECT COUNT(*) FROM h WHERE pm25 > $4), (SELECT COUNT(*) FROM h WHERE pm10 > $5),
+			(SELECT COUNT(*) FROM d WHERE pm25_hours >= $6 AND pm25 > $4),
+			(SELECT COUNT(*) FROM d WHERE pm10_hours >= $6 AND pm10 > $5),
+			(SELECT COUNT(*) FROM d WHERE pm25_hours >= $6), (SELECT COUNT(*) FROM d WHERE pm10_hours >= $6)`,
+		stationId, from, to, pm25Limit, pm10Limit, minDailyMeanHours, tz.String()).
+		Scan(&st.Hours, &st.Pm25.Hours, &st.Pm10.Hours, &st.Pm25.Days, &st.Pm10.Days,
+			&st.Pm25.ValidDays, &st.Pm10.ValidDays)
+	if err != nil {
+		return nil, err
+	}
+
+	return &st, nil
+}
+
+// ProfileValue is a mean value of measurement variable within hour of day or day of week.
+type ProfileValue struct {
+	// Period is an hour of day (0-23) or ISO day of week (1 is Monday, 7 is Sunday)
+	Period int
+	Mean   float64
+	Count  int
+}
+
+// StationProfile gets diurnal and weekly profiles of variable v of measurements of station with given ID
+// taken within time range [from, to): mean values per hour of day and day of week in time zone tz.
+// Periods without measurements are omitted.
+func (db *Db) StationProfile(ctx context.Context, stationId int, from, to time.Time, v string,
+	tz *time.Location) (hours []ProfileValue, days []ProfileValue, err error) {
+	if !isStatsVariable(v) {
+		return nil, nil, fmt.Errorf("unknown variable: %s", v)
+	}
+
+	ctx, cancel := withTimeout(ctx, db.queryTimeout)
+	defer cancel()
+
+	rows, err := db.reader().QueryContext(ctx, fmt.Sprintf(`WITH m AS (
+			SELECT TO_TIMESTAMP(EXTRACT(EPOCH FROM tstamp)) AT TIME ZONE $4 t, %[1]s v FROM measurements
+			WHERE station_id = $1 AND tstamp >= $2 AND tstamp < $3 AND %[1]s IS NOT NULL
+		)
+		SELECT FALSE, EXTRACT(HOUR FROM t)::INT, AVG(v), COUNT(*) FROM m GROUP BY 2
+		UNION ALL
+		SELECT TRUE, EXTRACT(ISODOW FROM t)::INT, AVG(v), COUNT(*) FROM m GROUP BY 2
+		ORDER BY 1, 2`, v), stationId, from, to, tz.String())
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var day bool
+		var pv ProfileValue
+		if err := rows.Scan(&day, &pv.Period, &pv.Mean, &pv.Count); err != nil {
+			return nil, nil, err
+		}
+		if day {
+			days = append(days, pv)
+		} else {
+			hours = append(hours, pv)
+		}
+	}
+
+	return hours, days, rows.Err()
+}
+
+func isStatsVariable(v string) bool {
+	for _, sv := range statsVariables {
+		if v == sv {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,195 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// createStationStatusesTable creates table of station status transitions recorded by stations monitor.
+const createStationStatusesTable = `CREATE TABLE IF NOT EXISTS station_statuses (
+		id BIGSERIAL PRIMARY KEY,
+		station_id INTEGER NOT NULL,
+		status TEXT NOT NULL,
+		tstamp TIMESTAMPTZ NOT NULL,
+		expected_interval INTEGER
+	);
+	CREATE INDEX IF NOT EXISTS station_statuses_station_id_tstamp_idx ON station_statuses (station_id, tstamp);
+	CREATE TABLE IF NOT EXISTS station_intervals (
+		station_id INTEGER PRIMARY KEY,
+		expected_interval INTEGER NOT NULL,
+		updated TIMESTAMPTZ NOT NULL
+	)`
+
+// StationStatus is a station status transition: station has got status at time Timestamp.
+type StationStatus struct {
+	StationId int `db:"station_id"`
+	Status    string
+	Timestamp time.Time `db:"tstamp"`
+	// ExpectedInterval is an expected station reporting interval in seconds at the time of transition
+	ExpectedInterval *int `db:"expected_interval"`
+}
+
+// CreateStationStatusesTable creates station status transitions table and table of current expected
+// station reporting intervals if they don't exist.
+func (db *Db) CreateStationStatusesTable(ctx context.Context) error {
+	ctx, cancel := withTimeout(ctx, db.writeTimeout)
+	defer cancel()
+
+	_, err := db.sqlx.ExecContext(ctx, createStationStatusesTable)
+	return err
+}
+
+// StationReportingIntervals gets median intervals between consecutive measurements of stations
+// taken since given time, keyed by station ID. Stations with less than two measurements are omitted.
+func (db *Db) StationReportingIntervals(ctx context.Context, since time.Time) (map[int]time.Duration, error) {
+	ctx, cancel := withTimeout(ctx, db.queryTimeout)
+	defer cancel()
+
+	rows, err := db.reader().QueryContext(ctx, `SELECT station_id, PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY gap)
+		FROM (
+			SELECT station_id,
+				EXTRACT(EPOCH FROM tstamp - LAG(tstamp) OVER (PARTITION BY station_id ORDER BY tstamp)) gap
+			FROM measurements WHERE tstamp >= $1
+		) g
+		WHERE gap > 0 GROUP BY station_id`, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	is := make(map[int]time.Duration)
+	for rows.Next() {
+		var id int
+		var sec float64
+		if err := rows.Scan(&id, &sec); err != nil {
+			return nil, err
+		}
+		is[id] = time.Duration(sec * float64(time.Second))
+	}
+
+	return is, rows.Err()
+}
+
+// SetStationIntervals records current expected reporting intervals of stations is, keyed by station ID,
+// which were learned at time updated. Intervals of other stations recorded before are removed.
+func (db *Db) SetStationIntervals(ctx context.Context, is map[int]time.Duration, updated time.Time) error {
+	ids := make([]int64, 0, len(is))
+	secs := make([]int64, 0, len(is))
+	for id, i := range is {
+		ids = append(ids, int64(id))
+		secs = append(secs, int64(i.Seconds()))
+	}
+
+	return db.inTx(ctx, func(ctx context.Context, tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, "DELETE FROM station_intervals WHERE station_id <> ALL($1::INTEGER[])",
+			pq.Array(ids))
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO station_intervals (station_id, expected_interval, updated)
+			SELECT id, sec, $3 FROM UNNEST($1::INTEGER[], $2::INTEGER[]) AS i (id, sec)
+			ON CONFLICT (station_id) DO UPDATE SET expected_interval = EXCLUDED.expected_interval,
+				updated = EXCLUDED.updated`,
+			pq.Array(ids), pq.Array(secs), updated)
+		return err
+	})
+}
+
+// StationInterval gets current expected reporting interval of station with given ID recorded by stations
+// monitor, returns zero if it is not recorded.
+func (db *Db) StationInterval(ctx context.Context, stationId int) (time.Duration, error) {
+	ctx, cancel := withTimeout(ctx, db.queryTimeout)
+	defer cancel()
+
+	var sec int
+	err := db.reader().GetContext(ctx, &sec,
+		"SELECT expected_interval FROM station_intervals WHERE station_id = $1", stationId)
+	if err == sql.ErrNoRows || isUndefinedTable(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(sec) * time.Second, nil
+}
+
+// StationStatuses gets current statuses of stations, i.e. their last status transitions, keyed by station ID.
+// It returns empty map if no statuses are recorded yet.
+func (db *Db) StationStatuses(ctx context.Context) (map[int]StationStatus, error) {
+	ctx, cancel := withTimeout(ctx, db.queryTimeout)
+	defer cancel()
+
+	var sss []StationStatus
+	err := db.reader().SelectContext(ctx, &sss, `SELECT DISTINCT ON (station_id)
+		station_id, status, tstamp, expected_interval FROM station_statuses ORDER BY station_id, tstamp DESC, id DESC`)
+	if err != nil && !isUndefinedTable(err) {
+		return nil, err
+	}
+
+	ss := make(map[int]StationStatus, len(sss))
+	for _, s := range sss {
+		ss[s.StationId] = s
+	}
+
+	return ss, nil
+}
+
+// AddStationStatuses records station status transitions sss. Transition is skipped if it doesn't change
+// station status, so several monitors sharing the database don't record duplicated transitions.
+func (db *Db) AddStationStatuses(ctx context.Context, sss []StationStatus) error {
+	return db.inTx(ctx, func(ctx context.Context, tx *sqlx.Tx) error {
+		for _, s := range sss {
+			_, err := tx.ExecContext(ctx, `INSERT INTO station_statuses (station_id, status, tstamp, expected_interval)
+				SELECT $1, $2, $3, $4 WHERE NOT EXISTS (
+					SELECT 1 FROM (
+						SELECT status FROM station_statuses WHERE station_id = $1 ORDER BY tstamp DESC, id DESC LIMIT 1
+					) l WHERE l.status = $2
+				)`, s.StationId, s.Status, s.Timestamp, s.ExpectedInterval)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// StationStatusHistory gets status transitions of station with given ID within time range [from, to)
+// sorted by time, along with the last transition before from, if any, which is the status at time from.
+func (db *Db) StationStatusHistory(ctx context.Context, stationId int, from, to time.Time) ([]StationStatus, error) {
+	ctx, cancel := withTimeout(ctx, db.queryTimeout)
+	defer cancel()
+
+	var sss []StationStatus
+	err := db.reader().SelectContext(ctx, &sss, `(
+			SELECT station_id, status, tstamp, expected_interval FROM station_statuses
+			WHERE station_id = $1 AND tstamp < $2 ORDER BY tstamp DESC, id DESC LIMIT 1
+		) UNION ALL (
+			SELECT station_id, status, tstamp, expected_interval FROM station_statuses
+			WHERE station_id = $1 AND tstamp >= $2 AND tstamp < $3
+		)
+		ORDER BY tstamp`, stationId, from, to)
+	if err != nil && !isUndefinedTable(err) {
+		return nil, err
+	}
+
+	return sss, nil
+}
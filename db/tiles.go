@@ -0,0 +1,96 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+const (
+	// MaxTileZoom is the maximum zoom level of vector tiles
+	MaxTileZoom = 22
+	// TileExtent is the vector tile extent in tile coordinate space
+	TileExtent = 4096
+	// TileBuffer is the size of vector tile buffer around tile bounds in tile coordinate space
+	TileBuffer = 64
+
+	// tileClusterMaxZoom is the zoom level below which stations are clustered
+	tileClusterMaxZoom = 12
+	// tileClusterCells is the number of cluster grid cells along tile side
+	tileClusterCells = 16
+	// webMercatorHalfSize is the half size of Web Mercator (EPSG:3857) projected bounds
+	webMercatorHalfSize = 20037508.342789244
+)
+
+// tileStationsQuery selects stations within buffered tile envelope along with their last measurement
+// taken within given period.
+const tileStationsQuery = `WITH b AS (
+		SELECT ST_TileEnvelope($1, $2, $3) AS env, ST_TileEnvelope($1, $2, $3, margin => $4) AS menv
+	), s AS (
+		SELECT s.id, s.description, ST_Transform(s.location, 3857) AS geom,
+			m.aqi, m.pm25, m.pm10, m.tstamp
+		FROM stations s CROSS JOIN b
+		LEFT JOIN LATERAL (
+			SELECT aqi, pm25, pm10, tstamp FROM measurements
+			WHERE station_id = s.id AND tstamp > NOW() - $5 * INTERVAL '1 SECOND'
+			ORDER BY tstamp DESC LIMIT 1
+		) m ON TRUE
+		WHERE s.location && ST_Transform(b.menv, 4326) AND (s.is_public OR $6)
+	)`
+
+const tileQuery = tileStationsQuery + `
+	SELECT ST_AsMVT(t, 'stations', $7, 'geom', 'id') FROM (
+		SELECT s.id, s.description, s.aqi, s.pm25, s.pm10, EXTRACT(EPOCH FROM s.tstamp)::BIGINT AS tstamp,
+			ST_AsMVTGeom(s.geom, b.env, $7, $8, TRUE) AS geom
+		FROM s CROSS JOIN b
+	) t`
+
+const tileClusterQuery = tileStationsQuery + `
+	SELECT ST_AsMVT(t, 'stations', $7, 'geom') FROM (
+		SELECT COUNT(*) AS count, CASE WHEN COUNT(*) = 1 THEN MIN(s.id) END AS station_id,
+			ROUND(AVG(s.aqi))::INT AS aqi, MAX(s.aqi) AS aqi_max,
+			ROUND(AVG(s.pm25)::NUMERIC, 1)::REAL AS pm25, ROUND(AVG(s.pm10)::NUMERIC, 1)::REAL AS pm10,
+			ST_AsMVTGeom(ST_Centroid(ST_Collect(s.geom)), b.env, $7, $8, TRUE) AS geom
+		FROM s CROSS JOIN b
+		GROUP BY ST_SnapToGrid(s.geom, $9, $10, $11, $11), b.env
+	) t`
+
+// StationsTile gets Mapbox Vector Tile z/x/y with stations layer. Stations are clustered on
+// grid at low zoom levels, so clusters have count of stations and their average AQI instead of station data.
+// mlast defines the maximum age of last measurement to include in tile.
+// sall, if true, will include all stations, otherwise public stations only.
+func (db *Db) StationsTile(ctx context.Context, z, x, y int, mlast time.Duration, sall bool) ([]byte, error) {
+	ctx, cancel := withTimeout(ctx, db.queryTimeout)
+	defer cancel()
+
+	margin := float64(TileBuffer) / TileExtent
+	args := []interface{}{z, x, y, margin, int(mlast.Seconds()), sall, TileExtent, TileBuffer}
+
+	query := tileQuery
+	if z < tileClusterMaxZoom {
+		cell := 2 * webMercatorHalfSize / math.Exp2(float64(z)) / tileClusterCells
+		args = append(args, -webMercatorHalfSize, -webMercatorHalfSize, cell)
+		query = tileClusterQuery
+	}
+
+	var tile []byte
+	if err := db.reader().GetContext(ctx, &tile, query, args...); err != nil {
+		return nil, err
+	}
+
+	return tile, nil
+}
@@ -0,0 +1,79 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package event
+
+import (
+	"sync"
+
+	"github.com/openairtech/apiserver/db"
+)
+
+// Bus delivers station feed events to subscribers once feeds are written to database.
+// Feed events carry inserted measurements only.
+type Bus struct {
+	mu   sync.RWMutex
+	subs []func(f db.Feed)
+}
+
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Subscribe adds subscriber function f to be called on every feed published.
+// Subscriber functions are called synchronously, so they must not block.
+func (b *Bus) Subscribe(f func(f db.Feed)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs = append(b.subs, f)
+}
+
+// Publish delivers feed f to all subscribers. It is safe to call Publish on nil bus.
+func (b *Bus) Publish(f db.Feed) {
+	if b == nil {
+		return
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, s := range b.subs {
+		s(f)
+	}
+}
+
+// Feeds groups inserted measurements ms by station and returns feeds of stations updated by feeds fs
+// carrying these measurements only.
+func Feeds(fs []db.Feed, ms []db.Measurement) []db.Feed {
+	sms := make(map[int][]db.Measurement)
+	for _, m := range ms {
+		id := int(m.StationId.Int64)
+		sms[id] = append(sms[id], m)
+	}
+
+	var rfs []db.Feed
+	idx := make(map[int]int)
+	for _, f := range fs {
+		f.Measurements = sms[f.StationId]
+		if i, ok := idx[f.StationId]; ok {
+			// Keep the latest station data
+			if f.Seen.After(rfs[i].Seen) {
+				rfs[i] = f
+			}
+			continue
+		}
+		idx[f.StationId] = len(rfs)
+		rfs = append(rfs, f)
+	}
+
+	return rfs
+}
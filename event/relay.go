@@ -0,0 +1,38 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package event
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/openairtech/apiserver/db"
+)
+
+// Relay publishes feeds written to database d by other API server instances to bus b until ctx is done.
+// reconnected, if set, is called when database connection is reestablished after being lost.
+func Relay(ctx context.Context, d *db.Db, b *Bus, reconnected func()) error {
+	return d.ListenFeeds(ctx, func(n db.FeedNotification) {
+		f, err := d.NotifiedFeed(ctx, n)
+		if err != nil {
+			log.Errorf("can't get notified feed of station %d: %v", n.StationId, err)
+			return
+		}
+		if len(f.Measurements) > 0 {
+			b.Publish(*f)
+		}
+	}, reconnected)
+}
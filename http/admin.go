@@ -0,0 +1,76 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// adminHiddenVars are names of expvar variables that are never published: command line contains
+// database credentials passed by flags, memory statistics are not the server metrics.
+var adminHiddenVars = map[string]bool{"cmdline": true, "memstats": true}
+
+// AdminServer is an admin HTTP server publishing server metrics on localhost.
+type AdminServer struct {
+	http *http.Server
+}
+
+// NewAdminServer creates admin server listening on localhost port.
+func NewAdminServer(port int) *AdminServer {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/vars", metricsHandler)
+
+	return &AdminServer{
+		http: &http.Server{
+			Addr:         fmt.Sprintf("127.0.0.1:%d", port),
+			WriteTimeout: 15 * time.Second,
+			ReadTimeout:  15 * time.Second,
+			Handler:      mux,
+		},
+	}
+}
+
+func (s *AdminServer) Run() error {
+	if err := s.http.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (s *AdminServer) Close() error {
+	return s.http.Close()
+}
+
+// metricsHandler writes published expvar variables as JSON object, same as expvar.Handler,
+// except hidden ones.
+func metricsHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprint(w, "{")
+	first := true
+	expvar.Do(func(kv expvar.KeyValue) {
+		if adminHiddenVars[kv.Key] {
+			return
+		}
+		if !first {
+			fmt.Fprint(w, ",")
+		}
+		first = false
+		fmt.Fprintf(w, "\n%q: %s", kv.Key, kv.Value)
+	})
+	fmt.Fprint(w, "\n}\n")
+}
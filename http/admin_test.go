@@ -0,0 +1,44 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	if expvar.Get("admin_test") == nil {
+		expvar.NewInt("admin_test").Set(42)
+	}
+
+	w := httptest.NewRecorder()
+	metricsHandler(w, httptest.NewRequest("GET", "/debug/vars", nil))
+
+	var vars map[string]json.RawMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &vars); err != nil {
+		t.Fatalf("metrics are not valid JSON: %v\n%s", err, w.Body.String())
+	}
+	if string(vars["admin_test"]) != "42" {
+		t.Errorf("admin_test = %s, want 42", vars["admin_test"])
+	}
+	for name := range adminHiddenVars {
+		if _, ok := vars[name]; ok {
+			t.Errorf("hidden variable %s is published", name)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/openairtech/api"
+	"github.com/openairtech/apiserver/db"
+	httputil "github.com/openairtech/apiserver/http/util"
+)
+
+// maxRegionSize is the maximum size of region polygon in request body
+const maxRegionSize = 1 << 20
+
+// AreaInfo is a named area that can be used to filter stations.
+type AreaInfo struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+	// BBox is an area bounding box [min_long, min_lat, max_long, max_lat]
+	BBox       []float64       `json:"bbox"`
+	Properties json.RawMessage `json:"properties,omitempty"`
+}
+
+// AreasResult is a result of areas query.
+type AreasResult struct {
+	api.Result
+	Areas []AreaInfo `json:"areas"`
+}
+
+// AreasGetHandler handles named areas requests.
+func AreasGetHandler(db *db.Db) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		das, err := db.Areas(r.Context())
+		if err != nil {
+			m := fmt.Sprintf("can't get areas: %v", err)
+			writeResult(w, api.StatusServerError, m)
+			log.Error(m)
+			return
+		}
+
+		as := make([]AreaInfo, 0, len(das))
+		for _, da := range das {
+			as = append(as, AreaInfo{
+				Id:         da.Id,
+				Name:       da.Name,
+				BBox:       da.BBox,
+				Properties: da.Properties,
+			})
+		}
+
+		httputil.WriteJsonResponse(w, AreasResult{
+			Result: api.Result{Status: api.StatusOk},
+			Areas:  as,
+		})
+	})
+}
+
+// parseRegion parses region to filter stations: either named area set by area parameter,
+// or polygon in WKT or GeoJSON format in POST request body. It returns nil if region is not set.
+func parseRegion(r *http.Request) (*db.Region, error) {
+	area := r.URL.Query().Get("area")
+
+	var body []byte
+	if r.Method == http.MethodPost {
+		var err error
+		if body, err = io.ReadAll(io.LimitReader(r.Body, maxRegionSize+1)); err != nil {
+			return nil, fmt.Errorf("can't read region: %v", err)
+		}
+		if len(body) > maxRegionSize {
+			return nil, fmt.Errorf("region is too large (maximum size is %d bytes)", maxRegionSize)
+		}
+		body = bytes.TrimSpace(body)
+	}
+
+	switch {
+	case area != "" && len(body) > 0:
+		return nil, errors.New("'area' parameter can't be used along with region polygon")
+	case area != "":
+		return &db.Region{Area: area}, nil
+	case len(body) == 0:
+		if r.Method == http.MethodPost {
+			return nil, errors.New("region polygon not set")
+		}
+		return nil, nil
+	case body[0] == '{':
+		g, err := polygonGeoJson(body)
+		if err != nil {
+			return nil, err
+		}
+		return &db.Region{GeoJSON: string(g)}, nil
+	default:
+		wkt := string(body)
+		if t := strings.ToUpper(wkt); !strings.HasPrefix(t, "POLYGON") && !strings.HasPrefix(t, "MULTIPOLYGON") {
+			return nil, errors.New("region must be POLYGON or MULTIPOLYGON in WKT format, or GeoJSON geometry")
+		}
+		return &db.Region{WKT: wkt}, nil
+	}
+}
+
+func isAreaNotFound(err error) bool {
+	return errors.Is(err, db.ErrAreaNotFound)
+}
+
+func isInvalidRegion(err error) bool {
+	return errors.Is(err, db.ErrInvalidRegion)
+}
+
+// geoJsonObject is a GeoJSON object of any type.
+type geoJsonObject struct {
+	Type     string          `json:"type"`
+	Geometry json.RawMessage `json:"geometry"`
+	Features []geoJsonObject `json:"features"`
+}
+
+// polygonGeoJson gets polygon geometry from GeoJSON geometry, feature or feature collection of single feature g.
+func polygonGeoJson(g []byte) ([]byte, error) {
+	var o geoJsonObject
+	if err := json.Unmarshal(g, &o); err != nil {
+		return nil, fmt.Errorf("can't parse region GeoJSON: %v", err)
+	}
+
+	switch o.Type {
+	case "FeatureCollection":
+		if len(o.Features) != 1 {
+			return nil, errors.New("region GeoJSON feature collection must have single feature")
+		}
+		o = o.Features[0]
+		if o.Type != "Feature" {
+			return nil, fmt.Errorf("unexpected region GeoJSON feature type: %s", o.Type)
+		}
+		fallthrough
+	case "Feature":
+		g = o.Geometry
+		if err := json.Unmarshal(g, &o); err != nil {
+			return nil, fmt.Errorf("can't parse region GeoJSON geometry: %v", err)
+		}
+	}
+
+	if o.Type != "Polygon" && o.Type != "MultiPolygon" {
+		return nil, fmt.Errorf("region GeoJSON geometry must be Polygon or MultiPolygon, not %s", o.Type)
+	}
+
+	return g, nil
+}
@@ -0,0 +1,61 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"time"
+
+	"github.com/openairtech/apiserver/db"
+)
+
+const (
+	// liveMaxAge is the client cache lifetime of responses which data may be changed by new measurements
+	liveMaxAge = 30 * time.Second
+	// historicalMaxAge is the client cache lifetime of responses with historical data
+	historicalMaxAge = 24 * time.Hour
+	// historicalAge is the age of data that is not expected to be changed by new measurements anymore
+	historicalAge = 24 * time.Hour
+)
+
+// cacheMaxAge returns client cache lifetime of response with data up to time t, or up to now if t is nil.
+func cacheMaxAge(t *time.Time) time.Duration {
+	if t != nil && t.Before(time.Now().Add(-historicalAge)) {
+		return historicalMaxAge
+	}
+	return liveMaxAge
+}
+
+// newestMeasurement returns the newest timestamp of measurements ms, or zero time if there are no measurements.
+func newestMeasurement(ms []db.Measurement) time.Time {
+	var t time.Time
+	for _, m := range ms {
+		if m.Timestamp != nil && m.Timestamp.After(t) {
+			t = *m.Timestamp
+		}
+	}
+	return t
+}
+
+// newestStationMeasurement returns the newest timestamp of last measurements of stations ss,
+// or zero time if there are no measurements.
+func newestStationMeasurement(ss []db.Station) time.Time {
+	var t time.Time
+	for _, s := range ss {
+		if s.Measurement.Timestamp != nil && s.Measurement.Timestamp.After(t) {
+			t = *s.Measurement.Timestamp
+		}
+	}
+	return t
+}
@@ -0,0 +1,200 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/openairtech/apiserver/db"
+)
+
+const (
+	formatCsv    = "csv"
+	formatNdjson = "ndjson"
+
+	timeFormatUnix    = "unix"
+	timeFormatIso8601 = "iso8601"
+)
+
+// measurementVars are names of all measurement variables in export column order.
+var measurementVars = []string{"temperature", "humidity", "pressure", "pm25", "pm10", "aqi"}
+
+// exportFormats maps measurements export formats to their content types and file extensions.
+var exportFormats = map[string]struct{ contentType, ext string }{
+	formatCsv:    {"text/csv; charset=utf-8", "csv"},
+	formatNdjson: {"application/x-ndjson", "ndjson"},
+}
+
+// measurementsExporter writes measurements to response in export format. Response header is written
+// on the first measurement export or on close, so error response can be sent until then.
+type measurementsExporter struct {
+	w          http.ResponseWriter
+	format     string
+	timeFormat string
+	filename   string
+	// vars are names of exported measurement variables
+	vars []string
+
+	bw      *bufio.Writer
+	cw      *csv.Writer
+	started bool
+}
+
+// newMeasurementsExporter creates exporter of measurement variables vars (all variables if empty) to response
+// w in given format. Exported file name is filename with format extension.
+func newMeasurementsExporter(w http.ResponseWriter, format, timeFormat, filename string,
+	vars []string) (*measurementsExporter, error) {
+	if _, err := db.MeasurementDbColumns(vars); err != nil {
+		return nil, err
+	}
+
+	// Timestamp is always exported first
+	var evs []string
+	for _, v := range vars {
+		if v != "timestamp" {
+			evs = append(evs, v)
+		}
+	}
+	if len(vars) == 0 {
+		evs = measurementVars
+	}
+
+	return &measurementsExporter{
+		w:          w,
+		format:     format,
+		timeFormat: timeFormat,
+		filename:   filename + "." + exportFormats[format].ext,
+		vars:       evs,
+	}, nil
+}
+
+// start writes response header and CSV header row.
+func (e *measurementsExporter) start() error {
+	e.started = true
+
+	h := e.w.Header()
+	h.Set("Content-Type", exportFormats[e.format].contentType)
+	h.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", e.filename))
+	e.w.WriteHeader(http.StatusOK)
+
+	e.bw = bufio.NewWriter(e.w)
+	if e.format != formatCsv {
+		return nil
+	}
+	e.cw = csv.NewWriter(e.bw)
+	return e.cw.Write(append([]string{"timestamp"}, e.vars...))
+}
+
+// Export writes measurement m.
+func (e *measurementsExporter) Export(m *db.Measurement) error {
+	if !e.started {
+		if err := e.start(); err != nil {
+			return err
+		}
+	}
+
+	if e.format == formatCsv {
+		r := make([]string, 0, len(e.vars)+1)
+		r = append(r, e.timestamp(m.Timestamp))
+		for _, v := range e.vars {
+			r = append(r, exportValue(m, v))
+		}
+		return e.cw.Write(r)
+	}
+
+	r := make(map[string]interface{}, len(e.vars)+1)
+	if m.Timestamp != nil {
+		if e.timeFormat == timeFormatIso8601 {
+			r["timestamp"] = e.timestamp(m.Timestamp)
+		} else {
+			r["timestamp"] = m.Timestamp.Unix()
+		}
+	}
+	for _, v := range e.vars {
+		if s := exportValue(m, v); s != "" {
+			r[v] = json.Number(s)
+		}
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = e.bw.Write(append(b, '\n'))
+	return err
+}
+
+// Close flushes exported measurements to response.
+func (e *measurementsExporter) Close() error {
+	if !e.started {
+		if err := e.start(); err != nil {
+			return err
+		}
+	}
+	if e.cw != nil {
+		e.cw.Flush()
+		if err := e.cw.Error(); err != nil {
+			return err
+		}
+	}
+	return e.bw.Flush()
+}
+
+func (e *measurementsExporter) timestamp(t *time.Time) string {
+	switch {
+	case t == nil:
+		return ""
+	case e.timeFormat == timeFormatIso8601:
+		return t.UTC().Format(time.RFC3339)
+	default:
+		return strconv.FormatInt(t.Unix(), 10)
+	}
+}
+
+// exportValue returns value of measurement m variable v as a string, empty if value is not set.
+func exportValue(m *db.Measurement, v string) string {
+	f, ok := measurementValue(*m, v)
+	if !ok {
+		return ""
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// parseExportFormat parses measurements response format (json, csv or ndjson) and timestamp format
+// (unix or iso8601) of export formats.
+func parseExportFormat(format, timeFormat string) (string, string, error) {
+	switch format {
+	case "":
+		format = formatJson
+	case formatJson, formatCsv, formatNdjson:
+	default:
+		return "", "", fmt.Errorf("unsupported format: %s", format)
+	}
+
+	switch timeFormat {
+	case "":
+		timeFormat = timeFormatUnix
+	case timeFormatUnix, timeFormatIso8601:
+	default:
+		return "", "", fmt.Errorf("unsupported time format: %s", timeFormat)
+	}
+
+	return format, timeFormat, nil
+}
@@ -15,8 +15,10 @@
 package v1
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
@@ -26,9 +28,16 @@ import (
 	"github.com/openairtech/api"
 	"github.com/openairtech/apiserver/aqi"
 	"github.com/openairtech/apiserver/db"
+	"github.com/openairtech/apiserver/event"
+	"github.com/openairtech/apiserver/ingest"
 )
 
-func FeederHandler(db *db.Db) http.Handler {
+// feedRetryAfter is the time after which feeds rejected due to full ingestion queue are to be retried
+const feedRetryAfter = 30 * time.Second
+
+// FeederHandler handles station data feeds. Feeds are written to database synchronously
+// and published to event bus, or queued to ingestion queue q if it is not nil.
+func FeederHandler(db *db.Db, q *ingest.Queue, bus *event.Bus) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		decoder := json.NewDecoder(r.Body)
 
@@ -40,7 +49,7 @@ func FeederHandler(db *db.Db) http.Handler {
 			return
 		}
 
-		s, err := db.StationByTokenId(f.TokenId)
+		s, err := feederStation(r.Context(), db, q, f.TokenId)
 		if err != nil {
 			em := fmt.Sprintf("can't get station by token id [%s]: %v", f.TokenId, err)
 			writeResult(w, api.StatusBadRequest, em)
@@ -49,34 +58,72 @@ func FeederHandler(db *db.Db) http.Handler {
 		}
 
 		ms := stationDbMeasurements(s, f)
-		if err := db.AddMeasurements(s, ms); err != nil {
+
+		// Update station data along with measurements
+		su := s.Copy()
+		seen := time.Now()
+		su.Seen = &seen
+		su.Version = sql.NullString{String: f.Version, Valid: len(f.Version) > 0}
+
+		if q != nil {
+			if err := q.Enqueue(r.Context(), stationFeed(&su, ms)); err != nil {
+				em := fmt.Sprintf("station [%d]: can't queue %d measurement(s): %v", s.Id, len(ms), err)
+				if errors.Is(err, ingest.ErrQueueFull) {
+					writeRetryLater(w, em, feedRetryAfter)
+					log.Warn(em)
+					return
+				}
+				writeResult(w, api.StatusServerError, em)
+				log.Error(em)
+				return
+			}
+			log.Debugf("station [%d]: queued %d measurement(s)", s.Id, len(ms))
+			writeResult(w, api.StatusOk, fmt.Sprintf("queued %d measurement(s)", len(ms)))
+			return
+		}
+
+		ims, err := db.FeedStation(r.Context(), s, &su, ms)
+		if err != nil {
 			em := fmt.Sprintf("station [%d]: can't add %d measurement(s): %v", s.Id, len(ms), err)
 			writeResult(w, api.StatusServerError, em)
+			log.Error(em)
 			return
 		}
 
-		m := fmt.Sprintf("station [%d]: added %d measurement(s)", s.Id, len(ms))
+		bus.Publish(stationFeed(&su, ims))
+
+		n := len(ims)
+		m := fmt.Sprintf("station [%d]: added %d measurement(s), skipped %d duplicate(s)", s.Id, n, len(ms)-n)
 		if len(ms) > 1 {
 			log.Info(m)
 		} else {
 			log.Debug(m)
 		}
 
-		// Update station data
-		su := s.Copy()
-		seen := time.Now()
-		su.Seen = &seen
-		su.Version = sql.NullString{String: f.Version, Valid: len(f.Version) > 0}
-		if err := db.UpdateStation(s, &su); err != nil {
-			em := fmt.Sprintf("station [%d]: can't update station data: %v", s.Id, err)
-			writeResult(w, api.StatusServerError, em)
-			return
-		}
-
-		writeResult(w, api.StatusOk, "")
+		writeResult(w, api.StatusOk, fmt.Sprintf("added %d measurement(s), skipped %d duplicate(s)", n, len(ms)-n))
 	})
 }
 
+// feederStation gets station by its token ID from ingestion queue q, if it is set, or from database d otherwise.
+func feederStation(ctx context.Context, d *db.Db, q *ingest.Queue, tokenId string) (*db.Station, error) {
+	if q != nil {
+		return q.Station(ctx, tokenId)
+	}
+	return d.StationByTokenId(ctx, tokenId)
+}
+
+// stationFeed returns feed of measurements ms along with updated station su data.
+func stationFeed(su *db.Station, ms []db.Measurement) db.Feed {
+	return db.Feed{
+		StationId:    su.Id,
+		Version:      su.Version,
+		Seen:         *su.Seen,
+		Measurements: ms,
+		Location:     su.Location,
+		IsPublic:     su.IsPublic,
+	}
+}
+
 func stationDbMeasurements(station *db.Station, f api.FeederData) []db.Measurement {
 	var ms []db.Measurement
 
@@ -94,11 +141,7 @@ func stationDbMeasurements(station *db.Station, f api.FeederData) []db.Measureme
 		}
 
 		// Use provided AQI value or compute it from PM values
-		if am.Aqi == nil && am.Pm10 != nil && am.Pm25 != nil {
-			pm := aqi.PM{Pm25: *am.Pm25, Pm10: *am.Pm10}
-			ac := pm.Aqi()
-			am.Aqi = &ac
-		}
+		am.Aqi = aqi.MeasurementAqi(am)
 
 		ms = append(ms, db.NewMeasurement(station, am))
 	}
@@ -0,0 +1,120 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/openairtech/api"
+	"github.com/openairtech/apiserver/db"
+)
+
+const (
+	formatJson    = "json"
+	formatGeoJson = "geojson"
+
+	geoJsonContentType = "application/geo+json"
+)
+
+// FeatureCollection is a GeoJSON (RFC 7946) feature collection.
+type FeatureCollection struct {
+	Type     string    `json:"type"`
+	Features []Feature `json:"features"`
+	// Next is a cursor of the next page of paginated stations query
+	Next string `json:"next,omitempty"`
+}
+
+// Feature is a GeoJSON feature.
+type Feature struct {
+	Type       string      `json:"type"`
+	Id         int         `json:"id"`
+	Geometry   Point       `json:"geometry"`
+	Properties interface{} `json:"properties"`
+}
+
+// Point is a GeoJSON point geometry.
+type Point struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+// StationProperties are GeoJSON feature properties of station.
+type StationProperties struct {
+	Id              int              `json:"id"`
+	Created         api.UnixTime     `json:"created"`
+	Seen            *api.UnixTime    `json:"seen,omitempty"`
+	Description     string           `json:"description"`
+	IsPublic        *bool            `json:"is_public,omitempty"`
+	LastMeasurement *api.Measurement `json:"last_measurement,omitempty"`
+	// Aqi duplicates last measurement AQI to ease feature styling
+	Aqi *int `json:"aqi,omitempty"`
+	// Distance is the distance in meters from the point of interest of near stations query
+	Distance *float64 `json:"distance,omitempty"`
+}
+
+// responseFormat gets format of response to request r from its format parameter or Accept header.
+func responseFormat(r *http.Request) (string, error) {
+	switch f := r.URL.Query().Get("format"); f {
+	case formatJson, formatGeoJson:
+		return f, nil
+	case "":
+	default:
+		return "", fmt.Errorf("unsupported format: %s", f)
+	}
+
+	for _, a := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mt, _, err := mime.ParseMediaType(strings.TrimSpace(a)); err == nil && mt == geoJsonContentType {
+			return formatGeoJson, nil
+		}
+	}
+
+	return formatJson, nil
+}
+
+// stationsFeatureCollection converts stations dss to GeoJSON feature collection.
+func stationsFeatureCollection(dss []db.Station) FeatureCollection {
+	fc := FeatureCollection{
+		Type:     "FeatureCollection",
+		Features: make([]Feature, 0, len(dss)),
+	}
+	for _, ds := range dss {
+		as := ds.ApiStation()
+		p := StationProperties{
+			Id:              ds.Id,
+			Created:         as.Created,
+			Seen:            as.Seen,
+			Description:     as.Description,
+			IsPublic:        as.IsPublic,
+			LastMeasurement: as.LastMeasurement,
+			Distance:        ds.Distance,
+		}
+		if as.LastMeasurement != nil {
+			p.Aqi = as.LastMeasurement.Aqi
+		}
+		fc.Features = append(fc.Features, Feature{
+			Type: "Feature",
+			Id:   ds.Id,
+			Geometry: Point{
+				Type:        "Point",
+				Coordinates: [2]float64{ds.Location.X, ds.Location.Y},
+			},
+			Properties: p,
+		})
+	}
+	return fc
+}
@@ -0,0 +1,331 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/openairtech/api"
+	"github.com/openairtech/apiserver/aqi"
+	"github.com/openairtech/apiserver/cache"
+	"github.com/openairtech/apiserver/db"
+	httputil "github.com/openairtech/apiserver/http/util"
+	"github.com/openairtech/apiserver/raster"
+	"github.com/openairtech/apiserver/util"
+)
+
+const (
+	heatmapTileSize = 256
+	// heatmapMaxGridSize is the maximum heatmap grid width and height
+	heatmapMaxGridSize = 1024
+
+	heatmapDefaultPower       = 2
+	heatmapDefaultMaxDistance = 5000
+	heatmapMaxMaxDistance     = 100000
+
+	formatGeoTiff = "geotiff"
+)
+
+// GridResult is an interpolated measurement variable grid.
+type GridResult struct {
+	api.Result
+	Variable string `json:"variable"`
+	// BBox is a grid bounding box [min_long, min_lat, max_long, max_lat]
+	BBox   [4]float64 `json:"bbox"`
+	Width  int        `json:"width"`
+	Height int        `json:"height"`
+	// Values are stored row by row starting from the north-west corner, missing values are null
+	Values []*float64 `json:"values"`
+}
+
+// heatmapParams are common heatmap request parameters.
+type heatmapParams struct {
+	variable    string
+	power       float64
+	maxDistance float64
+	mlast       time.Duration
+	sall        bool
+}
+
+// HeatmapTileHandler handles interpolated measurement variable PNG tile requests.
+func HeatmapTileHandler(db *db.Db, sc *cache.Stations) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		z, x, y, err := tileCoords(mux.Vars(r))
+		if err != nil {
+			writeResult(w, api.StatusBadRequest, fmt.Sprint(err))
+			return
+		}
+
+		p, err := parseHeatmapParams(r)
+		if err != nil {
+			writeResult(w, api.StatusBadRequest, fmt.Sprint(err))
+			return
+		}
+
+		toAqi, err := aqiConverter(p.variable)
+		if err != nil {
+			writeResult(w, api.StatusBadRequest, fmt.Sprint(err))
+			return
+		}
+
+		ramp := r.URL.Query().Get("ramp")
+		if ramp != "" && ramp != "category" && ramp != "smooth" {
+			writeResult(w, api.StatusBadRequest, fmt.Sprintf("unsupported color ramp: %s", ramp))
+			return
+		}
+
+		ip, err := heatmapInterpolator(r.Context(), db, sc, p, raster.TileBounds(z, x, y))
+		if err != nil {
+			m := fmt.Sprintf("can't get stations: %v", err)
+			writeResult(w, api.StatusServerError, m)
+			log.Error(m)
+			return
+		}
+
+		var b bytes.Buffer
+		g := raster.NewTileGrid(ip, z, x, y, heatmapTileSize)
+		if err := raster.WritePng(&b, g, toAqi, ramp == "smooth"); err != nil {
+			m := fmt.Sprintf("can't encode tile: %v", err)
+			writeResult(w, api.StatusServerError, m)
+			log.Error(m)
+			return
+		}
+
+		httputil.SetCacheControl(w, liveMaxAge)
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write(b.Bytes())
+	})
+}
+
+// HeatmapGridHandler handles interpolated measurement variable grid requests.
+func HeatmapGridHandler(db *db.Db, sc *cache.Stations) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		bbox, err := util.ParseBBox(query.Get("bbox"))
+		if err != nil {
+			writeResult(w, api.StatusBadRequest, fmt.Sprint(err))
+			return
+		}
+		if bbox == nil {
+			writeResult(w, api.StatusBadRequest, "'bbox' parameter not set")
+			return
+		}
+		if bbox[0] >= bbox[2] || bbox[1] >= bbox[3] {
+			writeResult(w, api.StatusBadRequest, fmt.Sprintf("invalid bounding box: %v", bbox))
+			return
+		}
+		gbbox := [4]float64{bbox[0], bbox[1], bbox[2], bbox[3]}
+
+		width, err := parseGridSize(query.Get("width"))
+		if err != nil {
+			writeResult(w, api.StatusBadRequest, fmt.Sprintf("invalid width: %v", err))
+			return
+		}
+		height, err := parseGridSize(query.Get("height"))
+		if err != nil {
+			writeResult(w, api.StatusBadRequest, fmt.Sprintf("invalid height: %v", err))
+			return
+		}
+
+		format := query.Get("format")
+		if format == "" {
+			format = formatJson
+		}
+		if format != formatJson && format != formatGeoTiff {
+			writeResult(w, api.StatusBadRequest, fmt.Sprintf("unsupported format: %s", format))
+			return
+		}
+
+		p, err := parseHeatmapParams(r)
+		if err != nil {
+			writeResult(w, api.StatusBadRequest, fmt.Sprint(err))
+			return
+		}
+
+		ip, err := heatmapInterpolator(r.Context(), db, sc, p, gbbox)
+		if err != nil {
+			m := fmt.Sprintf("can't get stations: %v", err)
+			writeResult(w, api.StatusServerError, m)
+			log.Error(m)
+			return
+		}
+
+		g := raster.NewGrid(ip, gbbox, width, height)
+
+		httputil.SetCacheControl(w, liveMaxAge)
+
+		if format == formatGeoTiff {
+			var b bytes.Buffer
+			if err := raster.WriteGeoTiff(&b, g); err != nil {
+				m := fmt.Sprintf("can't encode grid: %v", err)
+				writeResult(w, api.StatusServerError, m)
+				log.Error(m)
+				return
+			}
+			w.Header().Set("Content-Type", "image/tiff")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.tif"`, p.variable))
+			_, _ = w.Write(b.Bytes())
+			return
+		}
+
+		vs := make([]*float64, len(g.Values))
+		for i := range g.Values {
+			if !math.IsNaN(g.Values[i]) {
+				vs[i] = &g.Values[i]
+			}
+		}
+
+		httputil.WriteJsonResponse(w, GridResult{
+			Result:   api.Result{Status: api.StatusOk},
+			Variable: p.variable,
+			BBox:     g.BBox,
+			Width:    g.Width,
+			Height:   g.Height,
+			Values:   vs,
+		})
+	})
+}
+
+func parseHeatmapParams(r *http.Request) (heatmapParams, error) {
+	query := r.URL.Query()
+
+	p := heatmapParams{
+		variable:    query.Get("v"),
+		power:       heatmapDefaultPower,
+		maxDistance: heatmapDefaultMaxDistance,
+		mlast:       tileMeasurementMaxAge,
+		sall:        query.Get("sall") != "",
+	}
+
+	if p.variable == "" {
+		p.variable = "aqi"
+	}
+	if !isMeasurementVariable(p.variable) {
+		return p, fmt.Errorf("unsupported variable: %s", p.variable)
+	}
+
+	if s := query.Get("power"); s != "" {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || v <= 0 || v > 10 {
+			return p, fmt.Errorf("invalid power: %s", s)
+		}
+		p.power = v
+	}
+
+	if s := query.Get("maxdist"); s != "" {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || v <= 0 || v > heatmapMaxMaxDistance {
+			return p, fmt.Errorf("invalid maximum distance: %s", s)
+		}
+		p.maxDistance = v
+	}
+
+	mlast, err := util.ParseDuration(query.Get("mlast"))
+	if err != nil {
+		return p, err
+	}
+	if mlast != nil {
+		p.mlast = *mlast
+	}
+
+	return p, nil
+}
+
+func parseGridSize(s string) (int, error) {
+	if s == "" {
+		return heatmapTileSize, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	if v <= 0 || v > heatmapMaxGridSize {
+		return 0, fmt.Errorf("%d is out of range [1, %d]", v, heatmapMaxGridSize)
+	}
+	return v, nil
+}
+
+// heatmapInterpolator creates interpolator of latest measurements of stations affecting values within bbox.
+func heatmapInterpolator(ctx context.Context, d *db.Db, sc *cache.Stations, p heatmapParams,
+	bbox [4]float64) (*raster.Interpolator, error) {
+	ip := raster.NewInterpolator(nil, p.power, p.maxDistance)
+	m := ip.Margin(bbox)
+
+	dss, err := stations(ctx, d, sc, m[:], nil, &p.mlast, p.sall)
+	if err != nil {
+		return nil, err
+	}
+
+	var ss []raster.Sample
+	for _, s := range dss {
+		if v, ok := measurementValue(s.Measurement, p.variable); ok {
+			ss = append(ss, raster.Sample{Longitude: s.Location.X, Latitude: s.Location.Y, Value: v})
+		}
+	}
+
+	return raster.NewInterpolator(ss, p.power, p.maxDistance), nil
+}
+
+func isMeasurementVariable(v string) bool {
+	switch v {
+	case "temperature", "humidity", "pressure", "pm25", "pm10", "aqi":
+		return true
+	}
+	return false
+}
+
+// measurementValue gets variable v value of measurement m, returns false if it is not set.
+func measurementValue(m db.Measurement, v string) (float64, bool) {
+	var nf sql.NullFloat64
+	switch v {
+	case "temperature":
+		nf = m.Temperature
+	case "humidity":
+		nf = m.Humidity
+	case "pressure":
+		nf = m.Pressure
+	case "pm25":
+		nf = m.Pm25
+	case "pm10":
+		nf = m.Pm10
+	case "aqi":
+		return float64(m.Aqi.Int64), m.Aqi.Valid
+	}
+	return nf.Float64, nf.Valid
+}
+
+// aqiConverter returns function converting values of variable v to AQI.
+func aqiConverter(v string) (func(float64) float64, error) {
+	switch v {
+	case "aqi":
+		return func(a float64) float64 { return a }, nil
+	case "pm25":
+		return func(c float64) float64 { return float64(aqi.Pm25Aqi(float32(c))) }, nil
+	case "pm10":
+		return func(c float64) float64 { return float64(aqi.Pm10Aqi(float32(c))) }, nil
+	}
+	return nil, fmt.Errorf("variable %s has no AQI color ramp", v)
+}
@@ -17,8 +17,8 @@ package v1
 import (
 	"fmt"
 	"net/http"
-	"strconv"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -28,16 +28,14 @@ import (
 	"github.com/openairtech/apiserver/util"
 )
 
+// MeasurementsGetHandler handles station measurements requests. Single station measurements are returned
+// as JSON object, or streamed in CSV or NDJSON format if requested by format parameter. Measurements of
+// several stations are returned as per-station series or time-aligned matrix, optionally resampled.
 func MeasurementsGetHandler(db *db.Db) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ss := r.URL.Query().Get("station")
-		if ss == "" {
-			writeResult(w, api.StatusBadRequest, fmt.Sprint("'station' parameter not set"))
-			return
-		}
-		s, err := strconv.ParseInt(ss, 10, 32)
+		sel, err := parseMeasurementsStations(r.URL.Query())
 		if err != nil {
-			writeResult(w, api.StatusBadRequest, fmt.Sprintf("can't parse station id: %v", err))
+			writeResult(w, api.StatusBadRequest, fmt.Sprint(err))
 			return
 		}
 
@@ -67,7 +65,43 @@ func MeasurementsGetHandler(db *db.Db) http.Handler {
 			vars = strings.Split(v, ",")
 		}
 
-		dms, err := db.Measurements(int(s), *from, *to, vars)
+		format, timeFormat, err := parseExportFormat(r.URL.Query().Get("format"), r.URL.Query().Get("tformat"))
+		if err != nil {
+			writeResult(w, api.StatusBadRequest, fmt.Sprint(err))
+			return
+		}
+
+		layout, rs, err := parseSeriesLayout(r.URL.Query())
+		if err != nil {
+			writeResult(w, api.StatusBadRequest, fmt.Sprint(err))
+			return
+		}
+		if !sel.single() || layout != "" || rs != nil {
+			if format != formatJson {
+				writeResult(w, api.StatusBadRequest, fmt.Sprintf("format %s supports single station only", format))
+				return
+			}
+			if err := checkMeasurementVars(vars); err != nil {
+				writeResult(w, api.StatusBadRequest, fmt.Sprint(err))
+				return
+			}
+			writeStationsMeasurements(w, r, db, sel, *from, *to, vars, layout, rs)
+			return
+		}
+		s := sel.ids[0]
+
+		if format != formatJson {
+			filename := fmt.Sprintf("station-%d-%d-%d", s, from.Unix(), to.Unix())
+			e, err := newMeasurementsExporter(w, format, timeFormat, filename, vars)
+			if err != nil {
+				writeResult(w, api.StatusBadRequest, fmt.Sprint(err))
+				return
+			}
+			exportMeasurements(w, r, db, s, *from, *to, vars, e)
+			return
+		}
+
+		dms, err := db.Measurements(r.Context(), s, *from, *to, vars)
 		if err != nil {
 			m := fmt.Sprintf("can't get measurements: %v", err)
 			writeResult(w, api.StatusServerError, m)
@@ -75,6 +109,11 @@ func MeasurementsGetHandler(db *db.Db) http.Handler {
 			return
 		}
 
+		httputil.SetCacheControl(w, cacheMaxAge(to))
+		if httputil.CheckNotModified(w, r, httputil.NewValidator(r, newestMeasurement(dms), len(dms))) {
+			return
+		}
+
 		var ms []api.Measurement
 		for _, dm := range dms {
 			ms = append(ms, dm.ApiMeasurement())
@@ -86,3 +125,30 @@ func MeasurementsGetHandler(db *db.Db) http.Handler {
 		})
 	})
 }
+
+// exportMeasurements streams station measurements from database d to response using exporter e.
+func exportMeasurements(w http.ResponseWriter, r *http.Request, d *db.Db, stationId int, from, to time.Time,
+	vars []string, e *measurementsExporter) {
+	// Export of large time range may take long time, so server write timeout is not applicable to it
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Debugf("can't reset export write deadline: %v", err)
+	}
+
+	httputil.SetCacheControl(w, cacheMaxAge(&to))
+
+	err := d.EachMeasurement(r.Context(), stationId, from, to, vars, e.Export)
+	if err == nil {
+		err = e.Close()
+	}
+	if err != nil {
+		if !e.started {
+			m := fmt.Sprintf("can't get measurements: %v", err)
+			writeResult(w, api.StatusServerError, m)
+			log.Error(m)
+			return
+		}
+		// Response is partially sent already, so just abort it
+		log.Errorf("can't export measurements: %v", err)
+		panic(http.ErrAbortHandler)
+	}
+}
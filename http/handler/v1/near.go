@@ -0,0 +1,124 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/openairtech/api"
+	"github.com/openairtech/apiserver/db"
+	"github.com/openairtech/apiserver/util"
+)
+
+const (
+	nearestDefaultK = 5
+	nearestMaxK     = 100
+	// nearMaxRadius is the maximum radius in meters of stations search around point
+	nearMaxRadius = 100000
+)
+
+// NearStation is a station along with its distance in meters from the point of interest.
+type NearStation struct {
+	api.Station
+	Distance float64 `json:"distance"`
+}
+
+// NearStationsResult is a result of stations near point query.
+type NearStationsResult struct {
+	api.Result
+	Stations []NearStation `json:"stations"`
+}
+
+// nearQuery is a query of stations near point.
+type nearQuery struct {
+	lat, lon float64
+	// radius is the maximum distance of stations in meters, 0 for no limit
+	radius float64
+	// k is the maximum number of stations, 0 for no limit
+	k int
+}
+
+// parseNearQuery parses near (near=lat,lon&radius=2km) or nearest (nearest=lat,lon&k=5) station query
+// parameters, returns nil if none of them is set.
+func parseNearQuery(query url.Values) (*nearQuery, error) {
+	near, err := util.ParseLatLon(query.Get("near"))
+	if err != nil {
+		return nil, err
+	}
+	nearest, err := util.ParseLatLon(query.Get("nearest"))
+	if err != nil {
+		return nil, err
+	}
+
+	if near == nil && nearest == nil {
+		return nil, nil
+	}
+	if near != nil && nearest != nil {
+		return nil, errors.New("'near' and 'nearest' parameters are mutually exclusive")
+	}
+	if query.Get("bbox") != "" {
+		return nil, errors.New("'bbox' parameter can't be used along with 'near' or 'nearest' ones")
+	}
+
+	var nq nearQuery
+
+	if k := query.Get("k"); k != "" {
+		if nq.k, err = strconv.Atoi(k); err != nil || nq.k <= 0 || nq.k > nearestMaxK {
+			return nil, fmt.Errorf("invalid number of nearest stations (must be in range [1, %d]): %s",
+				nearestMaxK, k)
+		}
+	}
+
+	radius, err := util.ParseDistance(query.Get("radius"))
+	if err != nil {
+		return nil, err
+	}
+	if radius != nil && (*radius <= 0 || *radius > nearMaxRadius) {
+		return nil, fmt.Errorf("invalid radius (must be in range (0, %d] meters): %s",
+			nearMaxRadius, query.Get("radius"))
+	}
+
+	if near != nil {
+		if radius == nil {
+			return nil, errors.New("'radius' parameter not set")
+		}
+		nq.lat, nq.lon, nq.radius = near[0], near[1], *radius
+	} else {
+		if nq.k == 0 {
+			nq.k = nearestDefaultK
+		}
+		nq.lat, nq.lon = nearest[0], nearest[1]
+		if radius != nil {
+			nq.radius = *radius
+		}
+	}
+
+	return &nq, nil
+}
+
+func nearStations(dss []db.Station) []NearStation {
+	ns := make([]NearStation, 0, len(dss))
+	for _, ds := range dss {
+		n := NearStation{Station: ds.ApiStation()}
+		if ds.Distance != nil {
+			n.Distance = *ds.Distance
+		}
+		ns = append(ns, n)
+	}
+	return ns
+}
@@ -0,0 +1,260 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/openairtech/api"
+	"github.com/openairtech/apiserver/db"
+)
+
+// stationsMaxLimit is the maximum number of stations per page
+const stationsMaxLimit = 1000
+
+// Stations sort orders
+const (
+	stationsSortId       = "id"
+	stationsSortAqi      = "aqi"
+	stationsSortSeen     = "seen"
+	stationsSortCreated  = "created"
+	stationsSortDistance = "distance"
+)
+
+// stationFields are names of station fields that can be selected by fields parameter.
+var stationFields = map[string]bool{
+	"id": true, "created": true, "seen": true, "description": true, "long": true, "lat": true,
+	"is_public": true, "last_measurement": true, "aqi": true, "distance": true,
+}
+
+// StationsPageResult is a result of paginated stations query or stations query with selected fields.
+type StationsPageResult struct {
+	api.Result
+	Stations []interface{} `json:"stations"`
+	// Next is a cursor of the next page, empty if there are no more stations
+	Next string `json:"next,omitempty"`
+}
+
+// stationsPage is a page of stations query: stations matching search text q sorted by sort key
+// and taken after cursor, limit stations at most (all stations if limit is 0).
+type stationsPage struct {
+	sort   string
+	desc   bool
+	limit  int
+	cursor *stationsCursor
+	q      string
+	// fields are names of station fields to return, all fields if nil
+	fields map[string]bool
+}
+
+// stationsCursor is a position of the last station of page in sorted stations.
+type stationsCursor struct {
+	Sort string `json:"s"`
+	stationSortKey
+}
+
+// stationSortKey is a station sort key: sort value, nil if it is not set, and station ID.
+type stationSortKey struct {
+	Value *float64 `json:"v"`
+	Id    int      `json:"id"`
+}
+
+// parseStationsPage parses stations page parameters: sort (field name, optionally prefixed with "-"
+// for descending order), limit, cursor, q and fields. near is set for near stations queries, which
+// are sorted by distance by default.
+func parseStationsPage(query url.Values, near bool) (*stationsPage, error) {
+	p := stationsPage{sort: stationsSortId, q: strings.ToLower(query.Get("q"))}
+	if near {
+		p.sort = stationsSortDistance
+	}
+
+	if s := query.Get("sort"); s != "" {
+		p.desc = strings.HasPrefix(s, "-")
+		p.sort = strings.TrimPrefix(s, "-")
+		switch p.sort {
+		case stationsSortId, stationsSortAqi, stationsSortSeen, stationsSortCreated:
+		case stationsSortDistance:
+			if !near {
+				return nil, errors.New("sort by distance requires 'near' or 'nearest' parameter")
+			}
+		default:
+			return nil, fmt.Errorf("unsupported sort order: %s", s)
+		}
+	}
+
+	if l := query.Get("limit"); l != "" {
+		limit, err := strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > stationsMaxLimit {
+			return nil, fmt.Errorf("limit must be within 1..%d", stationsMaxLimit)
+		}
+		p.limit = limit
+	}
+
+	if c := query.Get("cursor"); c != "" {
+		cursor, err := parseStationsCursor(c)
+		if err != nil {
+			return nil, err
+		}
+		if cursor.Sort != p.sortName() {
+			return nil, errors.New("cursor doesn't match sort order")
+		}
+		p.cursor = cursor
+	}
+
+	if f := query.Get("fields"); f != "" {
+		// Station ID is always returned
+		p.fields = map[string]bool{"id": true}
+		for _, name := range strings.Split(f, ",") {
+			name = strings.TrimSpace(name)
+			if !stationFields[name] {
+				return nil, fmt.Errorf("unknown field: %s", name)
+			}
+			p.fields[name] = true
+		}
+	}
+
+	return &p, nil
+}
+
+// paged checks whether stations page result is requested instead of plain stations list.
+func (p *stationsPage) paged() bool {
+	return p.limit > 0 || p.cursor != nil || p.fields != nil
+}
+
+func (p *stationsPage) sortName() string {
+	if p.desc {
+		return "-" + p.sort
+	}
+	return p.sort
+}
+
+// apply returns page of stations dss and cursor of the next page, empty if it is the last one.
+func (p *stationsPage) apply(dss []db.Station) ([]db.Station, string) {
+	ss := make([]db.Station, 0, len(dss))
+	for _, ds := range dss {
+		if p.q == "" || strings.Contains(strings.ToLower(ds.Description.String), p.q) {
+			ss = append(ss, ds)
+		}
+	}
+
+	sort.SliceStable(ss, func(i, j int) bool {
+		return p.less(p.key(ss[i]), p.key(ss[j]))
+	})
+
+	if p.cursor != nil {
+		start := sort.Search(len(ss), func(i int) bool {
+			return p.less(p.cursor.stationSortKey, p.key(ss[i]))
+		})
+		ss = ss[start:]
+	}
+
+	if p.limit == 0 || len(ss) <= p.limit {
+		return ss, ""
+	}
+
+	ss = ss[:p.limit]
+	next := stationsCursor{Sort: p.sortName(), stationSortKey: p.key(ss[len(ss)-1])}
+
+	return ss, next.String()
+}
+
+// key returns sort key of station ds.
+func (p *stationsPage) key(ds db.Station) stationSortKey {
+	k := stationSortKey{Id: ds.Id}
+	var v float64
+	switch p.sort {
+	case stationsSortId:
+		v = float64(ds.Id)
+	case stationsSortAqi:
+		if !ds.Measurement.Aqi.Valid {
+			return k
+		}
+		v = float64(ds.Measurement.Aqi.Int64)
+	case stationsSortSeen:
+		if ds.Seen == nil {
+			return k
+		}
+		v = float64(ds.Seen.Unix())
+	case stationsSortCreated:
+		v = float64(ds.Created.Unix())
+	case stationsSortDistance:
+		if ds.Distance == nil {
+			return k
+		}
+		v = *ds.Distance
+	}
+	k.Value = &v
+	return k
+}
+
+// less checks station sort key a goes before b: stations without sort value go last
+// regardless of sort direction, stations with equal values are sorted by ID.
+func (p *stationsPage) less(a, b stationSortKey) bool {
+	if (a.Value == nil) != (b.Value == nil) {
+		return a.Value != nil
+	}
+	if a.Value != nil && *a.Value != *b.Value {
+		if p.desc {
+			return *a.Value > *b.Value
+		}
+		return *a.Value < *b.Value
+	}
+	return a.Id < b.Id
+}
+
+// project returns JSON object v with page fields only.
+func (p *stationsPage) project(v interface{}) (interface{}, error) {
+	if p.fields == nil {
+		return v, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	for f := range m {
+		if !p.fields[f] {
+			delete(m, f)
+		}
+	}
+	return m, nil
+}
+
+func (c stationsCursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func parseStationsCursor(s string) (*stationsCursor, error) {
+	var c stationsCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(b, &c)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %s", s)
+	}
+	return &c, nil
+}
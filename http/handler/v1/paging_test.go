@@ -0,0 +1,213 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/openairtech/apiserver/db"
+)
+
+func pagingKey(v interface{}, id int) stationSortKey {
+	k := stationSortKey{Id: id}
+	if f, ok := v.(float64); ok {
+		k.Value = &f
+	}
+	return k
+}
+
+func pagingNext(v interface{}, id int) *stationSortKey {
+	k := pagingKey(v, id)
+	return &k
+}
+
+func pagingStation(id int, aqi interface{}, description string) db.Station {
+	s := db.Station{Id: id, Description: sql.NullString{String: description, Valid: true}}
+	if a, ok := aqi.(int); ok {
+		s.Measurement.Aqi = sql.NullInt64{Int64: int64(a), Valid: true}
+	}
+	return s
+}
+
+func pagingIds(ss []db.Station) []int {
+	ids := []int{}
+	for _, s := range ss {
+		ids = append(ids, s.Id)
+	}
+	return ids
+}
+
+func TestStationsPage_Less(t *testing.T) {
+	tests := []struct {
+		name string
+		desc bool
+		a, b stationSortKey
+		want bool
+	}{
+		{"asc values", false, pagingKey(1.0, 2), pagingKey(2.0, 1), true},
+		{"asc values reversed", false, pagingKey(2.0, 1), pagingKey(1.0, 2), false},
+		{"desc values", true, pagingKey(2.0, 2), pagingKey(1.0, 1), true},
+		{"desc values reversed", true, pagingKey(1.0, 1), pagingKey(2.0, 2), false},
+		{"asc nil last", false, pagingKey(100.0, 2), pagingKey(nil, 1), true},
+		{"asc nil after value", false, pagingKey(nil, 1), pagingKey(100.0, 2), false},
+		{"desc nil last", true, pagingKey(-100.0, 2), pagingKey(nil, 1), true},
+		{"desc nil after value", true, pagingKey(nil, 1), pagingKey(-100.0, 2), false},
+		{"asc tie by id", false, pagingKey(5.0, 1), pagingKey(5.0, 2), true},
+		{"asc tie by id reversed", false, pagingKey(5.0, 2), pagingKey(5.0, 1), false},
+		{"desc tie by id ascending", true, pagingKey(5.0, 1), pagingKey(5.0, 2), true},
+		{"nil tie by id", true, pagingKey(nil, 1), pagingKey(nil, 2), true},
+		{"nil tie by id reversed", false, pagingKey(nil, 2), pagingKey(nil, 1), false},
+		{"same key", false, pagingKey(5.0, 1), pagingKey(5.0, 1), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := stationsPage{sort: stationsSortAqi, desc: tt.desc}
+			if got := p.less(tt.a, tt.b); got != tt.want {
+				t.Errorf("less() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStationsPage_Apply(t *testing.T) {
+	dss := []db.Station{
+		pagingStation(1, 50, "Center"),
+		pagingStation(2, nil, "North"),
+		pagingStation(3, 20, "center park"),
+		pagingStation(4, 50, "South"),
+		pagingStation(5, 80, "East"),
+		pagingStation(6, nil, "West"),
+	}
+	tests := []struct {
+		name  string
+		query string
+		want  []int
+		// next is the expected cursor key of the next page, nil if it is the last page
+		next *stationSortKey
+	}{
+		{"all by id", "", []int{1, 2, 3, 4, 5, 6}, nil},
+		{"aqi", "sort=aqi", []int{3, 1, 4, 5, 2, 6}, nil},
+		{"aqi desc", "sort=-aqi", []int{5, 1, 4, 3, 2, 6}, nil},
+		{"first page", "sort=aqi&limit=2", []int{3, 1}, pagingNext(50.0, 1)},
+		{"page between equal values", "sort=aqi&limit=2&cursor=" + stationsCursor{Sort: "aqi",
+			stationSortKey: pagingKey(50.0, 1)}.String(), []int{4, 5}, pagingNext(80.0, 5)},
+		{"page before nil values", "sort=aqi&limit=2&cursor=" + stationsCursor{Sort: "aqi",
+			stationSortKey: pagingKey(80.0, 5)}.String(), []int{2, 6}, nil},
+		{"page within nil values", "sort=-aqi&limit=2&cursor=" + stationsCursor{Sort: "-aqi",
+			stationSortKey: pagingKey(nil, 2)}.String(), []int{6}, nil},
+		{"cursor of missing station", "sort=-aqi&cursor=" + stationsCursor{Sort: "-aqi",
+			stationSortKey: pagingKey(60.0, 9)}.String(), []int{1, 4, 3, 2, 6}, nil},
+		{"last page exactly", "limit=3&cursor=" + stationsCursor{Sort: "id",
+			stationSortKey: pagingKey(3.0, 3)}.String(), []int{4, 5, 6}, nil},
+		{"search", "q=CENTER", []int{1, 3}, nil},
+		{"search page", "q=center&limit=1", []int{1}, pagingNext(1.0, 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err := parseStationsPage(q, false)
+			if err != nil {
+				t.Fatalf("parseStationsPage() error = %v", err)
+			}
+			ss, next := p.apply(dss)
+			if ids := pagingIds(ss); !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("apply() = %v, want %v", ids, tt.want)
+			}
+			if tt.next == nil {
+				if next != "" {
+					t.Errorf("apply() next = %q, want last page", next)
+				}
+				return
+			}
+			c, err := parseStationsCursor(next)
+			if err != nil {
+				t.Fatalf("parseStationsCursor(%q) error = %v", next, err)
+			}
+			if c.Sort != p.sortName() || !reflect.DeepEqual(c.stationSortKey, *tt.next) {
+				t.Errorf("apply() next = %+v, want %s %+v", c, p.sortName(), *tt.next)
+			}
+		})
+	}
+}
+
+func TestStationsCursor(t *testing.T) {
+	for _, c := range []stationsCursor{
+		{Sort: "id", stationSortKey: pagingKey(42.0, 42)},
+		{Sort: "-aqi", stationSortKey: pagingKey(nil, 7)},
+		{Sort: "distance", stationSortKey: pagingKey(1234.5678, 3)},
+	} {
+		got, err := parseStationsCursor(c.String())
+		if err != nil {
+			t.Fatalf("parseStationsCursor(%q) error = %v", c.String(), err)
+		}
+		if !reflect.DeepEqual(*got, c) {
+			t.Errorf("parseStationsCursor(%q) = %+v, want %+v", c.String(), *got, c)
+		}
+	}
+
+	for _, s := range []string{"!", base64.RawURLEncoding.EncodeToString([]byte("[1]"))} {
+		if _, err := parseStationsCursor(s); err == nil {
+			t.Errorf("parseStationsCursor(%q) succeeded", s)
+		}
+	}
+
+	q := url.Values{"sort": {"aqi"}, "cursor": {stationsCursor{Sort: "-aqi"}.String()}}
+	if _, err := parseStationsPage(q, false); err == nil {
+		t.Errorf("parseStationsPage() with cursor of other sort order succeeded")
+	}
+}
+
+func TestStationsPage_Project(t *testing.T) {
+	v := struct {
+		Id          int     `json:"id"`
+		Description string  `json:"description"`
+		Aqi         *int    `json:"aqi"`
+		Distance    float64 `json:"distance"`
+	}{Id: 1, Description: "Center", Distance: 12.5}
+
+	p := stationsPage{}
+	if got, err := p.project(v); err != nil || !reflect.DeepEqual(got, v) {
+		t.Errorf("project() = %v, %v, want unchanged value", got, err)
+	}
+
+	q := url.Values{"fields": {"aqi, distance"}}
+	pp, err := parseStationsPage(q, false)
+	if err != nil {
+		t.Fatalf("parseStationsPage() error = %v", err)
+	}
+	got, err := pp.project(v)
+	if err != nil {
+		t.Fatalf("project() error = %v", err)
+	}
+	b, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"aqi":null,"distance":12.5,"id":1}`; string(b) != want {
+		t.Errorf("project() = %s, want %s", b, want)
+	}
+
+	if _, err := parseStationsPage(url.Values{"fields": {"id,token"}}, false); err == nil {
+		t.Errorf("parseStationsPage() with unknown field succeeded")
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/openairtech/api"
+	"github.com/openairtech/apiserver/db"
+	httputil "github.com/openairtech/apiserver/http/util"
+	"github.com/openairtech/apiserver/util"
+)
+
+// profileDefaultPeriod is the default time range of station profile
+const profileDefaultPeriod = 30 * 24 * time.Hour
+
+// ProfileValue is a mean variable value within hour of day or day of week, mean is nil if there is no data.
+type ProfileValue struct {
+	// Period is an hour of day (0-23) or ISO day of week (1 is Monday, 7 is Sunday)
+	Period int      `json:"period"`
+	Mean   *float64 `json:"mean"`
+	Count  int      `json:"count"`
+}
+
+// StationProfileResult is a result of station profile query.
+type StationProfileResult struct {
+	api.Result
+	StationId int          `json:"station_id"`
+	Variable  string       `json:"var"`
+	Timezone  string       `json:"tz"`
+	From      api.UnixTime `json:"from"`
+	To        api.UnixTime `json:"to"`
+	// Hours are mean values per hour of day
+	Hours []ProfileValue `json:"hours"`
+	// Days are mean values per day of week
+	Days []ProfileValue `json:"days"`
+}
+
+// StationProfileHandler handles requests of "typical day" profiles of station measurements: mean values
+// of variable set by var parameter per hour of day and day of week within time range set by from and to
+// parameters (last 30 days by default). Profiles are computed in time zone set by tz parameter
+// or station time zone. Private stations are returned only if sall parameter is set.
+func StationProfileHandler(db *db.Db) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			writeResult(w, api.StatusBadRequest, fmt.Sprintf("invalid station id: %s", mux.Vars(r)["id"]))
+			return
+		}
+
+		v := query.Get("var")
+		if v == "" {
+			v = "pm25"
+		}
+		if !isMeasurementVariable(v) {
+			writeResult(w, api.StatusBadRequest, fmt.Sprintf("unknown variable: %s", v))
+			return
+		}
+
+		tz, err := util.ParseTimezone(query.Get("tz"))
+		if err != nil {
+			writeResult(w, api.StatusBadRequest, fmt.Sprint(err))
+			return
+		}
+
+		from, err := util.ParseUnixTime(query.Get("from"))
+		if err != nil {
+			writeResult(w, api.StatusBadRequest, fmt.Sprint(err))
+			return
+		}
+
+		to, err := util.ParseUnixTime(query.Get("to"))
+		if err != nil {
+			writeResult(w, api.StatusBadRequest, fmt.Sprint(err))
+			return
+		}
+		if to == nil {
+			now := time.Now()
+			to = &now
+		}
+		if from == nil {
+			f := to.Add(-profileDefaultPeriod)
+			from = &f
+		}
+
+		if !from.Before(*to) {
+			writeResult(w, api.StatusBadRequest, "'from' time must be before 'to' one")
+			return
+		}
+		if to.Sub(*from) > statsMaxPeriod {
+			writeResult(w, api.StatusBadRequest, fmt.Sprintf("time range can't exceed %v", statsMaxPeriod))
+			return
+		}
+
+		sall := query.Get("sall") != ""
+
+		res, err := stationProfile(r.Context(), db, id, *from, *to, v, tz, sall)
+		if err != nil {
+			m := fmt.Sprintf("can't get station profile: %v", err)
+			writeResult(w, api.StatusServerError, m)
+			log.Error(m)
+			return
+		}
+		if res == nil {
+			writeResult(w, api.StatusNotFound, fmt.Sprintf("station not found: %d", id))
+			return
+		}
+
+		httputil.SetCacheControl(w, cacheMaxAge(to))
+		httputil.WriteJsonResponse(w, res)
+	})
+}
+
+// stationProfile gets profiles of variable v of measurements of station with given ID taken within time range
+// [from, to) in time zone tz or station time zone if tz is nil, returns nil if there is no such station
+// or it is private and sall is not set.
+func stationProfile(ctx context.Context, d *db.Db, id int, from, to time.Time, v string,
+	tz *time.Location, sall bool) (*StationProfileResult, error) {
+	ss, err := d.StationsById(ctx, []int{id})
+	if err != nil {
+		return nil, err
+	}
+	if len(ss) == 0 || (!ss[0].IsPublic && !sall) {
+		return nil, nil
+	}
+
+	if tz == nil {
+		tz = stationTimezone(ss[0])
+	}
+
+	hours, days, err := d.StationProfile(ctx, id, from, to, v, tz)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StationProfileResult{
+		Result:    api.Result{Status: api.StatusOk},
+		StationId: id,
+		Variable:  v,
+		Timezone:  tz.String(),
+		From:      api.UnixTime(from),
+		To:        api.UnixTime(to),
+		Hours:     profileValues(hours, 0, 23),
+		Days:      profileValues(days, 1, 7),
+	}, nil
+}
+
+// profileValues returns profile values of all periods within range [first, last].
+func profileValues(pvs []db.ProfileValue, first, last int) []ProfileValue {
+	res := make([]ProfileValue, 0, last-first+1)
+	for p := first; p <= last; p++ {
+		res = append(res, ProfileValue{Period: p})
+	}
+	for _, pv := range pvs {
+		if pv.Period < first || pv.Period > last {
+			continue
+		}
+		mean := pv.Mean
+		res[pv.Period-first].Mean = &mean
+		res[pv.Period-first].Count = pv.Count
+	}
+	return res
+}
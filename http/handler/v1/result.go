@@ -15,7 +15,10 @@
 package v1
 
 import (
+	"encoding/json"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/openairtech/api"
 	httputil "github.com/openairtech/apiserver/http/util"
@@ -28,3 +31,12 @@ func writeResult(w http.ResponseWriter, sc api.StatusCode, m string) {
 	}
 	httputil.WriteJsonResponse(w, r)
 }
+
+// writeRetryLater writes result of request rejected due to temporary overload with HTTP status 503,
+// so clients retry it after retryAfter.
+func writeRetryLater(w http.ResponseWriter, m string, retryAfter time.Duration) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	_ = json.NewEncoder(w).Encode(api.Result{Status: http.StatusServiceUnavailable, Message: m})
+}
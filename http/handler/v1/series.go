@@ -0,0 +1,307 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/openairtech/api"
+	"github.com/openairtech/apiserver/db"
+	httputil "github.com/openairtech/apiserver/http/util"
+	"github.com/openairtech/apiserver/util"
+)
+
+const (
+	layoutSeries = "series"
+	layoutMatrix = "matrix"
+
+	// seriesMaxStations is the maximum number of stations of multi-station measurements query
+	seriesMaxStations = 50
+	// seriesMinResample is the minimum resampling interval of measurements
+	seriesMinResample = time.Minute
+)
+
+// StationMeasurements is a series of station measurements.
+type StationMeasurements struct {
+	StationId    int               `json:"station_id"`
+	Measurements []api.Measurement `json:"measurements"`
+}
+
+// MeasurementsMatrix is a time-aligned matrix of measurements of several stations.
+type MeasurementsMatrix struct {
+	Timestamps []api.UnixTime `json:"timestamps"`
+	Stations   []int          `json:"stations"`
+	// Values maps variable name to matrix of its values with row per timestamp and column per station,
+	// missing values are null
+	Values map[string][][]*float64 `json:"values"`
+}
+
+// StationsMeasurementsResult is a result of multi-station measurements query.
+type StationsMeasurementsResult struct {
+	api.Result
+	Series []StationMeasurements `json:"series,omitempty"`
+	Matrix *MeasurementsMatrix   `json:"matrix,omitempty"`
+}
+
+// measurementsStations is a selection of stations to get measurements of: either list of station IDs,
+// or stations within bounding box and/or named area.
+type measurementsStations struct {
+	ids    []int
+	bbox   []float64
+	region *db.Region
+	sall   bool
+}
+
+// single checks whether selection is a single station.
+func (ms *measurementsStations) single() bool {
+	return len(ms.ids) == 1
+}
+
+// parseMeasurementsStations parses stations selection of measurements query: comma-separated list
+// of station IDs in station parameter, or bbox and/or area parameters.
+func parseMeasurementsStations(query url.Values) (*measurementsStations, error) {
+	var ms measurementsStations
+
+	bbox, err := util.ParseBBox(query.Get("bbox"))
+	if err != nil {
+		return nil, err
+	}
+	area := query.Get("area")
+
+	ss := query.Get("station")
+	if ss == "" {
+		if bbox == nil && area == "" {
+			return nil, errors.New("'station', 'bbox' or 'area' parameter not set")
+		}
+		ms.bbox = bbox
+		if area != "" {
+			ms.region = &db.Region{Area: area}
+		}
+		ms.sall = query.Get("sall") != ""
+		return &ms, nil
+	}
+
+	if bbox != nil || area != "" {
+		return nil, errors.New("'station' parameter can't be used along with 'bbox' or 'area' ones")
+	}
+
+	seen := make(map[int]bool)
+	for _, s := range strings.Split(ss, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(s), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse station id: %v", err)
+		}
+		if !seen[int(id)] {
+			seen[int(id)] = true
+			ms.ids = append(ms.ids, int(id))
+		}
+	}
+	if len(ms.ids) > seriesMaxStations {
+		return nil, fmt.Errorf("too many stations (maximum is %d)", seriesMaxStations)
+	}
+
+	return &ms, nil
+}
+
+// parseSeriesLayout parses multi-station measurements layout (series or matrix) and resampling interval:
+// either duration or calendar day, week or month in time zone set by tz parameter (station time zone
+// if it is not set).
+func parseSeriesLayout(query url.Values) (string, *db.Resampling, error) {
+	layout := query.Get("layout")
+	switch layout {
+	case layoutSeries, layoutMatrix, "":
+	default:
+		return "", nil, fmt.Errorf("unsupported layout: %s", layout)
+	}
+
+	tz, err := util.ParseTimezone(query.Get("tz"))
+	if err != nil {
+		return "", nil, err
+	}
+
+	resample := query.Get("resample")
+	if db.IsCalendarResampleUnit(resample) {
+		return layout, &db.Resampling{Unit: resample, Location: tz}, nil
+	}
+	if tz != nil {
+		return "", nil, errors.New("'tz' parameter can be used with calendar resampling only")
+	}
+
+	step, err := util.ParseDuration(resample)
+	if err != nil {
+		return "", nil, err
+	}
+	if step == nil {
+		return layout, nil, nil
+	}
+	if *step < seriesMinResample {
+		return "", nil, fmt.Errorf("resampling interval must be at least %v", seriesMinResample)
+	}
+
+	return layout, &db.Resampling{Step: *step}, nil
+}
+
+// checkMeasurementVars checks measurement variable names vars are valid.
+func checkMeasurementVars(vars []string) error {
+	_, err := db.MeasurementDbColumns(vars)
+	return err
+}
+
+// writeStationsMeasurements writes measurements of selected stations ms from database d to response
+// as per-station series or time-aligned matrix depending on layout.
+func writeStationsMeasurements(w http.ResponseWriter, r *http.Request, d *db.Db, ms *measurementsStations,
+	from, to time.Time, vars []string, layout string, rs *db.Resampling) {
+	ids := ms.ids
+	if ids == nil {
+		var err error
+		ids, err = d.StationIdsWithin(r.Context(), ms.bbox, ms.region, ms.sall)
+		if isAreaNotFound(err) {
+			writeResult(w, api.StatusNotFound, fmt.Sprintf("area not found: %s", ms.region.Area))
+			return
+		}
+		if err != nil {
+			m := fmt.Sprintf("can't get stations: %v", err)
+			writeResult(w, api.StatusServerError, m)
+			log.Error(m)
+			return
+		}
+		if len(ids) > seriesMaxStations {
+			writeResult(w, api.StatusBadRequest,
+				fmt.Sprintf("too many stations selected: %d (maximum is %d)", len(ids), seriesMaxStations))
+			return
+		}
+	}
+
+	var dms []db.Measurement
+	if len(ids) > 0 {
+		var err error
+		if rs != nil && rs.Unit != "" && rs.Location == nil {
+			dms, err = stationsMeasurementsInTimezones(r.Context(), d, ids, from, to, vars, *rs)
+		} else {
+			dms, err = d.StationsMeasurements(r.Context(), ids, from, to, vars, rs)
+		}
+		if err != nil {
+			m := fmt.Sprintf("can't get measurements: %v", err)
+			writeResult(w, api.StatusServerError, m)
+			log.Error(m)
+			return
+		}
+	}
+
+	httputil.SetCacheControl(w, cacheMaxAge(&to))
+	if httputil.CheckNotModified(w, r, httputil.NewValidator(r, newestMeasurement(dms), len(dms))) {
+		return
+	}
+
+	res := StationsMeasurementsResult{Result: api.Result{Status: api.StatusOk}}
+	if layout == layoutMatrix {
+		res.Matrix = measurementsMatrix(ids, dms, vars)
+	} else {
+		res.Series = measurementsSeries(ids, dms)
+	}
+
+	httputil.WriteJsonResponse(w, res)
+}
+
+// measurementsSeries groups measurements dms sorted by station ID into series of stations with given IDs.
+func measurementsSeries(ids []int, dms []db.Measurement) []StationMeasurements {
+	sms := make([]StationMeasurements, 0, len(ids))
+	idx := make(map[int]int, len(ids))
+	for i, id := range ids {
+		idx[id] = i
+		sms = append(sms, StationMeasurements{StationId: id, Measurements: []api.Measurement{}})
+	}
+	for _, dm := range dms {
+		if i, ok := idx[int(dm.StationId.Int64)]; ok {
+			sms[i].Measurements = append(sms[i].Measurements, dm.ApiMeasurement())
+		}
+	}
+	return sms
+}
+
+// measurementsMatrix aligns measurements dms of stations with given IDs by their timestamps.
+// vars are names of matrix variables, all variables if empty.
+func measurementsMatrix(ids []int, dms []db.Measurement, vars []string) *MeasurementsMatrix {
+	want := make(map[string]bool, len(vars))
+	for _, v := range vars {
+		want[v] = true
+	}
+	mvs := make([]string, 0, len(measurementVars))
+	for _, v := range measurementVars {
+		if len(vars) == 0 || want[v] {
+			mvs = append(mvs, v)
+		}
+	}
+
+	cols := make(map[int]int, len(ids))
+	for i, id := range ids {
+		cols[id] = i
+	}
+
+	tss := make(map[int64]bool)
+	for _, dm := range dms {
+		if dm.Timestamp != nil {
+			tss[dm.Timestamp.Unix()] = true
+		}
+	}
+	uts := make([]int64, 0, len(tss))
+	for ts := range tss {
+		uts = append(uts, ts)
+	}
+	sort.Slice(uts, func(i, j int) bool { return uts[i] < uts[j] })
+
+	mm := &MeasurementsMatrix{
+		Timestamps: make([]api.UnixTime, 0, len(uts)),
+		Stations:   ids,
+		Values:     make(map[string][][]*float64, len(mvs)),
+	}
+	rows := make(map[int64]int, len(uts))
+	for i, ts := range uts {
+		rows[ts] = i
+		mm.Timestamps = append(mm.Timestamps, api.UnixTime(time.Unix(ts, 0)))
+	}
+	for _, v := range mvs {
+		vs := make([][]*float64, len(uts))
+		for i := range vs {
+			vs[i] = make([]*float64, len(ids))
+		}
+		mm.Values[v] = vs
+	}
+
+	for i := range dms {
+		dm := &dms[i]
+		col, ok := cols[int(dm.StationId.Int64)]
+		if !ok || dm.Timestamp == nil {
+			continue
+		}
+		row := rows[dm.Timestamp.Unix()]
+		for _, v := range mvs {
+			if f, ok := measurementValue(*dm, v); ok {
+				mm.Values[v][row][col] = &f
+			}
+		}
+	}
+
+	return mm
+}
@@ -0,0 +1,109 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"database/sql"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/cridenour/go-postgis"
+
+	"github.com/openairtech/apiserver/db"
+)
+
+func TestParseSeriesLayout(t *testing.T) {
+	tests := []struct {
+		query  string
+		layout string
+		rs     *db.Resampling
+		tz     string
+		err    bool
+	}{
+		{query: "", layout: "", rs: nil},
+		{query: "layout=matrix", layout: layoutMatrix, rs: nil},
+		{query: "layout=table", err: true},
+		{query: "resample=1h", layout: "", rs: &db.Resampling{Step: time.Hour}},
+		{query: "resample=30s", err: true},
+		{query: "resample=1x", err: true},
+		// Calendar resampling without tz is done in station time zones
+		{query: "resample=day", rs: &db.Resampling{Unit: db.ResampleDay}},
+		{query: "layout=series&resample=week&tz=Europe/Moscow", layout: layoutSeries,
+			rs: &db.Resampling{Unit: db.ResampleWeek}, tz: "Europe/Moscow"},
+		{query: "resample=month&tz=UTC", rs: &db.Resampling{Unit: db.ResampleMonth}, tz: "UTC"},
+		{query: "resample=day&tz=Mars/Olympus", err: true},
+		{query: "resample=day&tz=Local", err: true},
+		{query: "resample=1h&tz=Europe/Moscow", err: true},
+		{query: "tz=Europe/Moscow", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			q, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			layout, rs, err := parseSeriesLayout(q)
+			if tt.err {
+				if err == nil {
+					t.Errorf("parseSeriesLayout() succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseSeriesLayout() error = %v", err)
+			}
+			if layout != tt.layout {
+				t.Errorf("parseSeriesLayout() layout = %q, want %q", layout, tt.layout)
+			}
+			if (rs == nil) != (tt.rs == nil) {
+				t.Fatalf("parseSeriesLayout() resampling = %+v, want %+v", rs, tt.rs)
+			}
+			if rs == nil {
+				return
+			}
+			if rs.Step != tt.rs.Step || rs.Unit != tt.rs.Unit {
+				t.Errorf("parseSeriesLayout() resampling = %+v, want %+v", rs, tt.rs)
+			}
+			var tz string
+			if rs.Location != nil {
+				tz = rs.Location.String()
+			}
+			if tz != tt.tz {
+				t.Errorf("parseSeriesLayout() time zone = %q, want %q", tz, tt.tz)
+			}
+		})
+	}
+}
+
+func TestStationTimezone(t *testing.T) {
+	tests := []struct {
+		tz   sql.NullString
+		lon  float64
+		want string
+	}{
+		{sql.NullString{}, 44.5, "Etc/GMT-3"},
+		{sql.NullString{}, -74, "Etc/GMT+5"},
+		{sql.NullString{String: "Europe/Moscow", Valid: true}, -74, "Europe/Moscow"},
+		{sql.NullString{String: "Mars/Olympus", Valid: true}, 44.5, "Etc/GMT-3"},
+		{sql.NullString{String: "", Valid: true}, 0, "Etc/GMT"},
+	}
+	for _, tt := range tests {
+		s := db.Station{Id: 1, Timezone: tt.tz, Location: postgis.PointS{SRID: 4326, X: tt.lon}}
+		if got := stationTimezone(s).String(); got != tt.want {
+			t.Errorf("stationTimezone(%q, %v) = %s, want %s", tt.tz.String, tt.lon, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/openairtech/api"
+	"github.com/openairtech/apiserver/db"
+	httputil "github.com/openairtech/apiserver/http/util"
+	"github.com/openairtech/apiserver/monitor"
+	"github.com/openairtech/apiserver/util"
+)
+
+// outagesDefaultPeriod is the default time range of station outages history
+const outagesDefaultPeriod = 30 * 24 * time.Hour
+
+// stationUptimePeriods are periods of station uptime statistics
+var stationUptimePeriods = []struct {
+	name   string
+	period time.Duration
+}{
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+}
+
+// StationSensors describes station sensors hardware as reported by station.
+type StationSensors struct {
+	Firmware string `json:"firmware,omitempty"`
+	// Variables are measurement variables reported within the longest uptime period
+	Variables []string `json:"variables"`
+}
+
+// StationUptime is a station uptime within period.
+type StationUptime struct {
+	Period string `json:"period"`
+	// Hours is the number of hours with measurements
+	Hours int `json:"hours"`
+	// Uptime is the percentage of hours with measurements
+	Uptime float64 `json:"uptime"`
+}
+
+// StationDetails is a station with its full metadata and activity.
+type StationDetails struct {
+	api.Station
+	Version  string          `json:"version,omitempty"`
+	Timezone string          `json:"tz"`
+	Sensors  StationSensors  `json:"sensors"`
+	Uptime   []StationUptime `json:"uptime"`
+	// Status is online, late, offline or unknown (never seen)
+	Status      string        `json:"status"`
+	StatusSince *api.UnixTime `json:"status_since,omitempty"`
+	// ExpectedInterval is an expected station reporting interval in seconds learned by stations monitor
+	ExpectedInterval int `json:"expected_interval"`
+}
+
+// StationResult is a result of station query.
+type StationResult struct {
+	api.Result
+	Station StationDetails `json:"station"`
+}
+
+// StationGetHandler handles station details requests. Private stations are returned only if sall
+// parameter is set, same as for stations list requests.
+func StationGetHandler(db *db.Db) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			writeResult(w, api.StatusBadRequest, fmt.Sprintf("invalid station id: %s", mux.Vars(r)["id"]))
+			return
+		}
+
+		sall := r.URL.Query().Get("sall") != ""
+
+		sd, err := stationDetails(r.Context(), db, id, sall)
+		if err != nil {
+			m := fmt.Sprintf("can't get station: %v", err)
+			writeResult(w, api.StatusServerError, m)
+			log.Error(m)
+			return
+		}
+		if sd == nil {
+			writeResult(w, api.StatusNotFound, fmt.Sprintf("station not found: %d", id))
+			return
+		}
+
+		httputil.SetCacheControl(w, cacheMaxAge(nil))
+		httputil.WriteJsonResponse(w, StationResult{
+			Result:  api.Result{Status: api.StatusOk},
+			Station: *sd,
+		})
+	})
+}
+
+// stationDetails gets details of station with given ID, returns nil if there is no such station
+// or it is private and sall is not set.
+func stationDetails(ctx context.Context, d *db.Db, id int, sall bool) (*StationDetails, error) {
+	s, err := d.Station(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil || (!s.IsPublic && !sall) {
+		return nil, nil
+	}
+
+	now := time.Now()
+	since := make([]time.Time, len(stationUptimePeriods))
+	for i, up := range stationUptimePeriods {
+		since[i] = now.Add(-up.period)
+	}
+	sa, err := d.StationActivity(ctx, id, since)
+	if err != nil {
+		return nil, err
+	}
+
+	sd := &StationDetails{
+		Station:  s.ApiStation(),
+		Version:  s.Version.String,
+		Timezone: stationTimezone(*s).String(),
+		Sensors:  StationSensors{Firmware: s.Version.String, Variables: []string{}},
+	}
+	for _, v := range measurementVars {
+		if sa.Counts[v] > 0 {
+			sd.Sensors.Variables = append(sd.Sensors.Variables, v)
+		}
+	}
+	for i, up := range stationUptimePeriods {
+		sd.Uptime = append(sd.Uptime, StationUptime{
+			Period: up.name,
+			Hours:  sa.Hours[i],
+			Uptime: statsCompleteness(sa.Hours[i], since[i], now),
+		})
+	}
+
+	// Status is classified at request time using current reporting interval learned by stations monitor,
+	// so it is actual even if monitor hasn't checked station yet
+	interval, err := d.StationInterval(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	interval = monitor.ExpectedInterval(interval)
+	status, statusSince := monitor.Classify(s.Seen, interval, now)
+	sd.Status, sd.ExpectedInterval = status, int(interval.Seconds())
+	if status != monitor.StatusUnknown {
+		ss := api.UnixTime(statusSince)
+		sd.StatusSince = &ss
+	}
+
+	return sd, nil
+}
+
+// StationOutage is a period of station being offline, To is not set for ongoing outage.
+type StationOutage struct {
+	From api.UnixTime  `json:"from"`
+	To   *api.UnixTime `json:"to,omitempty"`
+	// Duration is an outage duration in seconds, up to now for ongoing outage
+	Duration int `json:"duration"`
+}
+
+// StationOutagesResult is a result of station outages query.
+type StationOutagesResult struct {
+	api.Result
+	StationId int             `json:"station_id"`
+	From      api.UnixTime    `json:"from"`
+	To        api.UnixTime    `json:"to"`
+	Outages   []StationOutage `json:"outages"`
+}
+
+// StationOutagesHandler handles requests of station outages history recorded by stations monitor within
+// time range set by from and to parameters (last 30 days by default). Private stations are returned
+// only if sall parameter is set.
+func StationOutagesHandler(db *db.Db) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			writeResult(w, api.StatusBadRequest, fmt.Sprintf("invalid station id: %s", mux.Vars(r)["id"]))
+			return
+		}
+
+		from, err := util.ParseUnixTime(query.Get("from"))
+		if err != nil {
+			writeResult(w, api.StatusBadRequest, fmt.Sprint(err))
+			return
+		}
+
+		to, err := util.ParseUnixTime(query.Get("to"))
+		if err != nil {
+			writeResult(w, api.StatusBadRequest, fmt.Sprint(err))
+			return
+		}
+		if to == nil {
+			now := time.Now()
+			to = &now
+		}
+		if from == nil {
+			f := to.Add(-outagesDefaultPeriod)
+			from = &f
+		}
+		if !from.Before(*to) {
+			writeResult(w, api.StatusBadRequest, "'from' time must be before 'to' one")
+			return
+		}
+
+		sall := query.Get("sall") != ""
+
+		res, err := stationOutages(r.Context(), db, id, *from, *to, sall)
+		if err != nil {
+			m := fmt.Sprintf("can't get station outages: %v", err)
+			writeResult(w, api.StatusServerError, m)
+			log.Error(m)
+			return
+		}
+		if res == nil {
+			writeResult(w, api.StatusNotFound, fmt.Sprintf("station not found: %d", id))
+			return
+		}
+
+		httputil.SetCacheControl(w, cacheMaxAge(to))
+		httputil.WriteJsonResponse(w, res)
+	})
+}
+
+// stationOutages gets outages of station with given ID within time range [from, to), returns nil
+// if there is no such station or it is private and sall is not set.
+func stationOutages(ctx context.Context, d *db.Db, id int, from, to time.Time,
+	sall bool) (*StationOutagesResult, error) {
+	ss, err := d.StationsById(ctx, []int{id})
+	if err != nil {
+		return nil, err
+	}
+	if len(ss) == 0 || (!ss[0].IsPublic && !sall) {
+		return nil, nil
+	}
+
+	sss, err := d.StationStatusHistory(ctx, id, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &StationOutagesResult{
+		Result:    api.Result{Status: api.StatusOk},
+		StationId: id,
+		From:      api.UnixTime(from),
+		To:        api.UnixTime(to),
+		Outages:   []StationOutage{},
+	}
+
+	now := time.Now()
+	for _, o := range monitor.Outages(sss, from, to) {
+		so := StationOutage{From: api.UnixTime(o.From)}
+		end := now
+		if o.To != nil {
+			t := api.UnixTime(*o.To)
+			so.To, end = &t, *o.To
+		}
+		so.Duration = int(end.Sub(o.From).Seconds())
+		res.Outages = append(res.Outages, so)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,210 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/openairtech/api"
+	"github.com/openairtech/apiserver/aqi"
+	"github.com/openairtech/apiserver/db"
+	httputil "github.com/openairtech/apiserver/http/util"
+	"github.com/openairtech/apiserver/util"
+)
+
+// statsMaxPeriod is the maximum time range of station statistics
+const statsMaxPeriod = 366 * 24 * time.Hour
+
+// VariableStats are statistics of measured variable values.
+type VariableStats struct {
+	Count int      `json:"count"`
+	Min   *float64 `json:"min,omitempty"`
+	Max   *float64 `json:"max,omitempty"`
+	Mean  *float64 `json:"mean,omitempty"`
+	// Percentiles are keyed by percentile names like "p50"
+	Percentiles map[string]float64 `json:"percentiles,omitempty"`
+}
+
+// GuidelineExceedances are numbers of hourly and daily means exceeding air quality guideline value.
+type GuidelineExceedances struct {
+	Guideline float64 `json:"guideline"`
+	Hours     int     `json:"hours"`
+	Days      int     `json:"days"`
+	// ValidDays is the number of days having at least 18 hourly means, only those days are checked
+	ValidDays int `json:"valid_days"`
+}
+
+// StationStatsResult is a result of station statistics query.
+type StationStatsResult struct {
+	api.Result
+	StationId    int          `json:"station_id"`
+	Timezone     string       `json:"tz"`
+	From         api.UnixTime `json:"from"`
+	To           api.UnixTime `json:"to"`
+	Measurements int          `json:"measurements"`
+	// Completeness is the percentage of hours within time range having measurements
+	Completeness float64                         `json:"completeness"`
+	Variables    map[string]VariableStats        `json:"variables"`
+	Exceedances  map[string]GuidelineExceedances `json:"exceedances"`
+}
+
+// StationStatsHandler handles requests of statistics of station measurements taken within time range
+// set by from and optional to parameters: per variable summaries, data completeness and numbers of hours
+// and days exceeding WHO 2021 24-hour guideline values for PM2.5 and PM10. Days are local ones of
+// time zone set by tz parameter or station time zone. Private stations are returned only if sall
+// parameter is set.
+func StationStatsHandler(db *db.Db) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			writeResult(w, api.StatusBadRequest, fmt.Sprintf("invalid station id: %s", mux.Vars(r)["id"]))
+			return
+		}
+
+		tz, err := util.ParseTimezone(query.Get("tz"))
+		if err != nil {
+			writeResult(w, api.StatusBadRequest, fmt.Sprint(err))
+			return
+		}
+
+		from, err := util.ParseUnixTime(query.Get("from"))
+		if err != nil {
+			writeResult(w, api.StatusBadRequest, fmt.Sprint(err))
+			return
+		}
+		if from == nil {
+			writeResult(w, api.StatusBadRequest, "'from' parameter is required")
+			return
+		}
+
+		to, err := util.ParseUnixTime(query.Get("to"))
+		if err != nil {
+			writeResult(w, api.StatusBadRequest, fmt.Sprint(err))
+			return
+		}
+		if to == nil {
+			now := time.Now()
+			to = &now
+		}
+
+		if !from.Before(*to) {
+			writeResult(w, api.StatusBadRequest, "'from' time must be before 'to' one")
+			return
+		}
+		if to.Sub(*from) > statsMaxPeriod {
+			writeResult(w, api.StatusBadRequest, fmt.Sprintf("time range can't exceed %v", statsMaxPeriod))
+			return
+		}
+
+		sall := query.Get("sall") != ""
+
+		res, err := stationStats(r.Context(), db, id, *from, *to, tz, sall)
+		if err != nil {
+			m := fmt.Sprintf("can't get station statistics: %v", err)
+			writeResult(w, api.StatusServerError, m)
+			log.Error(m)
+			return
+		}
+		if res == nil {
+			writeResult(w, api.StatusNotFound, fmt.Sprintf("station not found: %d", id))
+			return
+		}
+
+		httputil.SetCacheControl(w, cacheMaxAge(to))
+		httputil.WriteJsonResponse(w, res)
+	})
+}
+
+// stationStats gets statistics of measurements of station with given ID taken within time range [from, to)
+// in time zone tz or station time zone if tz is nil, returns nil if there is no such station or it is private
+// and sall is not set.
+func stationStats(ctx context.Context, d *db.Db, id int, from, to time.Time,
+	tz *time.Location, sall bool) (*StationStatsResult, error) {
+	ss, err := d.StationsById(ctx, []int{id})
+	if err != nil {
+		return nil, err
+	}
+	if len(ss) == 0 || (!ss[0].IsPublic && !sall) {
+		return nil, nil
+	}
+
+	if tz == nil {
+		tz = stationTimezone(ss[0])
+	}
+
+	st, err := d.StationStats(ctx, id, from, to, aqi.WhoPm25DailyGuideline, aqi.WhoPm10DailyGuideline, tz)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &StationStatsResult{
+		Result:       api.Result{Status: api.StatusOk},
+		StationId:    id,
+		Timezone:     tz.String(),
+		From:         api.UnixTime(from),
+		To:           api.UnixTime(to),
+		Measurements: st.Count,
+		Completeness: statsCompleteness(st.Hours, from, to),
+		Variables:    make(map[string]VariableStats, len(st.Variables)),
+		Exceedances: map[string]GuidelineExceedances{
+			"pm25": guidelineExceedances(st.Pm25, aqi.WhoPm25DailyGuideline),
+			"pm10": guidelineExceedances(st.Pm10, aqi.WhoPm10DailyGuideline),
+		},
+	}
+
+	for v, vs := range st.Variables {
+		s := VariableStats{Count: vs.Count}
+		if vs.Min.Valid {
+			s.Min, s.Max, s.Mean = &vs.Min.Float64, &vs.Max.Float64, &vs.Mean.Float64
+		}
+		if len(vs.Percentiles) == len(db.StatsPercentiles) {
+			s.Percentiles = make(map[string]float64, len(vs.Percentiles))
+			for i, p := range db.StatsPercentiles {
+				s.Percentiles[fmt.Sprintf("p%d", int(math.Round(p*100)))] = vs.Percentiles[i]
+			}
+		}
+		res.Variables[v] = s
+	}
+
+	return res, nil
+}
+
+// statsCompleteness returns percentage of hours within time range [from, to) having measurements.
+func statsCompleteness(hours int, from, to time.Time) float64 {
+	total := math.Ceil(to.Sub(from.Truncate(time.Hour)).Hours())
+	if total <= 0 {
+		return 0
+	}
+	return math.Min(100, math.Round(float64(hours)/total*1000)/10)
+}
+
+func guidelineExceedances(e db.Exceedances, guideline float64) GuidelineExceedances {
+	return GuidelineExceedances{
+		Guideline: guideline,
+		Hours:     e.Hours,
+		Days:      e.Days,
+		ValidDays: e.ValidDays,
+	}
+}
@@ -15,27 +15,56 @@
 package v1
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/openairtech/api"
+	"github.com/openairtech/apiserver/cache"
 	"github.com/openairtech/apiserver/db"
 	httputil "github.com/openairtech/apiserver/http/util"
 	"github.com/openairtech/apiserver/util"
 )
 
-func StationsGetHandler(db *db.Db) http.Handler {
+// StationsGetHandler handles stations requests. Requests for stations with their last measurements
+// are served from stations cache sc, if it is set and ready, while historical ones are served from database.
+// POST requests carry polygon to get stations within in body.
+func StationsGetHandler(db *db.Db, sc *cache.Stations) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		query := r.URL.Query()
 
+		format, err := responseFormat(r)
+		if err != nil {
+			writeResult(w, api.StatusBadRequest, fmt.Sprint(err))
+			return
+		}
+
 		bbox, err := util.ParseBBox(query.Get("bbox"))
 		if err != nil {
 			writeResult(w, api.StatusBadRequest, fmt.Sprint(err))
 			return
 		}
 
+		nq, err := parseNearQuery(query)
+		if err != nil {
+			writeResult(w, api.StatusBadRequest, fmt.Sprint(err))
+			return
+		}
+
+		region, err := parseRegion(r)
+		if err != nil {
+			writeResult(w, api.StatusBadRequest, fmt.Sprint(err))
+			return
+		}
+		if region != nil && nq != nil {
+			writeResult(w, api.StatusBadRequest, "region can't be used along with 'near' or 'nearest' parameters")
+			return
+		}
+
 		mfrom, err := util.ParseUnixTime(query.Get("mfrom"))
 		if err != nil {
 			writeResult(w, api.StatusBadRequest, fmt.Sprint(err))
@@ -50,7 +79,21 @@ func StationsGetHandler(db *db.Db) http.Handler {
 
 		sall := query.Get("sall") != ""
 
-		dss, err := db.Stations(bbox, mfrom, mlast, sall)
+		page, err := parseStationsPage(query, nq != nil)
+		if err != nil {
+			writeResult(w, api.StatusBadRequest, fmt.Sprint(err))
+			return
+		}
+
+		dss, err := queryStations(r.Context(), db, sc, bbox, nq, region, mfrom, mlast, sall)
+		if isAreaNotFound(err) {
+			writeResult(w, api.StatusNotFound, fmt.Sprintf("area not found: %s", region.Area))
+			return
+		}
+		if isInvalidRegion(err) {
+			writeResult(w, api.StatusBadRequest, fmt.Sprint(err))
+			return
+		}
 		if err != nil {
 			m := fmt.Sprintf("can't get stations: %v", err)
 			writeResult(w, api.StatusServerError, m)
@@ -58,14 +101,121 @@ func StationsGetHandler(db *db.Db) http.Handler {
 			return
 		}
 
-		var as []api.Station
-		for _, ds := range dss {
-			as = append(as, ds.ApiStation())
+		dss, next := page.apply(dss)
+
+		res, err := stationsResponse(dss, format, nq != nil, page, next)
+		if err != nil {
+			writeResult(w, api.StatusServerError, fmt.Sprint(err))
+			return
 		}
 
-		httputil.WriteJsonResponse(w, api.StationsResult{
-			Result:   api.Result{Status: api.StatusOk},
-			Stations: as,
-		})
+		b, err := json.Marshal(res)
+		if err != nil {
+			writeResult(w, api.StatusServerError, fmt.Sprint(err))
+			return
+		}
+
+		// Stations metadata changes have no timestamps, so entity tag is derived from response content
+		w.Header().Add("Vary", "Accept")
+		httputil.SetCacheControl(w, cacheMaxAge(mfrom))
+		if httputil.CheckNotModified(w, r, httputil.NewContentValidator(b, newestStationMeasurement(dss))) {
+			return
+		}
+
+		contentType := "application/json"
+		if format == formatGeoJson {
+			contentType = geoJsonContentType
+		}
+		w.Header().Set("Content-Type", contentType)
+		_, _ = w.Write(append(b, '\n'))
 	})
 }
+
+// stationsResponse returns response of stations dss in given format with cursor of the next page next.
+func stationsResponse(dss []db.Station, format string, near bool, page *stationsPage,
+	next string) (interface{}, error) {
+	if format == formatGeoJson {
+		fc := stationsFeatureCollection(dss)
+		fc.Next = next
+		for i, f := range fc.Features {
+			var err error
+			if fc.Features[i].Properties, err = page.project(f.Properties); err != nil {
+				return nil, err
+			}
+		}
+		return fc, nil
+	}
+
+	if page.paged() {
+		return stationsPageResult(dss, near, page, next)
+	}
+
+	if near {
+		return NearStationsResult{
+			Result:   api.Result{Status: api.StatusOk},
+			Stations: nearStations(dss),
+		}, nil
+	}
+
+	var as []api.Station
+	for _, ds := range dss {
+		as = append(as, ds.ApiStation())
+	}
+
+	return api.StationsResult{
+		Result:   api.Result{Status: api.StatusOk},
+		Stations: as,
+	}, nil
+}
+
+// stationsPageResult returns page of stations dss with cursor of the next page next.
+func stationsPageResult(dss []db.Station, near bool, page *stationsPage, next string) (*StationsPageResult, error) {
+	res := &StationsPageResult{
+		Result:   api.Result{Status: api.StatusOk},
+		Stations: make([]interface{}, 0, len(dss)),
+		Next:     next,
+	}
+
+	var ss []interface{}
+	if near {
+		for _, ns := range nearStations(dss) {
+			ss = append(ss, ns)
+		}
+	} else {
+		for _, ds := range dss {
+			ss = append(ss, ds.ApiStation())
+		}
+	}
+
+	for _, s := range ss {
+		ps, err := page.project(s)
+		if err != nil {
+			return nil, err
+		}
+		res.Stations = append(res.Stations, ps)
+	}
+
+	return res, nil
+}
+
+// queryStations gets stations near point, if near query nq is set, or within bounding box and region otherwise.
+func queryStations(ctx context.Context, d *db.Db, sc *cache.Stations, bbox []float64, nq *nearQuery,
+	region *db.Region, mfrom *time.Time, mlast *time.Duration, sall bool) ([]db.Station, error) {
+	if nq != nil {
+		return d.StationsNear(ctx, nq.lon, nq.lat, nq.radius, nq.k, mfrom, mlast, sall)
+	}
+	if region != nil {
+		return d.StationsWithin(ctx, bbox, region, mfrom, mlast, sall)
+	}
+	return stations(ctx, d, sc, bbox, mfrom, mlast, sall)
+}
+
+// stations gets stations from cache sc, if it is set and has stations with their last measurements requested,
+// or from database d otherwise.
+func stations(ctx context.Context, d *db.Db, sc *cache.Stations, bbox []float64, mfrom *time.Time,
+	mlast *time.Duration, sall bool) ([]db.Station, error) {
+	if sc != nil && mfrom == nil && sc.Ready() {
+		return sc.Stations(bbox, mlast, sall), nil
+	}
+	return d.Stations(ctx, bbox, mfrom, mlast, sall)
+}
@@ -0,0 +1,249 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/openairtech/api"
+	"github.com/openairtech/apiserver/stream"
+	"github.com/openairtech/apiserver/util"
+)
+
+const (
+	// streamHeartbeatInterval is the interval of keepalive messages sent to idle stream clients
+	streamHeartbeatInterval = 15 * time.Second
+	// streamRetry is the reconnection delay suggested to SSE clients
+	streamRetry = 3 * time.Second
+	// streamWriteTimeout is the maximum time of writing single message to WebSocket client
+	streamWriteTimeout = 10 * time.Second
+)
+
+// StreamEvent is a live measurement event sent to stream clients.
+type StreamEvent struct {
+	Id          uint64          `json:"id"`
+	StationId   int             `json:"station_id"`
+	Measurement api.Measurement `json:"measurement"`
+}
+
+var upgrader = websocket.Upgrader{
+	// Stream is public as any other API resource, so allow any origin
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// StreamHandler handles Server-Sent Events stream of live measurements.
+func StreamHandler(hub *stream.Hub) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, err := streamFilter(r)
+		if err != nil {
+			writeResult(w, api.StatusBadRequest, fmt.Sprint(err))
+			return
+		}
+
+		lid := r.Header.Get("Last-Event-ID")
+		if lid == "" {
+			lid = r.URL.Query().Get("last_event_id")
+		}
+		lastId, err := parseEventId(lid)
+		if err != nil {
+			writeResult(w, api.StatusBadRequest, fmt.Sprint(err))
+			return
+		}
+
+		rc := http.NewResponseController(w)
+		// Stream is endless, so server write timeout is not applicable to it
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+			log.Debugf("can't reset stream write deadline: %v", err)
+		}
+
+		sub := hub.Subscribe(f, lastId)
+		defer sub.Close()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		if _, err := fmt.Fprintf(w, "retry: %d\n\n", streamRetry.Milliseconds()); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			log.Errorf("can't flush stream: %v", err)
+			return
+		}
+
+		t := time.NewTicker(streamHeartbeatInterval)
+		defer t.Stop()
+
+		for {
+			select {
+			case e, ok := <-sub.C:
+				if !ok {
+					return
+				}
+				b, err := json.Marshal(streamEvent(e, f.Vars))
+				if err != nil {
+					log.Errorf("can't marshal stream event: %v", err)
+					return
+				}
+				if _, err := fmt.Fprintf(w, "id: %d\nevent: measurement\ndata: %s\n\n", e.Id, b); err != nil {
+					return
+				}
+			case <-t.C:
+				if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+					return
+				}
+			case <-r.Context().Done():
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// StreamWebSocketHandler handles WebSocket stream of live measurements.
+func StreamWebSocketHandler(hub *stream.Hub) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, err := streamFilter(r)
+		if err != nil {
+			writeResult(w, api.StatusBadRequest, fmt.Sprint(err))
+			return
+		}
+
+		lastId, err := parseEventId(r.URL.Query().Get("last_event_id"))
+		if err != nil {
+			writeResult(w, api.StatusBadRequest, fmt.Sprint(err))
+			return
+		}
+
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// Upgrader has already replied with error
+			log.Debugf("can't upgrade stream connection: %v", err)
+			return
+		}
+		defer util.CloseQuietly(c)
+
+		sub := hub.Subscribe(f, lastId)
+		defer sub.Close()
+
+		// Read messages to process control frames and detect connection close
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			_ = c.SetReadDeadline(time.Now().Add(2 * streamHeartbeatInterval))
+			c.SetPongHandler(func(string) error {
+				return c.SetReadDeadline(time.Now().Add(2 * streamHeartbeatInterval))
+			})
+			for {
+				if _, _, err := c.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		t := time.NewTicker(streamHeartbeatInterval)
+		defer t.Stop()
+
+		for {
+			select {
+			case e, ok := <-sub.C:
+				if !ok {
+					_ = c.WriteControl(websocket.CloseMessage,
+						websocket.FormatCloseMessage(websocket.CloseGoingAway, ""),
+						time.Now().Add(streamWriteTimeout))
+					return
+				}
+				_ = c.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+				if err := c.WriteJSON(streamEvent(e, f.Vars)); err != nil {
+					return
+				}
+			case <-t.C:
+				if err := c.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout)); err != nil {
+					return
+				}
+			case <-closed:
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+}
+
+// streamFilter parses stream request r filter parameters.
+func streamFilter(r *http.Request) (stream.Filter, error) {
+	query := r.URL.Query()
+
+	var f stream.Filter
+
+	bbox, err := util.ParseBBox(query.Get("bbox"))
+	if err != nil {
+		return f, err
+	}
+	f.BBox = bbox
+
+	if ss := query.Get("stations"); ss != "" {
+		f.Stations = make(map[int]bool)
+		for _, s := range strings.Split(ss, ",") {
+			id, err := strconv.ParseInt(strings.TrimSpace(s), 10, 32)
+			if err != nil {
+				return f, fmt.Errorf("can't parse station id: %v", err)
+			}
+			f.Stations[int(id)] = true
+		}
+	}
+
+	if v := query.Get("v"); v != "" {
+		f.Vars = strings.Split(v, ",")
+		if err := checkMeasurementVars(f.Vars); err != nil {
+			return f, err
+		}
+	}
+
+	f.All = query.Get("sall") != ""
+
+	return f, nil
+}
+
+// parseEventId parses last received event ID s, returns nil if s is empty.
+func parseEventId(s string) (*uint64, error) {
+	if s == "" {
+		return nil, nil
+	}
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse last event id: %v", err)
+	}
+	return &id, nil
+}
+
+func streamEvent(e stream.Event, vars []string) StreamEvent {
+	return StreamEvent{
+		Id:          e.Id,
+		StationId:   e.StationId,
+		Measurement: stream.FilterVars(e.Measurement, vars),
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestStreamFilter(t *testing.T) {
+	tests := []struct {
+		query string
+		vars  []string
+		err   bool
+	}{
+		{query: "", vars: nil},
+		{query: "v=pm25,aqi", vars: []string{"pm25", "aqi"}},
+		{query: "v=pm25,pm1", err: true},
+		{query: "stations=1,x", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			f, err := streamFilter(httptest.NewRequest("GET", "/v1/stream?"+tt.query, nil))
+			if (err != nil) != tt.err {
+				t.Fatalf("streamFilter() error = %v, want error %v", err, tt.err)
+			}
+			if err == nil && !reflect.DeepEqual(f.Vars, tt.vars) {
+				t.Errorf("streamFilter() vars = %v, want %v", f.Vars, tt.vars)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/openairtech/api"
+	"github.com/openairtech/apiserver/db"
+	httputil "github.com/openairtech/apiserver/http/util"
+	"github.com/openairtech/apiserver/stats"
+	"github.com/openairtech/apiserver/util"
+)
+
+// summaryMeasurementMaxAge is the default maximum age of station last measurement included in area summary
+const summaryMeasurementMaxAge = time.Hour
+
+// VariableSummary is a robust summary of measured variable values of area stations.
+type VariableSummary struct {
+	Median float64 `json:"median"`
+	// Min and Max are the minimum and maximum values excluding outliers
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+	// Stations is the number of stations reporting variable
+	Stations int `json:"stations"`
+	// Outliers are IDs of stations which values are considered outliers
+	Outliers []int `json:"outliers,omitempty"`
+}
+
+// AreaSummaryResult is a result of area summary query.
+type AreaSummaryResult struct {
+	api.Result
+	Area AreaInfo `json:"area"`
+	// From and To are set for summary of historical time range
+	From *api.UnixTime `json:"from,omitempty"`
+	To   *api.UnixTime `json:"to,omitempty"`
+	// Stations is the number of reporting stations
+	Stations int              `json:"stations"`
+	Pm25     *VariableSummary `json:"pm25,omitempty"`
+	Pm10     *VariableSummary `json:"pm10,omitempty"`
+	Aqi      *VariableSummary `json:"aqi,omitempty"`
+	// WorstStation is the station with the highest AQI excluding outliers
+	WorstStation *api.Station `json:"worst_station,omitempty"`
+}
+
+// AreaSummaryHandler handles area air quality summary requests. Summary is computed from last measurements
+// of public area stations, or from median values of station measurements taken within time range set by
+// from (and optional to) parameters. Outliers (e.g. values of broken sensors) are excluded from summary.
+func AreaSummaryHandler(db *db.Db) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			writeResult(w, api.StatusBadRequest, fmt.Sprintf("invalid area id: %s", mux.Vars(r)["id"]))
+			return
+		}
+
+		from, err := util.ParseUnixTime(query.Get("from"))
+		if err != nil {
+			writeResult(w, api.StatusBadRequest, fmt.Sprint(err))
+			return
+		}
+
+		to, err := util.ParseUnixTime(query.Get("to"))
+		if err != nil {
+			writeResult(w, api.StatusBadRequest, fmt.Sprint(err))
+			return
+		}
+		if to != nil && from == nil {
+			writeResult(w, api.StatusBadRequest, "'to' parameter can't be used without 'from' one")
+			return
+		}
+
+		mlast, err := util.ParseDuration(query.Get("mlast"))
+		if err != nil {
+			writeResult(w, api.StatusBadRequest, fmt.Sprint(err))
+			return
+		}
+		if mlast != nil && from != nil {
+			writeResult(w, api.StatusBadRequest, "'mlast' parameter can't be used along with 'from' one")
+			return
+		}
+
+		a, err := db.Area(r.Context(), id)
+		if err != nil {
+			m := fmt.Sprintf("can't get area: %v", err)
+			writeResult(w, api.StatusServerError, m)
+			log.Error(m)
+			return
+		}
+		if a == nil {
+			writeResult(w, api.StatusNotFound, fmt.Sprintf("area not found: %d", id))
+			return
+		}
+
+		res := AreaSummaryResult{
+			Result: api.Result{Status: api.StatusOk},
+			Area: AreaInfo{
+				Id:         a.Id,
+				Name:       a.Name,
+				BBox:       a.BBox,
+				Properties: a.Properties,
+			},
+		}
+
+		if from != nil {
+			if to == nil {
+				now := time.Now()
+				to = &now
+			}
+			af, at := api.UnixTime(*from), api.UnixTime(*to)
+			res.From, res.To = &af, &at
+		} else if mlast == nil {
+			d := summaryMeasurementMaxAge
+			mlast = &d
+		}
+
+		dss, err := areaStations(r.Context(), db, id, from, to, mlast)
+		if err != nil {
+			m := fmt.Sprintf("can't get area stations: %v", err)
+			writeResult(w, api.StatusServerError, m)
+			log.Error(m)
+			return
+		}
+
+		summarizeArea(&res, dss)
+
+		httputil.SetCacheControl(w, cacheMaxAge(to))
+		httputil.WriteJsonResponse(w, res)
+	})
+}
+
+// areaStations gets reporting public stations within area with given ID along with median values
+// of their measurements taken within time range [from, to], if from is set, or their last measurements
+// not older than mlast otherwise.
+func areaStations(ctx context.Context, d *db.Db, id int, from, to *time.Time,
+	mlast *time.Duration) ([]db.Station, error) {
+	if from != nil {
+		return d.AreaStationMedians(ctx, id, *from, *to)
+	}
+
+	dss, err := d.StationsWithin(ctx, nil, &db.Region{AreaId: id}, nil, mlast, false)
+	if err != nil {
+		return nil, err
+	}
+	var rss []db.Station
+	for _, ds := range dss {
+		if ds.Measurement.Id.Valid {
+			rss = append(rss, ds)
+		}
+	}
+	return rss, nil
+}
+
+// summarizeArea sets summary of measurements of area stations dss to result res.
+func summarizeArea(res *AreaSummaryResult, dss []db.Station) {
+	res.Stations = len(dss)
+	res.Pm25, _ = summarizeVariable(dss, func(m db.Measurement) sql.NullFloat64 { return m.Pm25 })
+	res.Pm10, _ = summarizeVariable(dss, func(m db.Measurement) sql.NullFloat64 { return m.Pm10 })
+	var worst *db.Station
+	res.Aqi, worst = summarizeVariable(dss, func(m db.Measurement) sql.NullFloat64 {
+		return sql.NullFloat64{Float64: float64(m.Aqi.Int64), Valid: m.Aqi.Valid}
+	})
+	if worst != nil {
+		as := worst.ApiStation()
+		// Station measurement may be an aggregate having no ID
+		am := worst.Measurement.ApiMeasurement()
+		as.LastMeasurement = &am
+		res.WorstStation = &as
+	}
+}
+
+// summarizeVariable summarizes variable values got by function v from measurements of stations dss.
+// It returns nil summary if no station reports variable, and station with the maximum non-outlier value.
+func summarizeVariable(dss []db.Station, v func(db.Measurement) sql.NullFloat64) (*VariableSummary, *db.Station) {
+	var vs []float64
+	var ss []*db.Station
+	for i := range dss {
+		if nv := v(dss[i].Measurement); nv.Valid {
+			vs = append(vs, nv.Float64)
+			ss = append(ss, &dss[i])
+		}
+	}
+	if len(vs) == 0 {
+		return nil, nil
+	}
+
+	s := &VariableSummary{
+		Median:   stats.Median(vs),
+		Stations: len(vs),
+	}
+	var max *db.Station
+	for i, o := range stats.Outliers(vs) {
+		if o {
+			s.Outliers = append(s.Outliers, ss[i].Id)
+			continue
+		}
+		first := max == nil
+		if first || vs[i] > s.Max {
+			s.Max, max = vs[i], ss[i]
+		}
+		if first || vs[i] < s.Min {
+			s.Min = vs[i]
+		}
+	}
+
+	return s, max
+}
@@ -0,0 +1,105 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/openairtech/api"
+	"github.com/openairtech/apiserver/cache"
+	"github.com/openairtech/apiserver/db"
+	httputil "github.com/openairtech/apiserver/http/util"
+	"github.com/openairtech/apiserver/util"
+)
+
+const (
+	mvtContentType = "application/vnd.mapbox-vector-tile"
+
+	// tileMeasurementMaxAge is the default maximum age of station last measurement included in tile
+	tileMeasurementMaxAge = time.Hour
+)
+
+// TilesGetHandler handles stations vector tile requests. Tiles are served from tile cache tc, if it is set.
+func TilesGetHandler(db *db.Db, tc *cache.Tiles) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		z, x, y, err := tileCoords(mux.Vars(r))
+		if err != nil {
+			writeResult(w, api.StatusBadRequest, fmt.Sprint(err))
+			return
+		}
+
+		mlast := tileMeasurementMaxAge
+		if d, err := util.ParseDuration(r.URL.Query().Get("mlast")); err != nil {
+			writeResult(w, api.StatusBadRequest, fmt.Sprint(err))
+			return
+		} else if d != nil {
+			mlast = *d
+		}
+
+		sall := r.URL.Query().Get("sall") != ""
+
+		variant := fmt.Sprintf("%d|%t", int(mlast.Seconds()), sall)
+
+		tile, ok := []byte(nil), false
+		if tc != nil {
+			tile, ok = tc.Get(z, x, y, variant)
+		}
+		if !ok {
+			if tile, err = db.StationsTile(r.Context(), z, x, y, mlast, sall); err != nil {
+				m := fmt.Sprintf("can't get tile: %v", err)
+				writeResult(w, api.StatusServerError, m)
+				log.Error(m)
+				return
+			}
+			if tc != nil {
+				tc.Put(z, x, y, variant, tile)
+			}
+		}
+
+		httputil.SetCacheControl(w, liveMaxAge)
+		w.Header().Set("Content-Type", mvtContentType)
+		if len(tile) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		_, _ = w.Write(tile)
+	})
+}
+
+// tileCoords parses and validates tile coordinates from request path variables vars.
+func tileCoords(vars map[string]string) (int, int, int, error) {
+	var c [3]int
+	for i, n := range []string{"z", "x", "y"} {
+		v, err := strconv.Atoi(vars[n])
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("can't parse tile %s: %v", n, err)
+		}
+		c[i] = v
+	}
+	z, x, y := c[0], c[1], c[2]
+	if z < 0 || z > db.MaxTileZoom {
+		return 0, 0, 0, fmt.Errorf("tile zoom is out of range [0, %d]: %d", db.MaxTileZoom, z)
+	}
+	if n := 1 << uint(z); x < 0 || x >= n || y < 0 || y >= n {
+		return 0, 0, 0, fmt.Errorf("tile %d/%d/%d is out of range", z, x, y)
+	}
+	return z, x, y, nil
+}
@@ -0,0 +1,78 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/openairtech/apiserver/db"
+	"github.com/openairtech/apiserver/util"
+)
+
+// stationTimezone returns time zone of station s: configured one, if it is set and valid,
+// or nautical time zone of station location otherwise.
+func stationTimezone(s db.Station) *time.Location {
+	if s.Timezone.Valid {
+		tz, err := util.ParseTimezone(s.Timezone.String)
+		if err == nil && tz != nil {
+			return tz
+		}
+		log.Warnf("station %d has invalid time zone %q", s.Id, s.Timezone.String)
+	}
+	return util.LongitudeTimezone(s.Location.X)
+}
+
+// stationsMeasurementsInTimezones gets measurements of stations with given IDs resampled to calendar
+// intervals rs in station time zones, so stations of different time zones are queried separately.
+// Measurements are sorted by station ID and timestamp.
+func stationsMeasurementsInTimezones(ctx context.Context, d *db.Db, ids []int, from, to time.Time,
+	vars []string, rs db.Resampling) ([]db.Measurement, error) {
+	ss, err := d.StationsById(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	var tzs []*time.Location
+	tzIds := make(map[string][]int)
+	for _, s := range ss {
+		tz := stationTimezone(s)
+		if _, ok := tzIds[tz.String()]; !ok {
+			tzs = append(tzs, tz)
+		}
+		tzIds[tz.String()] = append(tzIds[tz.String()], s.Id)
+	}
+
+	var dms []db.Measurement
+	for _, tz := range tzs {
+		rs.Location = tz
+		tdms, err := d.StationsMeasurements(ctx, tzIds[tz.String()], from, to, vars, &rs)
+		if err != nil {
+			return nil, err
+		}
+		dms = append(dms, tdms...)
+	}
+
+	if len(tzs) > 1 {
+		sort.SliceStable(dms, func(i, j int) bool {
+			return dms[i].StationId.Int64 < dms[j].StationId.Int64
+		})
+	}
+
+	return dms, nil
+}
@@ -16,21 +16,33 @@ package http
 
 import (
 	"context"
+	"net"
 	"net/http"
 	"time"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 
+	"github.com/openairtech/apiserver/cache"
 	"github.com/openairtech/apiserver/db"
+	"github.com/openairtech/apiserver/event"
 	v1 "github.com/openairtech/apiserver/http/handler/v1"
+	httputil "github.com/openairtech/apiserver/http/util"
+	"github.com/openairtech/apiserver/ingest"
+	"github.com/openairtech/apiserver/stream"
 )
 
+// streamReplaySize is the number of recent live measurement events kept to resume streams
+const streamReplaySize = 1024
+
 type Server struct {
 	http *http.Server
+	// cancel cancels base context of all requests being served
+	cancel context.CancelFunc
 }
 
-func NewServer(buildVersion, buildDate string, addr string, db *db.Db) *Server {
+func NewServer(buildVersion, buildDate string, addr string, db *db.Db, q *ingest.Queue, bus *event.Bus,
+	sc *cache.Stations, tc *cache.Tiles) *Server {
 	var router = mux.NewRouter()
 
 	var v1Api = router.PathPrefix("/v1").Subrouter()
@@ -38,30 +50,57 @@ func NewServer(buildVersion, buildDate string, addr string, db *db.Db) *Server {
 	v1Api.NotFoundHandler = http.HandlerFunc(v1.ErrorNotFoundHandler)
 	v1Api.MethodNotAllowedHandler = http.HandlerFunc(v1.ErrorMethodNotAllowedHandler)
 
-	v1Api.Handle("/feeder", v1.FeederHandler(db)).Methods("POST")
+	v1Api.Handle("/feeder", v1.FeederHandler(db, q, bus)).Methods("POST")
 
 	v1Api.Handle("/info", v1.InfoHandler(buildVersion, buildDate)).Methods("GET")
 
-	sgh := v1.StationsGetHandler(db)
-	v1Api.Handle("/stations", sgh).Methods("GET")
+	sgh := v1.StationsGetHandler(db, sc)
+	v1Api.Handle("/stations", sgh).Methods("GET", "POST")
+	v1Api.Handle("/stations/{id:[0-9]+}", v1.StationGetHandler(db)).Methods("GET")
+	v1Api.Handle("/stations/{id:[0-9]+}/stats", v1.StationStatsHandler(db)).Methods("GET")
+	v1Api.Handle("/stations/{id:[0-9]+}/profile", v1.StationProfileHandler(db)).Methods("GET")
+	v1Api.Handle("/stations/{id:[0-9]+}/outages", v1.StationOutagesHandler(db)).Methods("GET")
+
+	v1Api.Handle("/areas", v1.AreasGetHandler(db)).Methods("GET")
+	v1Api.Handle("/areas/{id:[0-9]+}/summary", v1.AreaSummaryHandler(db)).Methods("GET")
 
 	mgh := v1.MeasurementsGetHandler(db)
 	v1Api.Handle("/measurements", mgh).Methods("GET")
 
+	v1Api.Handle("/tiles/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}.mvt", v1.TilesGetHandler(db, tc)).Methods("GET")
+
+	v1Api.Handle("/heatmap", v1.HeatmapGridHandler(db, sc)).Methods("GET")
+	v1Api.Handle("/heatmap/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}.png", v1.HeatmapTileHandler(db, sc)).Methods("GET")
+
+	hub := stream.NewHub(streamReplaySize)
+	bus.Subscribe(hub.Publish)
+
+	v1Api.Handle("/stream", v1.StreamHandler(hub)).Methods("GET")
+	v1Api.Handle("/stream/ws", v1.StreamWebSocketHandler(hub)).Methods("GET")
+
 	originsOk := handlers.AllowedOrigins([]string{"*"})
-	headersOk := handlers.AllowedHeaders([]string{"X-Requested-With"})
+	headersOk := handlers.AllowedHeaders([]string{"X-Requested-With", "Last-Event-ID", "Content-Type"})
 	methodsOk := handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "OPTIONS"})
 
+	ctx, cancel := context.WithCancel(context.Background())
+
 	s := &Server{
 		http: &http.Server{
 			Addr:         addr,
 			WriteTimeout: 15 * time.Second,
 			ReadTimeout:  15 * time.Second,
 			IdleTimeout:  60 * time.Second,
-			Handler:      handlers.CORS(originsOk, headersOk, methodsOk)(router),
+			Handler:      handlers.CORS(originsOk, headersOk, methodsOk)(httputil.CompressHandler(router)),
+			BaseContext: func(net.Listener) context.Context {
+				return ctx
+			},
 		},
+		cancel: cancel,
 	}
 
+	// Finish active streams, so they don't block graceful shutdown
+	s.http.RegisterOnShutdown(hub.Close)
+
 	return s
 }
 
@@ -72,6 +111,13 @@ func (s *Server) Run() error {
 	return nil
 }
 
+// Shutdown gracefully shuts down the server waiting for active requests to complete until ctx is done.
+// Requests that are still active after that are canceled along with their database queries.
 func (s *Server) Shutdown(ctx context.Context) error {
-	return s.http.Shutdown(ctx)
+	err := s.http.Shutdown(ctx)
+	s.cancel()
+	if err != nil {
+		_ = s.http.Close()
+	}
+	return err
 }
@@ -0,0 +1,99 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Validator holds cache validators of response.
+type Validator struct {
+	ETag         string
+	LastModified time.Time
+}
+
+// NewValidator returns cache validator of response to request r consisting of n items
+// with the newest item timestamp lastModified.
+func NewValidator(r *http.Request, lastModified time.Time, n int) Validator {
+	h := fnv.New64a()
+	_, _ = fmt.Fprintf(h, "%s?%s|%d|%d|%s", r.URL.Path, r.URL.RawQuery, lastModified.UnixNano(), n,
+		r.Header.Get("Accept"))
+	return Validator{
+		ETag:         fmt.Sprintf(`W/"%x"`, h.Sum64()),
+		LastModified: lastModified,
+	}
+}
+
+// NewContentValidator returns cache validator of response with body b and the newest item timestamp
+// lastModified. Entity tag is derived from response body, so it changes along with response data
+// that has no timestamps.
+func NewContentValidator(b []byte, lastModified time.Time) Validator {
+	h := fnv.New64a()
+	_, _ = h.Write(b)
+	return Validator{
+		ETag:         fmt.Sprintf(`W/"%x"`, h.Sum64()),
+		LastModified: lastModified,
+	}
+}
+
+// CheckNotModified sets cache validator headers of response and checks request r conditional headers.
+// If resource is not modified, it writes 304 (Not Modified) response and returns true.
+func CheckNotModified(w http.ResponseWriter, r *http.Request, v Validator) bool {
+	w.Header().Set("ETag", v.ETag)
+	if !v.LastModified.IsZero() {
+		w.Header().Set("Last-Modified", v.LastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	// If-None-Match takes precedence over If-Modified-Since
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if !etagMatch(inm, v.ETag) {
+			return false
+		}
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" && !v.LastModified.IsZero() {
+		t, err := http.ParseTime(ims)
+		if err != nil || v.LastModified.Truncate(time.Second).After(t) {
+			return false
+		}
+	} else {
+		return false
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatch checks whether If-None-Match header value inm matches entity tag etag using weak comparison.
+func etagMatch(inm, etag string) bool {
+	for _, t := range strings.Split(inm, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// SetCacheControl sets response Cache-Control header allowing public caching for given duration.
+func SetCacheControl(w http.ResponseWriter, maxAge time.Duration) {
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+}
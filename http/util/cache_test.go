@@ -0,0 +1,119 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheckNotModified(t *testing.T) {
+	lm := time.Date(2019, 10, 1, 12, 30, 15, 500, time.UTC)
+	r := httptest.NewRequest("GET", "/v1/measurements?station=1", nil)
+	v := NewValidator(r, lm, 10)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    bool
+	}{
+		{name: "unconditional", want: false},
+		{name: "etag match", headers: map[string]string{"If-None-Match": v.ETag}, want: true},
+		{name: "etag list match", headers: map[string]string{"If-None-Match": `"x", ` + v.ETag}, want: true},
+		{name: "etag mismatch", headers: map[string]string{"If-None-Match": `W/"x"`}, want: false},
+		{name: "etag precedence", headers: map[string]string{"If-None-Match": `W/"x"`,
+			"If-Modified-Since": lm.Format(http.TimeFormat)}, want: false},
+		{name: "not modified since", headers: map[string]string{"If-Modified-Since": lm.Format(http.TimeFormat)},
+			want: true},
+		{name: "modified since", headers: map[string]string{
+			"If-Modified-Since": lm.Add(-time.Second).Format(http.TimeFormat)}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/v1/measurements?station=1", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			if got := CheckNotModified(w, r, v); got != tt.want {
+				t.Errorf("CheckNotModified() = %v, want %v", got, tt.want)
+			}
+			if tt.want && w.Code != http.StatusNotModified {
+				t.Errorf("CheckNotModified() status = %v, want %v", w.Code, http.StatusNotModified)
+			}
+			if w.Header().Get("ETag") != v.ETag {
+				t.Errorf("CheckNotModified() ETag = %v, want %v", w.Header().Get("ETag"), v.ETag)
+			}
+		})
+	}
+
+	if v2 := NewValidator(httptest.NewRequest("GET", "/v1/measurements?station=2", nil), lm, 10); v2.ETag == v.ETag {
+		t.Errorf("NewValidator() ETag is the same for different requests: %v", v.ETag)
+	}
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		ae   string
+		want bool
+	}{
+		{ae: "", want: false},
+		{ae: "gzip, deflate", want: false},
+		{ae: "gzip, deflate, br", want: true},
+		{ae: "br;q=1.0, gzip;q=0.8", want: true},
+		{ae: "br;q=0, gzip", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ae, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Accept-Encoding", tt.ae)
+			if got := acceptsEncoding(r, brotliEncoding); got != tt.want {
+				t.Errorf("acceptsEncoding() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewContentValidator(t *testing.T) {
+	lm := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+	v := NewContentValidator([]byte(`{"stations":[{"id":1,"description":"a"}]}`), lm)
+	if !v.LastModified.Equal(lm) {
+		t.Errorf("NewContentValidator() LastModified = %v, want %v", v.LastModified, lm)
+	}
+	if v2 := NewContentValidator([]byte(`{"stations":[{"id":1,"description":"b"}]}`), lm); v2.ETag == v.ETag {
+		t.Errorf("NewContentValidator() ETag is the same for different content: %v", v.ETag)
+	}
+	if v2 := NewContentValidator([]byte(`{"stations":[{"id":1,"description":"a"}]}`), lm); v2.ETag != v.ETag {
+		t.Errorf("NewContentValidator() ETag = %v for the same content, want %v", v2.ETag, v.ETag)
+	}
+
+	// Metadata changed along with the same newest measurement is detected by entity tag
+	r := httptest.NewRequest(http.MethodGet, "/v1/stations", nil)
+	r.Header.Set("If-None-Match", v.ETag)
+	r.Header.Set("If-Modified-Since", lm.Format(http.TimeFormat))
+	w := httptest.NewRecorder()
+	if CheckNotModified(w, r, NewContentValidator([]byte(`{"stations":[{"id":1,"description":"b"}]}`), lm)) {
+		t.Errorf("CheckNotModified() = true for changed content")
+	}
+	if w.Header().Get("Last-Modified") != lm.Format(http.TimeFormat) {
+		t.Errorf("Last-Modified = %q, want %q", w.Header().Get("Last-Modified"), lm.Format(http.TimeFormat))
+	}
+	w = httptest.NewRecorder()
+	if !CheckNotModified(w, r, v) || w.Code != http.StatusNotModified {
+		t.Errorf("CheckNotModified() = false for the same content, want 304")
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/felixge/httpsnoop"
+	"github.com/gorilla/handlers"
+)
+
+const brotliEncoding = "br"
+
+// CompressHandler compresses HTTP responses with brotli, gzip or deflate encoding
+// for clients that support it via the 'Accept-Encoding' header. Brotli encoding is preferred.
+// Responses without body, i.e. responses to HEAD requests and 204 or 304 responses, are not compressed.
+func CompressHandler(h http.Handler) http.Handler {
+	gh := handlers.CompressHandler(h)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if r.Header.Get("Upgrade") != "" || !acceptsEncoding(r, brotliEncoding) {
+			gh.ServeHTTP(w, r)
+			return
+		}
+
+		// Always add Accept-Encoding to Vary to prevent intermediate caches corruption
+		w.Header().Add("Vary", "Accept-Encoding")
+		w.Header().Set("Content-Encoding", brotliEncoding)
+		r.Header.Del("Accept-Encoding")
+
+		bw := brotli.NewWriterLevel(w, brotli.DefaultCompression)
+		cw := &compressResponseWriter{w: w, compressor: bw}
+		defer func() {
+			if !cw.bodyless {
+				_ = bw.Close()
+			}
+		}()
+
+		w = httpsnoop.Wrap(w, httpsnoop.Hooks{
+			Write: func(httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+				return cw.Write
+			},
+			WriteHeader: func(httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+				return cw.WriteHeader
+			},
+			Flush: func(httpsnoop.FlushFunc) httpsnoop.FlushFunc {
+				return cw.Flush
+			},
+			ReadFrom: func(httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+				return cw.ReadFrom
+			},
+		})
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// acceptsEncoding checks whether request r 'Accept-Encoding' header allows given encoding.
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, ae := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		ps := strings.Split(ae, ";")
+		if strings.TrimSpace(ps[0]) != encoding {
+			continue
+		}
+		for _, p := range ps[1:] {
+			if q := strings.TrimSpace(p); strings.HasPrefix(q, "q=") {
+				if v, err := strconv.ParseFloat(q[2:], 64); err == nil && v == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+type compressResponseWriter struct {
+	compressor *brotli.Writer
+	w          http.ResponseWriter
+	// bodyless is set if response status doesn't allow body, so response is not compressed
+	bodyless bool
+}
+
+func (cw *compressResponseWriter) WriteHeader(c int) {
+	if c == http.StatusNoContent || c == http.StatusNotModified {
+		cw.bodyless = true
+		cw.w.Header().Del("Content-Encoding")
+	} else {
+		cw.w.Header().Del("Content-Length")
+	}
+	cw.w.WriteHeader(c)
+}
+
+func (cw *compressResponseWriter) Write(b []byte) (int, error) {
+	if cw.bodyless {
+		return cw.w.Write(b)
+	}
+	h := cw.w.Header()
+	if h.Get("Content-Type") == "" {
+		h.Set("Content-Type", http.DetectContentType(b))
+	}
+	h.Del("Content-Length")
+
+	return cw.compressor.Write(b)
+}
+
+func (cw *compressResponseWriter) ReadFrom(r io.Reader) (int64, error) {
+	if cw.bodyless {
+		return io.Copy(cw.w, r)
+	}
+	return io.Copy(cw.compressor, r)
+}
+
+func (cw *compressResponseWriter) Flush() {
+	if !cw.bodyless {
+		_ = cw.compressor.Flush()
+	}
+	if f, ok := cw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
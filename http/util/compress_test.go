@@ -0,0 +1,76 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestCompressHandler(t *testing.T) {
+	const body = `{"status":0,"stations":[]}`
+	h := CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") != "" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(body))
+	}))
+
+	tests := []struct {
+		name     string
+		method   string
+		inm      string
+		status   int
+		encoding string
+	}{
+		{name: "get", method: http.MethodGet, status: http.StatusOK, encoding: brotliEncoding},
+		{name: "not modified", method: http.MethodGet, inm: `W/"1"`, status: http.StatusNotModified},
+		{name: "head", method: http.MethodHead, status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/v1/stations", nil)
+			r.Header.Set("Accept-Encoding", "gzip, br")
+			if tt.inm != "" {
+				r.Header.Set("If-None-Match", tt.inm)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if ce := w.Header().Get("Content-Encoding"); ce != tt.encoding {
+				t.Errorf("Content-Encoding = %q, want %q", ce, tt.encoding)
+			}
+			if tt.status == http.StatusNotModified && w.Body.Len() != 0 {
+				t.Errorf("body = %q, want empty", w.Body.String())
+			}
+			if tt.encoding == "" {
+				return
+			}
+			b, err := ioutil.ReadAll(brotli.NewReader(w.Body))
+			if err != nil || string(b) != body {
+				t.Errorf("decompressed body = %q, %v, want %q", b, err, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,450 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ingest
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/openairtech/apiserver/db"
+	"github.com/openairtech/apiserver/event"
+)
+
+var (
+	ErrQueueFull   = errors.New("ingestion queue is full")
+	ErrQueueClosed = errors.New("ingestion queue is closed")
+)
+
+const (
+	maxRetryDelay    = 30 * time.Second
+	spoolSegmentSize = 16 << 20
+	// cachedStationTimeout is the maximum time to look up station which is cached already
+	cachedStationTimeout = 2 * time.Second
+)
+
+var metrics = expvar.NewMap("ingest")
+
+// Config holds ingestion queue parameters.
+type Config struct {
+	// Size is the maximum number of feeds queued but not written to database yet.
+	Size int
+	// SpoolDir is the directory of on-disk spool queued feeds are written to before
+	// being acknowledged, so they are replayed on restart if not written to database.
+	// Queued feeds are kept in memory only if it is not set.
+	SpoolDir string
+	// Workers is the number of workers writing queued feeds to database.
+	Workers int
+	// BatchSize is the maximum number of feeds written to database in a single transaction.
+	BatchSize int
+	// FlushInterval is the maximum time to wait for a batch to fill up before writing it to database.
+	FlushInterval time.Duration
+	// EnqueueTimeout is the maximum time to wait for a free queue slot before rejecting a feed.
+	EnqueueTimeout time.Duration
+}
+
+// entry is a queued feed along with its spool segment identifier.
+type entry struct {
+	feed    db.Feed
+	segment uint64
+	spooled bool
+}
+
+// Queue is an asynchronous station feeds ingestion queue.
+// Feeds are persisted to on-disk spool, queued and written to database in batches by a pool of workers.
+type Queue struct {
+	db    *db.Db
+	cfg   Config
+	spool *spool
+	bus   *event.Bus
+
+	// slots limits the number of queued feeds not written to database yet
+	slots   chan struct{}
+	entries chan *entry
+
+	mu     sync.RWMutex
+	closed bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	stations *stationCache
+}
+
+// NewQueue creates ingestion queue writing feeds to database db according to config cfg.
+// Written feeds are published to event bus.
+func NewQueue(db *db.Db, cfg Config, bus *event.Bus) (*Queue, error) {
+	if cfg.Size <= 0 {
+		return nil, errors.New("ingestion queue size must be positive")
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	q := &Queue{
+		db:       db,
+		cfg:      cfg,
+		bus:      bus,
+		slots:    make(chan struct{}, cfg.Size),
+		entries:  make(chan *entry, cfg.Size),
+		ctx:      ctx,
+		cancel:   cancel,
+		stations: newStationCache(),
+	}
+
+	if cfg.SpoolDir != "" {
+		var err error
+		if q.spool, err = openSpool(cfg.SpoolDir, spoolSegmentSize, true); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+
+	metrics.Set("queue_length", expvar.Func(func() interface{} { return len(q.slots) }))
+	metrics.Set("queue_capacity", expvar.Func(func() interface{} { return cap(q.slots) }))
+	metrics.Set("spool_segments", expvar.Func(func() interface{} {
+		if q.spool == nil {
+			return 0
+		}
+		return q.spool.size()
+	}))
+
+	return q, nil
+}
+
+// Start starts queue workers and replays feeds left in spool since previous run.
+func (q *Queue) Start() {
+	for i := 0; i < q.cfg.Workers; i++ {
+		q.wg.Add(1)
+		go q.worker()
+	}
+
+	if q.spool != nil {
+		q.wg.Add(1)
+		go q.replay()
+	}
+}
+
+// replay queues feeds from spool waiting for free queue slots as long as needed.
+// Feeds not queued because queue is closed are left in spool.
+func (q *Queue) replay() {
+	defer q.wg.Done()
+
+	n := 0
+	err := q.spool.replay(func(id uint64, p []byte) error {
+		var f db.Feed
+		if err := json.Unmarshal(p, &f); err != nil {
+			log.Warnf("skipped invalid spool record: %v", err)
+			q.spool.ack(id)
+			return nil
+		}
+		select {
+		case q.slots <- struct{}{}:
+		case <-q.ctx.Done():
+			return ErrQueueClosed
+		}
+		if err := q.push(&entry{feed: f, segment: id, spooled: true}); err != nil {
+			<-q.slots
+			return err
+		}
+		n++
+		metrics.Add("replayed", 1)
+		return nil
+	})
+	if err != nil && err != ErrQueueClosed {
+		log.Errorf("can't replay ingestion spool: %v", err)
+	}
+	if n > 0 {
+		log.Infof("replayed %d feed(s) from ingestion spool", n)
+	}
+}
+
+// Enqueue adds feed f to the queue. It waits for a free queue slot no longer than
+// configured enqueue timeout and returns ErrQueueFull if there are still no free slots.
+// Once Enqueue returns without error, feed is persisted to spool, if it is configured.
+func (q *Queue) Enqueue(ctx context.Context, f db.Feed) error {
+	select {
+	case q.slots <- struct{}{}:
+	default:
+		t := time.NewTimer(q.cfg.EnqueueTimeout)
+		defer t.Stop()
+
+		select {
+		case q.slots <- struct{}{}:
+		case <-t.C:
+			metrics.Add("rejected", 1)
+			return ErrQueueFull
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	e := &entry{feed: f}
+
+	if q.spool != nil {
+		p, err := json.Marshal(f)
+		if err != nil {
+			<-q.slots
+			return err
+		}
+		if e.segment, err = q.spool.append(p); err != nil {
+			<-q.slots
+			return err
+		}
+		e.spooled = true
+	}
+
+	if err := q.push(e); err != nil {
+		q.done(e)
+		return err
+	}
+
+	metrics.Add("enqueued", 1)
+
+	return nil
+}
+
+// push sends entry e holding queue slot to workers.
+func (q *Queue) push(e *entry) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return ErrQueueClosed
+	}
+	// Never blocks since entries channel capacity equals to the number of slots
+	q.entries <- e
+	return nil
+}
+
+// done releases queue slot of entry e and acknowledges it in spool.
+func (q *Queue) done(e *entry) {
+	if e.spooled {
+		q.spool.ack(e.segment)
+	}
+	<-q.slots
+}
+
+// worker writes queued feeds to database in batches until queue is closed.
+func (q *Queue) worker() {
+	defer q.wg.Done()
+
+	for {
+		e, ok := <-q.entries
+		if !ok {
+			return
+		}
+
+		batch := []*entry{e}
+		t := time.NewTimer(q.cfg.FlushInterval)
+	collect:
+		for len(batch) < q.cfg.BatchSize {
+			select {
+			case e, ok := <-q.entries:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, e)
+			case <-t.C:
+				break collect
+			}
+		}
+		t.Stop()
+
+		q.write(batch)
+	}
+}
+
+// write writes batch of entries to database retrying on temporary errors.
+// Entries are left in spool if queue is closed before they are written.
+func (q *Queue) write(batch []*entry) {
+	delay := time.Second
+	for {
+		err := q.writeBatch(batch)
+		if err == nil {
+			return
+		}
+
+		if isPermanent(err) {
+			if len(batch) > 1 {
+				// Write entries one by one to drop invalid ones only
+				for _, e := range batch {
+					q.write([]*entry{e})
+				}
+				return
+			}
+			log.Errorf("station [%d]: dropped %d measurement(s): %v",
+				batch[0].feed.StationId, len(batch[0].feed.Measurements), err)
+			metrics.Add("dropped", 1)
+			q.done(batch[0])
+			return
+		}
+
+		metrics.Add("errors", 1)
+		log.Errorf("can't write %d feed(s), retrying in %v: %v", len(batch), delay, err)
+
+		select {
+		case <-time.After(delay):
+		case <-q.ctx.Done():
+			return
+		}
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+func (q *Queue) writeBatch(batch []*entry) error {
+	feeds := make([]db.Feed, len(batch))
+	nm := 0
+	for i, e := range batch {
+		feeds[i] = e.feed
+		nm += len(e.feed.Measurements)
+	}
+
+	ms, err := q.db.FeedStations(q.ctx, feeds)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range batch {
+		q.done(e)
+	}
+
+	for _, f := range event.Feeds(feeds, ms) {
+		q.bus.Publish(f)
+	}
+
+	n := len(ms)
+
+	metrics.Add("batches", 1)
+	metrics.Add("written", int64(len(batch)))
+	metrics.Add("measurements_inserted", int64(n))
+	metrics.Add("measurements_duplicate", int64(nm-n))
+
+	log.Debugf("written %d feed(s): added %d measurement(s), skipped %d duplicate(s)", len(batch), n, nm-n)
+
+	return nil
+}
+
+// isPermanent checks whether database error err can't be fixed by retrying, like constraint violation.
+func isPermanent(err error) bool {
+	var pe *pq.Error
+	if errors.As(err, &pe) {
+		switch pe.Code.Class() {
+		case "22", "23":
+			return true
+		}
+	}
+	return false
+}
+
+// Close stops accepting new feeds and waits for queued feeds to be written to database until ctx is done.
+// Feeds not written by that time are left in spool to be replayed on next start.
+func (q *Queue) Close(ctx context.Context) {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.entries)
+	}
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		q.cancel()
+		<-done
+	}
+
+	q.cancel()
+
+	if q.spool != nil {
+		q.spool.close()
+	}
+}
+
+// Station gets station by its token ID. Stations found are cached, so feeds of known stations
+// are accepted while database is unavailable. Lookup of cached station is limited by short timeout,
+// so feeds aren't held by unresponsive database longer than feeder requests can last.
+func (q *Queue) Station(ctx context.Context, tokenId string) (*db.Station, error) {
+	if q.stations.get(tokenId) != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cachedStationTimeout)
+		defer cancel()
+	}
+	s, err := q.db.StationByTokenId(ctx, tokenId)
+	if err == nil {
+		q.stations.put(tokenId, s)
+		return s, nil
+	}
+	if err == sql.ErrNoRows {
+		q.stations.remove(tokenId)
+		return nil, err
+	}
+	if cs := q.stations.get(tokenId); cs != nil {
+		log.Warnf("station [%d]: using cached station data: %v", cs.Id, err)
+		return cs, nil
+	}
+	return nil, err
+}
+
+// stationCache is a cache of stations by their token IDs.
+type stationCache struct {
+	mu sync.RWMutex
+	s  map[string]db.Station
+}
+
+func newStationCache() *stationCache {
+	return &stationCache{s: make(map[string]db.Station)}
+}
+
+func (c *stationCache) get(tokenId string) *db.Station {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if s, ok := c.s[tokenId]; ok {
+		return &s
+	}
+	return nil
+}
+
+func (c *stationCache) put(tokenId string, s *db.Station) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.s[tokenId] = *s
+}
+
+func (c *stationCache) remove(tokenId string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.s, tokenId)
+}
@@ -0,0 +1,82 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/openairtech/apiserver/db"
+	"github.com/openairtech/apiserver/event"
+)
+
+func TestQueue_ReplayInterrupted(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := openSpool(dir, spoolSegmentSize, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var id uint64
+	for i := 1; i <= 3; i++ {
+		p, _ := json.Marshal(db.Feed{StationId: i})
+		if id, err = s.append(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.close()
+
+	// Queue without workers takes the first replayed feed only and waits for a free slot for the second one
+	q, err := NewQueue(nil, Config{Size: 1, SpoolDir: dir}, event.NewBus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.wg.Add(1)
+	go q.replay()
+
+	for deadline := time.Now().Add(5 * time.Second); len(q.entries) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("replayed feed is not queued")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	q.Close(ctx)
+
+	if _, err := os.Stat(s.path(id)); err != nil {
+		t.Fatalf("spool segment is removed after interrupted replay: %v", err)
+	}
+
+	// All feeds are replayed on next start
+	s, err = openSpool(dir, spoolSegmentSize, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	if err := s.replay(func(uint64, []byte) error {
+		n++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("replayed %d feed(s) after restart, want 3", n)
+	}
+}
@@ -0,0 +1,311 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ingest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	spoolExt = ".spool"
+	// Record header consists of payload length and payload CRC32 checksum
+	recordHeaderSize = 8
+	maxRecordSize    = 16 << 20
+)
+
+// spool is an on-disk write-ahead log of queued records.
+// Records are appended to segment files, and a segment file is removed
+// once it is not written anymore and all its records are acknowledged.
+type spool struct {
+	dir         string
+	segmentSize int64
+	sync        bool
+
+	mu      sync.Mutex
+	nextId  uint64
+	active  *segment
+	pending map[uint64]int
+	// kept are segments which replay was interrupted, so their files are kept to be replayed on next start
+	kept map[uint64]bool
+}
+
+// segment is a spool segment file opened for writing.
+type segment struct {
+	id   uint64
+	f    *os.File
+	size int64
+}
+
+// openSpool opens spool in directory dir creating it if needed.
+// segmentSize is the segment file size to start next segment after,
+// and sync specifies whether to sync segment file to disk after every record written.
+func openSpool(dir string, segmentSize int64, sync bool) (*spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	s := &spool{
+		dir:         dir,
+		segmentSize: segmentSize,
+		sync:        sync,
+		pending:     make(map[uint64]int),
+		kept:        make(map[uint64]bool),
+	}
+
+	ids, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) > 0 {
+		s.nextId = ids[len(ids)-1] + 1
+	}
+
+	return s, nil
+}
+
+// segments returns sorted identifiers of segment files found in spool directory.
+func (s *spool) segments() ([]uint64, error) {
+	fis, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uint64
+	for _, fi := range fis {
+		n := fi.Name()
+		if fi.IsDir() || !strings.HasSuffix(n, spoolExt) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(n, spoolExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	return ids, nil
+}
+
+func (s *spool) path(id uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", id, spoolExt))
+}
+
+// replay reads records of all segment files existing at the moment of spool opening and passes them to f
+// along with identifier of segment they belong to. Every record f accepts by returning nil must be acknowledged
+// by ack call, record f returns error for is not acknowledged, and replay stops keeping segment files
+// of that record and all next records to be replayed on next start.
+// Reading of segment stops at first incomplete or corrupted record, which may be left after a crash.
+func (s *spool) replay(f func(id uint64, p []byte) error) error {
+	ids, err := s.segments()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		s.mu.Lock()
+		if id >= s.nextId {
+			s.mu.Unlock()
+			break
+		}
+		// Keep segment until its records are replayed
+		s.pending[id]++
+		s.mu.Unlock()
+
+		err := s.replaySegment(id, f)
+		if err != nil {
+			s.mu.Lock()
+			s.kept[id] = true
+			s.mu.Unlock()
+		}
+		s.ack(id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *spool) replaySegment(id uint64, f func(id uint64, p []byte) error) error {
+	sf, err := os.Open(s.path(id))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = sf.Close() }()
+
+	r := bufio.NewReader(sf)
+	for {
+		p, err := readRecord(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			log.Warnf("spool segment %d: %v, skipping rest of segment", id, err)
+			return nil
+		}
+
+		s.mu.Lock()
+		s.pending[id]++
+		s.mu.Unlock()
+
+		if err := f(id, p); err != nil {
+			// Record is not accepted, and segment is held by replay, so it can't be removed here
+			s.mu.Lock()
+			s.pending[id]--
+			s.mu.Unlock()
+			return err
+		}
+	}
+}
+
+// append writes record payload p to the active segment and returns identifier of that segment.
+func (s *spool) append(p []byte) (uint64, error) {
+	if len(p) > maxRecordSize {
+		return 0, fmt.Errorf("record size %d exceeds %d bytes", len(p), maxRecordSize)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active == nil {
+		f, err := os.OpenFile(s.path(s.nextId), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return 0, err
+		}
+		s.active = &segment{id: s.nextId, f: f}
+		s.nextId++
+	}
+
+	a := s.active
+
+	b := make([]byte, recordHeaderSize+len(p))
+	binary.BigEndian.PutUint32(b[0:4], uint32(len(p)))
+	binary.BigEndian.PutUint32(b[4:8], crc32.ChecksumIEEE(p))
+	copy(b[recordHeaderSize:], p)
+
+	if _, err := a.f.Write(b); err != nil {
+		// Don't append to possibly partially written segment anymore
+		s.closeActive()
+		return 0, err
+	}
+	if s.sync {
+		if err := a.f.Sync(); err != nil {
+			s.closeActive()
+			return 0, err
+		}
+	}
+
+	a.size += int64(len(b))
+	s.pending[a.id]++
+
+	if a.size >= s.segmentSize {
+		s.closeActive()
+	}
+
+	return a.id, nil
+}
+
+// closeActive closes active segment file, so next records will be written to the new one.
+func (s *spool) closeActive() {
+	a := s.active
+	s.active = nil
+	_ = a.f.Close()
+	if s.pending[a.id] == 0 {
+		s.remove(a.id)
+	}
+}
+
+// ack acknowledges record of segment id. Segment file is removed if it has no more records pending.
+func (s *spool) ack(id uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending[id]--
+	if s.pending[id] > 0 {
+		return
+	}
+
+	if s.active != nil && s.active.id == id {
+		s.closeActive()
+	} else {
+		s.remove(id)
+	}
+}
+
+func (s *spool) remove(id uint64) {
+	delete(s.pending, id)
+	if !s.kept[id] {
+		_ = os.Remove(s.path(id))
+	}
+}
+
+// size returns the number of segment files in spool.
+func (s *spool) size() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.pending)
+}
+
+func (s *spool) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active != nil {
+		a := s.active
+		s.active = nil
+		_ = a.f.Close()
+	}
+}
+
+var errCorruptedRecord = errors.New("corrupted record")
+
+// readRecord reads next record payload from r. It returns io.EOF if there are no more complete records.
+func readRecord(r io.Reader) ([]byte, error) {
+	h := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(r, h); err != nil {
+		// Incomplete header is a partial write
+		return nil, io.EOF
+	}
+
+	n := binary.BigEndian.Uint32(h[0:4])
+	if n > maxRecordSize {
+		return nil, errCorruptedRecord
+	}
+
+	p := make([]byte, n)
+	if _, err := io.ReadFull(r, p); err != nil {
+		return nil, io.EOF
+	}
+
+	if crc32.ChecksumIEEE(p) != binary.BigEndian.Uint32(h[4:8]) {
+		return nil, errCorruptedRecord
+	}
+
+	return p, nil
+}
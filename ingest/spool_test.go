@@ -0,0 +1,98 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ingest
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestSpool_Replay(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := openSpool(dir, 64, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []uint64
+	for i := 0; i < 10; i++ {
+		id, err := s.append([]byte(fmt.Sprintf("record %d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	// Acknowledge the first half of records
+	for _, id := range ids[:5] {
+		s.ack(id)
+	}
+	s.close()
+
+	// Append a partially written record to the last segment
+	f, err := os.OpenFile(s.path(ids[len(ids)-1]), os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.Write([]byte{0, 0, 0, 10, 1, 2})
+	_ = f.Close()
+
+	s, err = openSpool(dir, 64, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	var rids []uint64
+	if err := s.replay(func(id uint64, p []byte) error {
+		got = append(got, string(p))
+		rids = append(rids, id)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Segments with acknowledged records only are removed, so records are replayed starting from segment boundary
+	if len(got) < 5 || got[len(got)-1] != "record 9" {
+		t.Fatalf("replay() records = %v, want all unacknowledged records", got)
+	}
+	for i, r := range got[len(got)-5:] {
+		if want := fmt.Sprintf("record %d", i+5); r != want {
+			t.Errorf("replay() record = %v, want %v", r, want)
+		}
+	}
+
+	id, err := s.append([]byte("new record"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id <= ids[len(ids)-1] {
+		t.Errorf("append() segment = %d, want greater than %d", id, ids[len(ids)-1])
+	}
+
+	for _, id := range rids {
+		s.ack(id)
+	}
+	s.ack(id)
+
+	if n := s.size(); n != 0 {
+		t.Errorf("size() = %d after all records acknowledged, want 0", n)
+	}
+	if des, _ := os.ReadDir(dir); len(des) != 0 {
+		t.Errorf("spool directory has %d file(s) after all records acknowledged, want 0", len(des))
+	}
+}
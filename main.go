@@ -17,6 +17,8 @@ package main
 import (
 	"fmt"
 	"os"
+	// Embedded time zone database is used by timezone aware queries in images without it
+	_ "time/tzdata"
 
 	cmdpkg "github.com/openairtech/apiserver/cmd"
 )
@@ -0,0 +1,235 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package monitor implements background monitor of stations reporting, which classifies stations
+// as online, late or offline and records their status transitions.
+package monitor
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/openairtech/apiserver/db"
+)
+
+// Station statuses
+const (
+	StatusOnline  = "online"
+	StatusLate    = "late"
+	StatusOffline = "offline"
+	StatusUnknown = "unknown"
+)
+
+const (
+	// DefaultInterval is an expected reporting interval of stations without learned one
+	DefaultInterval = 5 * time.Minute
+	// minInterval and maxInterval limit learned reporting intervals
+	minInterval = time.Minute
+	maxInterval = time.Hour
+
+	// lateIntervals is the number of missed reporting intervals station is considered late after
+	lateIntervals = 3
+	// offlineIntervals is the number of missed reporting intervals station is considered offline after
+	offlineIntervals = 10
+)
+
+// Config is a stations monitor config.
+type Config struct {
+	// CheckInterval is an interval of stations status checks
+	CheckInterval time.Duration
+	// LearnInterval is an interval of stations reporting intervals update
+	LearnInterval time.Duration
+	// History is a period of measurements stations reporting intervals are learned from
+	History time.Duration
+}
+
+// Monitor periodically checks stations seen time and records their status transitions to database.
+type Monitor struct {
+	d   *db.Db
+	cfg Config
+
+	intervals map[int]time.Duration
+	learned   time.Time
+	statuses  map[int]db.StationStatus
+}
+
+func NewMonitor(d *db.Db, cfg Config) *Monitor {
+	return &Monitor{
+		d:         d,
+		cfg:       cfg,
+		intervals: make(map[int]time.Duration),
+	}
+}
+
+// ExpectedInterval returns expected reporting interval: learned interval limited to sane range,
+// or DefaultInterval if it is not learned.
+func ExpectedInterval(learned time.Duration) time.Duration {
+	switch {
+	case learned <= 0:
+		return DefaultInterval
+	case learned < minInterval:
+		return minInterval
+	case learned > maxInterval:
+		return maxInterval
+	}
+	return learned
+}
+
+// Classify returns status of station last seen at time seen with expected reporting interval
+// at time now, along with the time station has got this status since.
+func Classify(seen *time.Time, interval time.Duration, now time.Time) (string, time.Time) {
+	if seen == nil {
+		return StatusUnknown, now
+	}
+	late := seen.Add(lateIntervals * interval)
+	offline := seen.Add(offlineIntervals * interval)
+	switch {
+	case !now.Before(offline):
+		return StatusOffline, offline
+	case !now.Before(late):
+		return StatusLate, late
+	}
+	return StatusOnline, *seen
+}
+
+// Run creates status transitions table, if it doesn't exist, and checks stations status
+// with configured interval until ctx is done.
+func (m *Monitor) Run(ctx context.Context) {
+	if err := m.d.CreateStationStatusesTable(ctx); err != nil {
+		log.Errorf("can't create station statuses table, stations monitor is disabled: %v", err)
+		return
+	}
+
+	t := time.NewTicker(m.cfg.CheckInterval)
+	defer t.Stop()
+
+	for {
+		if err := m.check(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			log.Errorf("can't check stations status: %v", err)
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// check classifies stations at time now and records their status transitions.
+func (m *Monitor) check(ctx context.Context, now time.Time) error {
+	if m.statuses == nil {
+		ss, err := m.d.StationStatuses(ctx)
+		if err != nil {
+			return err
+		}
+		m.statuses = ss
+	}
+
+	if now.Sub(m.learned) >= m.cfg.LearnInterval {
+		is, err := m.d.StationReportingIntervals(ctx, now.Add(-m.cfg.History))
+		if err != nil {
+			return err
+		}
+		// Expected intervals are recorded, so stations status can be classified on request
+		// the same way as by monitor
+		eis := make(map[int]time.Duration, len(is))
+		for id, i := range is {
+			eis[id] = ExpectedInterval(i)
+		}
+		if err := m.d.SetStationIntervals(ctx, eis, now); err != nil {
+			return err
+		}
+		m.intervals, m.learned = is, now
+	}
+
+	dss, err := m.d.StationsById(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	var sss []db.StationStatus
+	for _, s := range dss {
+		if s.Seen == nil {
+			continue
+		}
+		interval := ExpectedInterval(m.intervals[s.Id])
+		status, since := Classify(s.Seen, interval, now)
+		if cs, ok := m.statuses[s.Id]; ok && cs.Status == status {
+			continue
+		}
+		sec := int(interval.Seconds())
+		sss = append(sss, db.StationStatus{
+			StationId:        s.Id,
+			Status:           status,
+			Timestamp:        since,
+			ExpectedInterval: &sec,
+		})
+	}
+
+	if len(sss) == 0 {
+		return nil
+	}
+
+	if err := m.d.AddStationStatuses(ctx, sss); err != nil {
+		return err
+	}
+	for _, s := range sss {
+		log.Debugf("station %d is %s since %s", s.StationId, s.Status, s.Timestamp.Format(time.RFC3339))
+		m.statuses[s.StationId] = s
+	}
+
+	return nil
+}
+
+// Outage is a period of station being offline, To is nil for ongoing outage.
+type Outage struct {
+	From time.Time
+	To   *time.Time
+}
+
+// Outages returns outages within time range [from, to) from station status transitions sss sorted by time.
+// Outage started before from is returned starting at from.
+func Outages(sss []db.StationStatus, from, to time.Time) []Outage {
+	var os []Outage
+	var cur *Outage
+	for _, s := range sss {
+		if !s.Timestamp.Before(to) {
+			break
+		}
+		if s.Status == StatusOffline {
+			if cur == nil {
+				t := s.Timestamp
+				if t.Before(from) {
+					t = from
+				}
+				cur = &Outage{From: t}
+			}
+			continue
+		}
+		if cur != nil {
+			t := s.Timestamp
+			if t.After(from) {
+				cur.To = &t
+				os = append(os, *cur)
+			}
+			cur = nil
+		}
+	}
+	if cur != nil {
+		os = append(os, *cur)
+	}
+	return os
+}
@@ -0,0 +1,95 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/openairtech/apiserver/db"
+)
+
+func TestClassify(t *testing.T) {
+	seen := time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		age    time.Duration
+		status string
+		since  time.Time
+	}{
+		{age: time.Minute, status: StatusOnline, since: seen},
+		{age: 3 * time.Minute, status: StatusLate, since: seen.Add(3 * time.Minute)},
+		{age: 9 * time.Minute, status: StatusLate, since: seen.Add(3 * time.Minute)},
+		{age: time.Hour, status: StatusOffline, since: seen.Add(10 * time.Minute)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.age.String(), func(t *testing.T) {
+			status, since := Classify(&seen, time.Minute, seen.Add(tt.age))
+			if status != tt.status || !since.Equal(tt.since) {
+				t.Errorf("Classify() = %s since %v, want %s since %v", status, since, tt.status, tt.since)
+			}
+		})
+	}
+
+	if status, _ := Classify(nil, time.Minute, seen); status != StatusUnknown {
+		t.Errorf("Classify() of never seen station = %s, want %s", status, StatusUnknown)
+	}
+}
+
+func TestExpectedInterval(t *testing.T) {
+	tests := []struct {
+		learned, want time.Duration
+	}{
+		{learned: 0, want: DefaultInterval},
+		{learned: 10 * time.Second, want: minInterval},
+		{learned: 2 * time.Minute, want: 2 * time.Minute},
+		{learned: 24 * time.Hour, want: maxInterval},
+	}
+	for _, tt := range tests {
+		if got := ExpectedInterval(tt.learned); got != tt.want {
+			t.Errorf("ExpectedInterval(%v) = %v, want %v", tt.learned, got, tt.want)
+		}
+	}
+}
+
+func TestOutages(t *testing.T) {
+	t0 := time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC)
+	at := func(h int) time.Time { return t0.Add(time.Duration(h) * time.Hour) }
+	sss := []db.StationStatus{
+		{Status: StatusOffline, Timestamp: at(0)},
+		{Status: StatusOnline, Timestamp: at(2)},
+		{Status: StatusLate, Timestamp: at(3)},
+		{Status: StatusOffline, Timestamp: at(4)},
+		{Status: StatusOnline, Timestamp: at(5)},
+		{Status: StatusOffline, Timestamp: at(8)},
+	}
+
+	os := Outages(sss, at(1), at(10))
+	if len(os) != 3 {
+		t.Fatalf("Outages() = %d outages, want 3", len(os))
+	}
+	if !os[0].From.Equal(at(1)) || !os[0].To.Equal(at(2)) {
+		t.Errorf("first outage = [%v, %v], want clamped to range start", os[0].From, *os[0].To)
+	}
+	if !os[1].From.Equal(at(4)) || !os[1].To.Equal(at(5)) {
+		t.Errorf("second outage = [%v, %v]", os[1].From, *os[1].To)
+	}
+	if !os[2].From.Equal(at(8)) || os[2].To != nil {
+		t.Errorf("last outage = %+v, want ongoing", os[2])
+	}
+
+	if os := Outages(sss, at(6), at(7)); len(os) != 0 {
+		t.Errorf("Outages() = %+v, want none", os)
+	}
+}
@@ -0,0 +1,89 @@
+#!/usr/bin/env python3
+# Checks that golden file measurements.parquet written by parquet.Writer (see TestWriter_Golden)
+# is read by independent Parquet implementations: pyarrow and DuckDB, whichever are installed.
+#
+# Usage: pip install pyarrow duckdb && python3 parquet/testdata/verify.py
+
+import os
+import sys
+from datetime import datetime, timezone
+
+GOLDEN = os.path.join(os.path.dirname(os.path.abspath(__file__)), "measurements.parquet")
+
+COLUMNS = ["timestamp", "pm25", "aqi", "station_description", "count", "longitude"]
+
+
+def ms(*args):
+    return int(datetime(*args, tzinfo=timezone.utc).timestamp() * 1000)
+
+
+# Same rows as goldenRows of parquet/writer_test.go, timestamps are in milliseconds since epoch
+ROWS = [
+    (ms(2019, 10, 1, 12, 30, 15, 500000), 12.5, 52, "Volgograd, Центр", 1, 44.5),
+    (ms(2019, 10, 1, 12, 35, 0), None, None, None, -2, 44.5),
+    (ms(2019, 10, 1, 12, 40, 0), 0.0, 0, "", 1 << 40, -0.125),
+    (ms(2019, 10, 1, 12, 45, 0), 250.75, None, "station", 0, 180.0),
+    (ms(1969, 12, 31, 23, 59, 59), None, 500, None, 7, -180.0),
+]
+
+
+def check(reader, rows):
+    rows = [tuple(r) for r in rows]
+    if rows != ROWS:
+        for got, want in zip(rows, ROWS):
+            if got != want:
+                print("%s: row %r, want %r" % (reader, got, want))
+        if len(rows) != len(ROWS):
+            print("%s: %d rows, want %d" % (reader, len(rows), len(ROWS)))
+        return False
+    print("%s: OK" % reader)
+    return True
+
+
+def check_pyarrow():
+    import pyarrow as pa
+    import pyarrow.parquet as pq
+
+    f = pq.ParquetFile(GOLDEN)
+    if f.metadata.num_row_groups != 3:
+        print("pyarrow: %d row groups, want 3" % f.metadata.num_row_groups)
+        return False
+    t = f.read()
+    if t.column_names != COLUMNS:
+        print("pyarrow: columns %r, want %r" % (t.column_names, COLUMNS))
+        return False
+    ts = t.schema.field("timestamp").type
+    if not pa.types.is_timestamp(ts) or ts.unit != "ms":
+        print("pyarrow: timestamp type %s, want timestamp[ms]" % ts)
+        return False
+    t = t.set_column(0, "timestamp", t.column("timestamp").cast(pa.int64()))
+    return check("pyarrow", zip(*[t.column(c).to_pylist() for c in COLUMNS]))
+
+
+def check_duckdb():
+    import duckdb
+
+    rows = duckdb.sql(
+        "SELECT epoch_ms(\"timestamp\"), pm25, aqi, station_description, \"count\", longitude FROM '%s'" % GOLDEN
+    ).fetchall()
+    return check("duckdb", rows)
+
+
+def main():
+    checked, ok = 0, True
+    for name, f in (("pyarrow", check_pyarrow), ("duckdb", check_duckdb)):
+        try:
+            __import__(name)
+        except ImportError:
+            print("%s: not installed, skipped" % name)
+            continue
+        checked += 1
+        ok = f() and ok
+    if checked == 0:
+        print("no Parquet readers installed")
+        return 1
+    return 0 if ok else 1
+
+
+if __name__ == "__main__":
+    sys.exit(main())
@@ -0,0 +1,121 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Thrift compact protocol field types
+const (
+	ctI32    = 5
+	ctI64    = 6
+	ctBinary = 8
+	ctList   = 9
+	ctStruct = 12
+)
+
+// compactWriter encodes Parquet metadata structures using Thrift compact protocol.
+type compactWriter struct {
+	b bytes.Buffer
+	// last is a stack of the last written field IDs of nested structs
+	last []int16
+}
+
+func newCompactWriter() *compactWriter {
+	return &compactWriter{last: []int16{0}}
+}
+
+func (w *compactWriter) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	w.b.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func (w *compactWriter) zigzag(v int64) {
+	w.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (w *compactWriter) field(id int16, typ byte) {
+	last := &w.last[len(w.last)-1]
+	if d := id - *last; d > 0 && d <= 15 {
+		w.b.WriteByte(byte(d)<<4 | typ)
+	} else {
+		w.b.WriteByte(typ)
+		w.zigzag(int64(id))
+	}
+	*last = id
+}
+
+func (w *compactWriter) i32(id int16, v int32) {
+	w.field(id, ctI32)
+	w.zigzag(int64(v))
+}
+
+func (w *compactWriter) i64(id int16, v int64) {
+	w.field(id, ctI64)
+	w.zigzag(v)
+}
+
+func (w *compactWriter) binary(id int16, v []byte) {
+	w.field(id, ctBinary)
+	w.varint(uint64(len(v)))
+	w.b.Write(v)
+}
+
+func (w *compactWriter) string(id int16, v string) {
+	w.binary(id, []byte(v))
+}
+
+// beginStruct starts struct field, struct must be finished with endStruct.
+func (w *compactWriter) beginStruct(id int16) {
+	w.field(id, ctStruct)
+	w.last = append(w.last, 0)
+}
+
+// endStruct finishes struct, including list element and top-level one.
+func (w *compactWriter) endStruct() {
+	w.b.WriteByte(0)
+	w.last = w.last[:len(w.last)-1]
+}
+
+// beginList starts list field of n elements of type typ.
+func (w *compactWriter) beginList(id int16, typ byte, n int) {
+	w.field(id, ctList)
+	if n < 15 {
+		w.b.WriteByte(byte(n)<<4 | typ)
+	} else {
+		w.b.WriteByte(0xf0 | typ)
+		w.varint(uint64(n))
+	}
+}
+
+// beginElement starts struct element of list, element must be finished with endStruct.
+func (w *compactWriter) beginElement() {
+	w.last = append(w.last, 0)
+}
+
+func (w *compactWriter) i32Element(v int32) {
+	w.zigzag(int64(v))
+}
+
+func (w *compactWriter) stringElement(v string) {
+	w.varint(uint64(len(v)))
+	w.b.WriteString(v)
+}
+
+func (w *compactWriter) bytes() []byte {
+	return w.b.Bytes()
+}
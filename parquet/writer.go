@@ -0,0 +1,472 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package parquet implements writer of Apache Parquet files of flat schema. Values are PLAIN encoded
+// and pages are GZIP compressed, which is supported by all Parquet readers.
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// Type is a column value type.
+type Type int
+
+const (
+	// Int32 column values are of int32 or int type
+	Int32 Type = iota
+	// Int64 column values are of int64 type
+	Int64
+	// Double column values are of float64 type
+	Double
+	// String column values are of string type
+	String
+	// Timestamp column values are of time.Time type, stored with millisecond precision
+	Timestamp
+)
+
+const (
+	magic = "PAR1"
+
+	createdBy = "openair-apiserver"
+
+	// defaultRowGroupSize is the maximum number of rows in row group
+	defaultRowGroupSize = 1 << 16
+)
+
+// Parquet format enumeration values
+const (
+	typeInt32     = 1
+	typeInt64     = 2
+	typeDouble    = 5
+	typeByteArray = 6
+
+	convertedUtf8            = 0
+	convertedTimestampMillis = 9
+
+	repetitionRequired = 0
+	repetitionOptional = 1
+
+	encodingPlain = 0
+	encodingRle   = 3
+
+	codecGzip = 2
+
+	pageData = 0
+)
+
+// Column is a column of flat schema.
+type Column struct {
+	Name string
+	Type Type
+	// Optional column accepts nil values
+	Optional bool
+}
+
+func (c Column) physicalType() int32 {
+	switch c.Type {
+	case Int32:
+		return typeInt32
+	case Int64, Timestamp:
+		return typeInt64
+	case Double:
+		return typeDouble
+	default:
+		return typeByteArray
+	}
+}
+
+// columnBuffer is a buffer of column chunk values of current row group.
+type columnBuffer struct {
+	// defined are flags of non-nil values
+	defined []bool
+	// data are PLAIN encoded non-nil values
+	data  bytes.Buffer
+	nulls int64
+	// min and max are PLAIN encoded minimum and maximum values of numeric columns
+	min, max    []byte
+	minV, maxV  float64
+	initialized bool
+}
+
+// columnChunk is a written column chunk.
+type columnChunk struct {
+	offset             int64
+	values             int64
+	uncompressedSize   int64
+	compressedSize     int64
+	nulls              int64
+	min, max           []byte
+	hasStatisticsRange bool
+}
+
+type rowGroup struct {
+	columns []columnChunk
+	rows    int64
+}
+
+// Writer writes rows to Parquet file. Rows are buffered in memory until row group is complete.
+type Writer struct {
+	w            io.Writer
+	columns      []Column
+	rowGroupSize int
+
+	offset    int64
+	buffers   []*columnBuffer
+	rows      int
+	rowGroups []rowGroup
+	err       error
+}
+
+// NewWriter creates Parquet file writer of rows of given columns to w.
+func NewWriter(w io.Writer, columns []Column) (*Writer, error) {
+	if len(columns) == 0 {
+		return nil, errors.New("no columns")
+	}
+	pw := &Writer{
+		w:            w,
+		columns:      columns,
+		rowGroupSize: defaultRowGroupSize,
+	}
+	pw.resetBuffers()
+	if err := pw.write([]byte(magic)); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+func (pw *Writer) resetBuffers() {
+	pw.buffers = make([]*columnBuffer, len(pw.columns))
+	for i := range pw.buffers {
+		pw.buffers[i] = &columnBuffer{}
+	}
+	pw.rows = 0
+}
+
+func (pw *Writer) write(b []byte) error {
+	if pw.err != nil {
+		return pw.err
+	}
+	n, err := pw.w.Write(b)
+	pw.offset += int64(n)
+	pw.err = err
+	return err
+}
+
+// Write writes row of column values, nil values are allowed for optional columns only.
+func (pw *Writer) Write(row ...interface{}) error {
+	if pw.err != nil {
+		return pw.err
+	}
+	if len(row) != len(pw.columns) {
+		return fmt.Errorf("row has %d values, expected %d", len(row), len(pw.columns))
+	}
+
+	// Check all row values before buffering them
+	for i, v := range row {
+		c := pw.columns[i]
+		if v == nil {
+			if !c.Optional {
+				return fmt.Errorf("column %s value is not set", c.Name)
+			}
+			continue
+		}
+		if !validValue(c.Type, v) {
+			return fmt.Errorf("column %s value %v has invalid type %T", c.Name, v, v)
+		}
+	}
+
+	for i, v := range row {
+		pw.buffers[i].add(pw.columns[i].Type, v)
+	}
+
+	pw.rows++
+	if pw.rows >= pw.rowGroupSize {
+		return pw.flushRowGroup()
+	}
+
+	return nil
+}
+
+func validValue(t Type, v interface{}) bool {
+	switch v.(type) {
+	case int32, int:
+		return t == Int32
+	case int64:
+		return t == Int64
+	case float64:
+		return t == Double
+	case string:
+		return t == String
+	case time.Time:
+		return t == Timestamp
+	}
+	return false
+}
+
+func (cb *columnBuffer) add(t Type, v interface{}) {
+	if v == nil {
+		cb.defined = append(cb.defined, false)
+		cb.nulls++
+		return
+	}
+	cb.defined = append(cb.defined, true)
+
+	var b [8]byte
+	var e []byte
+	var f float64
+	switch v := v.(type) {
+	case int:
+		binary.LittleEndian.PutUint32(b[:], uint32(int32(v)))
+		e, f = b[:4], float64(int32(v))
+	case int32:
+		binary.LittleEndian.PutUint32(b[:], uint32(v))
+		e, f = b[:4], float64(v)
+	case int64:
+		binary.LittleEndian.PutUint64(b[:], uint64(v))
+		e, f = b[:], float64(v)
+	case float64:
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+		e, f = b[:], v
+	case time.Time:
+		ms := v.UnixNano() / int64(time.Millisecond)
+		binary.LittleEndian.PutUint64(b[:], uint64(ms))
+		e, f = b[:], float64(ms)
+	case string:
+		binary.LittleEndian.PutUint32(b[:], uint32(len(v)))
+		cb.data.Write(b[:4])
+		cb.data.WriteString(v)
+		return
+	}
+	cb.data.Write(e)
+
+	if t == Double && math.IsNaN(f) {
+		return
+	}
+	if !cb.initialized || f < cb.minV {
+		cb.minV, cb.min = f, append([]byte(nil), e...)
+	}
+	if !cb.initialized || f > cb.maxV {
+		cb.maxV, cb.max = f, append([]byte(nil), e...)
+	}
+	cb.initialized = true
+}
+
+// flushRowGroup writes buffered rows as a row group.
+func (pw *Writer) flushRowGroup() error {
+	rg := rowGroup{rows: int64(pw.rows)}
+
+	for i, cb := range pw.buffers {
+		c := pw.columns[i]
+
+		var page bytes.Buffer
+		if c.Optional {
+			page.Write(encodeDefinitionLevels(cb.defined))
+		}
+		page.Write(cb.data.Bytes())
+
+		var compressed bytes.Buffer
+		zw := gzip.NewWriter(&compressed)
+		if _, err := zw.Write(page.Bytes()); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+
+		h := newCompactWriter()
+		h.i32(1, pageData)
+		h.i32(2, int32(page.Len()))
+		h.i32(3, int32(compressed.Len()))
+		h.beginStruct(5)
+		h.i32(1, int32(len(cb.defined)))
+		h.i32(2, encodingPlain)
+		h.i32(3, encodingRle)
+		h.i32(4, encodingRle)
+		h.endStruct()
+		h.endStruct()
+
+		cc := columnChunk{
+			offset:             pw.offset,
+			values:             int64(len(cb.defined)),
+			uncompressedSize:   int64(len(h.bytes()) + page.Len()),
+			compressedSize:     int64(len(h.bytes()) + compressed.Len()),
+			nulls:              cb.nulls,
+			min:                cb.min,
+			max:                cb.max,
+			hasStatisticsRange: cb.initialized,
+		}
+
+		if err := pw.write(h.bytes()); err != nil {
+			return err
+		}
+		if err := pw.write(compressed.Bytes()); err != nil {
+			return err
+		}
+
+		rg.columns = append(rg.columns, cc)
+	}
+
+	pw.rowGroups = append(pw.rowGroups, rg)
+	pw.resetBuffers()
+
+	return nil
+}
+
+// encodeDefinitionLevels encodes definition levels of optional column values using bit-packed
+// RLE/bit-packing hybrid encoding with bit width 1 prefixed by encoded data length.
+func encodeDefinitionLevels(defined []bool) []byte {
+	groups := (len(defined) + 7) / 8
+
+	var b [binary.MaxVarintLen64]byte
+	h := b[:binary.PutUvarint(b[:], uint64(groups<<1|1))]
+
+	e := make([]byte, 4, 4+len(h)+groups)
+	binary.LittleEndian.PutUint32(e, uint32(len(h)+groups))
+	e = append(e, h...)
+
+	bits := make([]byte, groups)
+	for i, d := range defined {
+		if d {
+			bits[i/8] |= 1 << uint(i%8)
+		}
+	}
+
+	return append(e, bits...)
+}
+
+// Close writes buffered rows and file metadata. It doesn't close underlying writer.
+func (pw *Writer) Close() error {
+	if pw.rows > 0 {
+		if err := pw.flushRowGroup(); err != nil {
+			return err
+		}
+	}
+
+	m := pw.fileMetaData()
+	if err := pw.write(m); err != nil {
+		return err
+	}
+
+	var l [4]byte
+	binary.LittleEndian.PutUint32(l[:], uint32(len(m)))
+	if err := pw.write(l[:]); err != nil {
+		return err
+	}
+
+	return pw.write([]byte(magic))
+}
+
+// NumRows returns the number of rows written.
+func (pw *Writer) NumRows() int64 {
+	n := int64(pw.rows)
+	for _, rg := range pw.rowGroups {
+		n += rg.rows
+	}
+	return n
+}
+
+// fileMetaData encodes file metadata.
+func (pw *Writer) fileMetaData() []byte {
+	var rows int64
+	for _, rg := range pw.rowGroups {
+		rows += rg.rows
+	}
+
+	m := newCompactWriter()
+	m.i32(1, 1)
+
+	m.beginList(2, ctStruct, len(pw.columns)+1)
+	m.beginElement()
+	m.string(4, "schema")
+	m.i32(5, int32(len(pw.columns)))
+	m.endStruct()
+	for _, c := range pw.columns {
+		m.beginElement()
+		m.i32(1, c.physicalType())
+		if c.Optional {
+			m.i32(3, repetitionOptional)
+		} else {
+			m.i32(3, repetitionRequired)
+		}
+		m.string(4, c.Name)
+		switch c.Type {
+		case String:
+			m.i32(6, convertedUtf8)
+		case Timestamp:
+			m.i32(6, convertedTimestampMillis)
+		}
+		m.endStruct()
+	}
+
+	m.i64(3, rows)
+
+	m.beginList(4, ctStruct, len(pw.rowGroups))
+	for _, rg := range pw.rowGroups {
+		m.beginElement()
+		m.beginList(1, ctStruct, len(rg.columns))
+		var size int64
+		for i, cc := range rg.columns {
+			size += cc.uncompressedSize
+			m.beginElement()
+			m.i64(2, cc.offset)
+			m.beginStruct(3)
+			m.i32(1, pw.columns[i].physicalType())
+			m.beginList(2, ctI32, 2)
+			m.i32Element(encodingPlain)
+			m.i32Element(encodingRle)
+			m.beginList(3, ctBinary, 1)
+			m.stringElement(pw.columns[i].Name)
+			m.i32(4, codecGzip)
+			m.i64(5, cc.values)
+			m.i64(6, cc.uncompressedSize)
+			m.i64(7, cc.compressedSize)
+			m.i64(9, cc.offset)
+			m.beginStruct(12)
+			m.i64(3, cc.nulls)
+			if cc.hasStatisticsRange {
+				m.binary(5, cc.max)
+				m.binary(6, cc.min)
+			}
+			m.endStruct()
+			m.endStruct()
+			m.endStruct()
+		}
+		m.i64(2, size)
+		m.i64(3, rg.rows)
+		m.endStruct()
+	}
+
+	m.string(6, createdBy)
+
+	// Column orders are required to use min and max statistics values
+	m.beginList(7, ctStruct, len(pw.columns))
+	for range pw.columns {
+		m.beginElement()
+		m.beginStruct(1)
+		m.endStruct()
+		m.endStruct()
+	}
+
+	m.endStruct()
+
+	return m.bytes()
+}
@@ -0,0 +1,255 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"flag"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// compactReader decodes Thrift compact protocol structs into maps of field values by field IDs.
+type compactReader struct {
+	b *bytes.Reader
+	t *testing.T
+}
+
+func (r *compactReader) varint() uint64 {
+	v, err := binary.ReadUvarint(r.b)
+	if err != nil {
+		r.t.Fatalf("can't read varint: %v", err)
+	}
+	return v
+}
+
+func (r *compactReader) zigzag() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *compactReader) value(typ byte) interface{} {
+	switch typ {
+	case ctI32, ctI64:
+		return r.zigzag()
+	case ctBinary:
+		b := make([]byte, r.varint())
+		if _, err := io.ReadFull(r.b, b); err != nil {
+			r.t.Fatalf("can't read binary: %v", err)
+		}
+		return b
+	case ctList:
+		h, _ := r.b.ReadByte()
+		n := int(h >> 4)
+		if n == 15 {
+			n = int(r.varint())
+		}
+		l := make([]interface{}, n)
+		for i := range l {
+			l[i] = r.value(h & 0x0f)
+		}
+		return l
+	case ctStruct:
+		return r.structure()
+	}
+	r.t.Fatalf("unexpected type: %d", typ)
+	return nil
+}
+
+func (r *compactReader) structure() map[int16]interface{} {
+	s := make(map[int16]interface{})
+	var id int16
+	for {
+		h, err := r.b.ReadByte()
+		if err != nil {
+			r.t.Fatalf("can't read field header: %v", err)
+		}
+		if h == 0 {
+			return s
+		}
+		if d := int16(h >> 4); d != 0 {
+			id += d
+		} else {
+			id = int16(r.zigzag())
+		}
+		s[id] = r.value(h & 0x0f)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	ts := time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	var b bytes.Buffer
+	pw, err := NewWriter(&b, []Column{
+		{Name: "timestamp", Type: Timestamp},
+		{Name: "pm25", Type: Double, Optional: true},
+		{Name: "aqi", Type: Int32, Optional: true},
+		{Name: "description", Type: String},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pw.rowGroupSize = 2
+
+	rows := [][]interface{}{
+		{ts, 10.5, 42, "a"},
+		{ts.Add(time.Minute), nil, nil, "a"},
+		{ts.Add(2 * time.Minute), 3.25, int32(13), "a"},
+	}
+	for _, row := range rows {
+		if err := pw.Write(row...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pw.Write(nil, 1.0, 1, "a"); err == nil {
+		t.Error("nil value of required column is written")
+	}
+	if err := pw.Write(ts, "1", 1, "a"); err == nil {
+		t.Error("value of invalid type is written")
+	}
+	if err := pw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f := b.Bytes()
+	if string(f[:4]) != magic || string(f[len(f)-4:]) != magic {
+		t.Fatal("no magic bytes")
+	}
+	l := int(binary.LittleEndian.Uint32(f[len(f)-8:]))
+	r := &compactReader{b: bytes.NewReader(f[len(f)-8-l : len(f)-8]), t: t}
+	m := r.structure()
+	if r.b.Len() != 0 {
+		t.Errorf("%d bytes left after file metadata", r.b.Len())
+	}
+
+	if m[3] != int64(3) {
+		t.Errorf("num_rows = %v, want 3", m[3])
+	}
+	schema := m[2].([]interface{})
+	if len(schema) != 5 || string(schema[4].(map[int16]interface{})[4].([]byte)) != "description" {
+		t.Errorf("unexpected schema: %v", schema)
+	}
+	rgs := m[4].([]interface{})
+	if len(rgs) != 2 {
+		t.Fatalf("got %d row groups, want 2", len(rgs))
+	}
+
+	// Read pm25 column chunk of the first row group
+	cc := rgs[0].(map[int16]interface{})[1].([]interface{})[1].(map[int16]interface{})
+	cm := cc[3].(map[int16]interface{})
+	if cm[5] != int64(2) {
+		t.Errorf("num_values = %v, want 2", cm[5])
+	}
+	st := cm[12].(map[int16]interface{})
+	if st[3] != int64(1) {
+		t.Errorf("null_count = %v, want 1", st[3])
+	}
+	if v := math.Float64frombits(binary.LittleEndian.Uint64(st[5].([]byte))); v != 10.5 {
+		t.Errorf("max_value = %v, want 10.5", v)
+	}
+
+	off := cc[2].(int64)
+	pr := &compactReader{b: bytes.NewReader(f[off:]), t: t}
+	ph := pr.structure()
+	hl := int64(len(f[off:]) - pr.b.Len())
+	if cm[7] != hl+ph[3].(int64) {
+		t.Errorf("total_compressed_size = %v, want %d", cm[7], hl+ph[3].(int64))
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(f[off+hl : off+hl+ph[3].(int64)]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Definition levels of 2 values: length 2, bit-packed header (1 group), bits 0b01, then single value
+	want := []byte{2, 0, 0, 0, 3, 1}
+	want = binary.LittleEndian.AppendUint64(want, math.Float64bits(10.5))
+	if !reflect.DeepEqual(page, want) {
+		t.Errorf("page = %v, want %v", page, want)
+	}
+}
+
+func TestEncodeDefinitionLevels(t *testing.T) {
+	d := make([]bool, 9)
+	d[0], d[3], d[8] = true, true, true
+	want := []byte{3, 0, 0, 0, 5, 0x09, 0x01}
+	if got := encodeDefinitionLevels(d); !reflect.DeepEqual(got, want) {
+		t.Errorf("encodeDefinitionLevels() = %v, want %v", got, want)
+	}
+}
+
+// goldenColumns and goldenRows are written to golden file testdata/measurements.parquet,
+// which is checked to be read by Parquet implementations with testdata/verify.py.
+var (
+	goldenColumns = []Column{
+		{Name: "timestamp", Type: Timestamp},
+		{Name: "pm25", Type: Double, Optional: true},
+		{Name: "aqi", Type: Int32, Optional: true},
+		{Name: "station_description", Type: String, Optional: true},
+		{Name: "count", Type: Int64},
+		{Name: "longitude", Type: Double},
+	}
+	goldenRows = [][]interface{}{
+		{time.Date(2019, 10, 1, 12, 30, 15, 500e6, time.UTC), 12.5, int32(52), "Volgograd, Центр", int64(1), 44.5},
+		{time.Date(2019, 10, 1, 12, 35, 0, 0, time.UTC), nil, nil, nil, int64(-2), 44.5},
+		{time.Date(2019, 10, 1, 12, 40, 0, 0, time.UTC), 0.0, int32(0), "", int64(1) << 40, -0.125},
+		{time.Date(2019, 10, 1, 12, 45, 0, 0, time.UTC), 250.75, nil, "station", int64(0), 180.0},
+		{time.Date(1969, 12, 31, 23, 59, 59, 0, time.UTC), nil, int32(500), nil, int64(7), -180.0},
+	}
+)
+
+func TestWriter_Golden(t *testing.T) {
+	var b bytes.Buffer
+	pw, err := NewWriter(&b, goldenColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Several row groups, the last one is incomplete
+	pw.rowGroupSize = 2
+	for _, row := range goldenRows {
+		if err := pw.Write(row...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join("testdata", "measurements.parquet")
+	if *update {
+		if err := os.WriteFile(path, b.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b.Bytes(), want) {
+		t.Errorf("written file differs from golden file %s verified by Parquet readers", path)
+	}
+}
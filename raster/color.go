@@ -0,0 +1,87 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raster
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+
+	"github.com/openairtech/apiserver/aqi"
+)
+
+var (
+	// aqiColors are the colors of AQI categories
+	aqiColors = []color.NRGBA{
+		{R: 0x00, G: 0xe4, B: 0x00, A: 0xff}, // Good
+		{R: 0xff, G: 0xff, B: 0x00, A: 0xff}, // Moderate
+		{R: 0xff, G: 0x7e, B: 0x00, A: 0xff}, // Unhealthy for sensitive groups
+		{R: 0xff, G: 0x00, B: 0x00, A: 0xff}, // Unhealthy
+		{R: 0x8f, G: 0x3f, B: 0x97, A: 0xff}, // Very unhealthy
+		{R: 0x7e, G: 0x00, B: 0x23, A: 0xff}, // Hazardous
+	}
+	// aqiCategoryMid are AQI values at the middle of AQI categories used as gradient stops
+	aqiCategoryMid = []float64{25, 75, 125, 175, 250, 350}
+)
+
+// AqiColor returns color of AQI value a. If smooth is true, color is interpolated between colors
+// of adjacent AQI categories, otherwise color of AQI category is returned. Negative AQI values
+// are treated as 0.
+func AqiColor(a float64, smooth bool) color.NRGBA {
+	a = math.Max(a, 0)
+	if !smooth {
+		// Both ranges of hazardous category have the same color
+		c := aqi.Category(int(math.Round(a)))
+		if c >= len(aqiColors) {
+			c = len(aqiColors) - 1
+		}
+		return aqiColors[c]
+	}
+	if a <= aqiCategoryMid[0] {
+		return aqiColors[0]
+	}
+	for i := 1; i < len(aqiCategoryMid); i++ {
+		if a <= aqiCategoryMid[i] {
+			t := (a - aqiCategoryMid[i-1]) / (aqiCategoryMid[i] - aqiCategoryMid[i-1])
+			return blend(aqiColors[i-1], aqiColors[i], t)
+		}
+	}
+	return aqiColors[len(aqiColors)-1]
+}
+
+func blend(c1, c2 color.NRGBA, t float64) color.NRGBA {
+	mix := func(a, b uint8) uint8 {
+		return uint8(math.Round(float64(a) + (float64(b)-float64(a))*t))
+	}
+	return color.NRGBA{R: mix(c1.R, c2.R), G: mix(c1.G, c2.G), B: mix(c1.B, c2.B), A: mix(c1.A, c2.A)}
+}
+
+// WritePng writes grid g as PNG image colored by AQI with transparent missing values.
+// toAqi converts grid values to AQI.
+func WritePng(w io.Writer, g Grid, toAqi func(v float64) float64, smooth bool) error {
+	img := image.NewNRGBA(image.Rect(0, 0, g.Width, g.Height))
+	for r := 0; r < g.Height; r++ {
+		for c := 0; c < g.Width; c++ {
+			v := g.Values[r*g.Width+c]
+			if math.IsNaN(v) {
+				continue
+			}
+			img.SetNRGBA(c, r, AqiColor(toAqi(v), smooth))
+		}
+	}
+	return png.Encode(w, img)
+}
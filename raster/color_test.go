@@ -0,0 +1,44 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raster
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestAqiColor(t *testing.T) {
+	good, moderate, hazardous := aqiColors[0], aqiColors[1], aqiColors[len(aqiColors)-1]
+	tests := []struct {
+		a      float64
+		smooth bool
+		want   color.NRGBA
+	}{
+		{a: -50, smooth: false, want: good},
+		{a: -50, smooth: true, want: good},
+		{a: 0, smooth: false, want: good},
+		{a: 75, smooth: false, want: moderate},
+		{a: 75, smooth: true, want: moderate},
+		{a: 350, smooth: false, want: hazardous},
+		{a: 450, smooth: false, want: hazardous},
+		{a: 450, smooth: true, want: hazardous},
+		{a: 1000, smooth: false, want: hazardous},
+	}
+	for _, tt := range tests {
+		if got := AqiColor(tt.a, tt.smooth); got != tt.want {
+			t.Errorf("AqiColor(%v, %v) = %v, want %v", tt.a, tt.smooth, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raster
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"sort"
+)
+
+// GeoTiffNoData is the GeoTIFF value of missing grid values
+const GeoTiffNoData = -9999
+
+// TIFF field types
+const (
+	tiffShort  = 3
+	tiffLong   = 4
+	tiffAscii  = 2
+	tiffDouble = 12
+)
+
+type tiffField struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	// data is field value encoded in little-endian byte order
+	data []byte
+}
+
+func shortsField(tag uint16, vs ...uint16) tiffField {
+	b := make([]byte, 2*len(vs))
+	for i, v := range vs {
+		binary.LittleEndian.PutUint16(b[2*i:], v)
+	}
+	return tiffField{tag: tag, typ: tiffShort, count: uint32(len(vs)), data: b}
+}
+
+func longField(tag uint16, v uint32) tiffField {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return tiffField{tag: tag, typ: tiffLong, count: 1, data: b}
+}
+
+func doublesField(tag uint16, vs ...float64) tiffField {
+	b := make([]byte, 8*len(vs))
+	for i, v := range vs {
+		binary.LittleEndian.PutUint64(b[8*i:], math.Float64bits(v))
+	}
+	return tiffField{tag: tag, typ: tiffDouble, count: uint32(len(vs)), data: b}
+}
+
+func asciiField(tag uint16, s string) tiffField {
+	return tiffField{tag: tag, typ: tiffAscii, count: uint32(len(s) + 1), data: append([]byte(s), 0)}
+}
+
+// WriteGeoTiff writes grid g as single band 32-bit float GeoTIFF image in WGS 84 (EPSG:4326) coordinates.
+// Missing values are written as GeoTiffNoData.
+func WriteGeoTiff(w io.Writer, g Grid) error {
+	const headerSize = 8
+
+	pixels := new(bytes.Buffer)
+	pixels.Grow(4 * len(g.Values))
+	for _, v := range g.Values {
+		if math.IsNaN(v) {
+			v = GeoTiffNoData
+		}
+		_ = binary.Write(pixels, binary.LittleEndian, float32(v))
+	}
+
+	fields := []tiffField{
+		longField(256, uint32(g.Width)),      // ImageWidth
+		longField(257, uint32(g.Height)),     // ImageLength
+		shortsField(258, 32),                 // BitsPerSample
+		shortsField(259, 1),                  // Compression: none
+		shortsField(262, 1),                  // PhotometricInterpretation: black is zero
+		longField(273, 0),                    // StripOffsets, set below
+		shortsField(277, 1),                  // SamplesPerPixel
+		longField(278, uint32(g.Height)),     // RowsPerStrip
+		longField(279, uint32(pixels.Len())), // StripByteCounts
+		shortsField(284, 1),                  // PlanarConfiguration: chunky
+		shortsField(339, 3),                  // SampleFormat: IEEE floating point
+		// ModelPixelScale
+		doublesField(33550, (g.BBox[2]-g.BBox[0])/float64(g.Width), (g.BBox[3]-g.BBox[1])/float64(g.Height), 0),
+		// ModelTiepoint: raster (0, 0) is at north-west corner
+		doublesField(33922, 0, 0, 0, g.BBox[0], g.BBox[3], 0),
+		// GeoKeyDirectory: version 1.1.0, 3 keys
+		shortsField(34735,
+			1, 1, 0, 3,
+			1024, 0, 1, 2, // GTModelType: geographic
+			1025, 0, 1, 1, // GTRasterType: pixel is area
+			2048, 0, 1, 4326, // GeographicType: WGS 84
+		),
+		asciiField(42113, "-9999"), // GDAL_NODATA
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].tag < fields[j].tag })
+
+	// Layout: header, IFD, values not fitting into IFD entries, pixel data
+	ifdSize := 2 + 12*len(fields) + 4
+	offset := uint32(headerSize + ifdSize)
+	var extra bytes.Buffer
+	offsets := make([]uint32, len(fields))
+	for i, f := range fields {
+		if len(f.data) > 4 {
+			offsets[i] = offset + uint32(extra.Len())
+			extra.Write(f.data)
+			if extra.Len()%2 != 0 {
+				// Values must begin on a word boundary
+				extra.WriteByte(0)
+			}
+		}
+	}
+	pixelsOffset := offset + uint32(extra.Len())
+	for i, f := range fields {
+		if f.tag == 273 {
+			binary.LittleEndian.PutUint32(fields[i].data, pixelsOffset)
+		}
+	}
+
+	b := new(bytes.Buffer)
+	b.WriteString("II")
+	_ = binary.Write(b, binary.LittleEndian, uint16(42))
+	_ = binary.Write(b, binary.LittleEndian, uint32(headerSize))
+
+	_ = binary.Write(b, binary.LittleEndian, uint16(len(fields)))
+	for i, f := range fields {
+		_ = binary.Write(b, binary.LittleEndian, f.tag)
+		_ = binary.Write(b, binary.LittleEndian, f.typ)
+		_ = binary.Write(b, binary.LittleEndian, f.count)
+		if len(f.data) > 4 {
+			_ = binary.Write(b, binary.LittleEndian, offsets[i])
+		} else {
+			v := make([]byte, 4)
+			copy(v, f.data)
+			b.Write(v)
+		}
+	}
+	// No next IFD
+	_ = binary.Write(b, binary.LittleEndian, uint32(0))
+
+	b.Write(extra.Bytes())
+
+	if _, err := w.Write(b.Bytes()); err != nil {
+		return err
+	}
+	_, err := w.Write(pixels.Bytes())
+	return err
+}
@@ -0,0 +1,165 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raster
+
+import (
+	"math"
+)
+
+const (
+	earthRadius = 6371008.8
+	// metersPerDegree is the length of one degree of latitude
+	metersPerDegree = earthRadius * math.Pi / 180
+)
+
+// Sample is a measured value at location (Longitude, Latitude).
+type Sample struct {
+	Longitude float64
+	Latitude  float64
+	Value     float64
+}
+
+// Interpolator interpolates sample values using inverse distance weighting (IDW).
+// Only samples within MaxDistance of interpolated point are used, so points
+// having no samples around are left without value instead of being extrapolated.
+type Interpolator struct {
+	samples []Sample
+	// Power is the distance weighting power parameter
+	Power float64
+	// MaxDistance is the sample search radius in meters
+	MaxDistance float64
+}
+
+// NewInterpolator creates IDW interpolator of samples ss.
+func NewInterpolator(ss []Sample, power, maxDistance float64) *Interpolator {
+	return &Interpolator{
+		samples:     ss,
+		Power:       power,
+		MaxDistance: maxDistance,
+	}
+}
+
+// Margin returns bounding box [min_long, min_lat, max_long, max_lat] of samples affecting
+// values within bounding box bbox.
+func (ip *Interpolator) Margin(bbox [4]float64) [4]float64 {
+	dlat := ip.MaxDistance / metersPerDegree
+	// Use the latitude farthest from equator as the longitude degree is the shortest there
+	lat := math.Min(math.Max(math.Abs(bbox[1]), math.Abs(bbox[3]))+dlat, 89)
+	dlon := math.Min(dlat/math.Cos(lat*math.Pi/180), 180)
+	return [4]float64{bbox[0] - dlon, bbox[1] - dlat, bbox[2] + dlon, bbox[3] + dlat}
+}
+
+// Within returns interpolator using only samples affecting values within bounding box bbox.
+func (ip *Interpolator) Within(bbox [4]float64) *Interpolator {
+	m := ip.Margin(bbox)
+	var ss []Sample
+	for _, s := range ip.samples {
+		if s.Longitude >= m[0] && s.Longitude <= m[2] && s.Latitude >= m[1] && s.Latitude <= m[3] {
+			ss = append(ss, s)
+		}
+	}
+	return NewInterpolator(ss, ip.Power, ip.MaxDistance)
+}
+
+// At returns interpolated value at location (lon, lat), or false if there are no samples within max distance.
+func (ip *Interpolator) At(lon, lat float64) (float64, bool) {
+	var ws, wvs float64
+	kx := metersPerDegree * math.Cos(lat*math.Pi/180)
+	for _, s := range ip.samples {
+		// Equirectangular approximation is accurate enough at interpolation distances
+		dx := (s.Longitude - lon) * kx
+		dy := (s.Latitude - lat) * metersPerDegree
+		d := math.Sqrt(dx*dx + dy*dy)
+		if d > ip.MaxDistance {
+			continue
+		}
+		if d < 1 {
+			return s.Value, true
+		}
+		w := 1 / math.Pow(d, ip.Power)
+		ws += w
+		wvs += w * s.Value
+	}
+	if ws == 0 {
+		return 0, false
+	}
+	return wvs / ws, true
+}
+
+// Grid is a raster of values. Values are stored row by row starting from the north-west corner,
+// missing values are NaN.
+type Grid struct {
+	// BBox is a grid bounding box [min_long, min_lat, max_long, max_lat]
+	BBox   [4]float64
+	Width  int
+	Height int
+	Values []float64
+}
+
+// NewGrid interpolates values of grid of given size covering bounding box bbox with equal
+// longitude and latitude steps.
+func NewGrid(ip *Interpolator, bbox [4]float64, width, height int) Grid {
+	ip = ip.Within(bbox)
+	g := Grid{BBox: bbox, Width: width, Height: height, Values: make([]float64, width*height)}
+	dx := (bbox[2] - bbox[0]) / float64(width)
+	dy := (bbox[3] - bbox[1]) / float64(height)
+	for r := 0; r < height; r++ {
+		lat := bbox[3] - (float64(r)+0.5)*dy
+		for c := 0; c < width; c++ {
+			lon := bbox[0] + (float64(c)+0.5)*dx
+			g.Values[r*width+c] = valueOrNaN(ip.At(lon, lat))
+		}
+	}
+	return g
+}
+
+// NewTileGrid interpolates values of XYZ (Web Mercator) tile z/x/y pixels, tile has size pixels along its side.
+func NewTileGrid(ip *Interpolator, z, x, y, size int) Grid {
+	bbox := TileBounds(z, x, y)
+	ip = ip.Within(bbox)
+	g := Grid{BBox: bbox, Width: size, Height: size, Values: make([]float64, size*size)}
+	n := math.Exp2(float64(z))
+	for r := 0; r < size; r++ {
+		lat := tileLatitude((float64(y) + (float64(r)+0.5)/float64(size)) / n)
+		for c := 0; c < size; c++ {
+			lon := (float64(x)+(float64(c)+0.5)/float64(size))/n*360 - 180
+			g.Values[r*size+c] = valueOrNaN(ip.At(lon, lat))
+		}
+	}
+	return g
+}
+
+// TileBounds returns bounding box [min_long, min_lat, max_long, max_lat] of XYZ tile z/x/y.
+func TileBounds(z, x, y int) [4]float64 {
+	n := math.Exp2(float64(z))
+	return [4]float64{
+		float64(x)/n*360 - 180,
+		tileLatitude(float64(y+1) / n),
+		float64(x+1)/n*360 - 180,
+		tileLatitude(float64(y) / n),
+	}
+}
+
+// tileLatitude returns latitude of Web Mercator normalized y coordinate (0 at the north edge, 1 at the south one).
+func tileLatitude(y float64) float64 {
+	return math.Atan(math.Sinh(math.Pi*(1-2*y))) * 180 / math.Pi
+}
+
+func valueOrNaN(v float64, ok bool) float64 {
+	if !ok {
+		return math.NaN()
+	}
+	return v
+}
@@ -0,0 +1,86 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raster
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestInterpolator_At(t *testing.T) {
+	ip := NewInterpolator([]Sample{
+		{Longitude: 44.50, Latitude: 48.70, Value: 10},
+		{Longitude: 44.52, Latitude: 48.70, Value: 30},
+	}, 2, 5000)
+
+	tests := []struct {
+		name     string
+		lon, lat float64
+		want     float64
+		ok       bool
+	}{
+		{name: "sample", lon: 44.50, lat: 48.70, want: 10, ok: true},
+		{name: "midpoint", lon: 44.51, lat: 48.70, want: 20, ok: true},
+		{name: "single in range", lon: 44.45, lat: 48.70, want: 10, ok: true},
+		{name: "masked", lon: 44.50, lat: 49.00, ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ip.At(tt.lon, tt.lat)
+			if ok != tt.ok || (ok && math.Abs(got-tt.want) > 1e-6) {
+				t.Errorf("At() = %v, %v, want %v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestTileBounds(t *testing.T) {
+	b := TileBounds(1, 1, 0)
+	if b[0] != 0 || b[2] != 180 || math.Abs(b[1]) > 1e-9 || math.Abs(b[3]-85.0511287798) > 1e-6 {
+		t.Errorf("TileBounds() = %v, want [0 0 180 85.0511]", b)
+	}
+}
+
+func TestNewGrid(t *testing.T) {
+	ip := NewInterpolator([]Sample{{Longitude: 0.5, Latitude: 0.5, Value: 1}}, 2, 100000)
+	g := NewGrid(ip, [4]float64{0, 0, 4, 4}, 4, 4)
+	// Only south-west corner pixel containing the sample is within max distance of it
+	for i, v := range g.Values {
+		if (i == 12) == math.IsNaN(v) {
+			t.Errorf("grid value %d = %v", i, v)
+		}
+	}
+}
+
+func TestWriteGeoTiff(t *testing.T) {
+	g := Grid{BBox: [4]float64{0, 0, 2, 1}, Width: 2, Height: 1, Values: []float64{1.5, math.NaN()}}
+	var b bytes.Buffer
+	if err := WriteGeoTiff(&b, g); err != nil {
+		t.Fatal(err)
+	}
+	p := b.Bytes()
+	if string(p[:2]) != "II" || binary.LittleEndian.Uint16(p[2:]) != 42 {
+		t.Fatalf("invalid TIFF header: %v", p[:4])
+	}
+	// Pixel data is at the end of file
+	px := p[len(p)-8:]
+	v1 := math.Float32frombits(binary.LittleEndian.Uint32(px))
+	v2 := math.Float32frombits(binary.LittleEndian.Uint32(px[4:]))
+	if v1 != 1.5 || v2 != GeoTiffNoData {
+		t.Errorf("pixel values = %v, %v, want 1.5, %v", v1, v2, GeoTiffNoData)
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"math"
+	"sort"
+)
+
+const (
+	// outlierThreshold is the modified z-score above which value is considered an outlier
+	outlierThreshold = 3.5
+	// minOutlierSamples is the minimum number of values to detect outliers among them
+	minOutlierSamples = 3
+)
+
+// Median returns median of values vs, NaN if vs is empty.
+func Median(vs []float64) float64 {
+	if len(vs) == 0 {
+		return math.NaN()
+	}
+	s := append([]float64(nil), vs...)
+	sort.Float64s(s)
+	n := len(s)
+	if n%2 == 1 {
+		return s[n/2]
+	}
+	return (s[n/2-1] + s[n/2]) / 2
+}
+
+// Outliers detects outliers among values vs using modified z-score based on median absolute deviation (MAD),
+// which, unlike standard deviation, is not affected by outliers themselves. Mean absolute deviation is used
+// instead of MAD if the latter is zero, e.g. when more than half of values are equal.
+// It returns slice of flags marking outlier values.
+func Outliers(vs []float64) []bool {
+	os := make([]bool, len(vs))
+	if len(vs) < minOutlierSamples {
+		return os
+	}
+
+	med := Median(vs)
+	ds := make([]float64, len(vs))
+	for i, v := range vs {
+		ds[i] = math.Abs(v - med)
+	}
+
+	// Scale factors make both deviations consistent estimators of standard deviation for normal distribution
+	scale := 1.4826 * Median(ds)
+	if scale == 0 {
+		var sum float64
+		for _, d := range ds {
+			sum += d
+		}
+		scale = 1.2533 * sum / float64(len(ds))
+	}
+	if scale == 0 {
+		return os
+	}
+
+	for i, d := range ds {
+		os[i] = d/scale > outlierThreshold
+	}
+
+	return os
+}
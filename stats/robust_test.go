@@ -0,0 +1,71 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stats
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestMedian(t *testing.T) {
+	tests := []struct {
+		name string
+		vs   []float64
+		want float64
+	}{
+		{name: "single", vs: []float64{5}, want: 5},
+		{name: "odd", vs: []float64{7, 1, 3}, want: 3},
+		{name: "even", vs: []float64{4, 1, 3, 2}, want: 2.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Median(tt.vs); got != tt.want {
+				t.Errorf("Median() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if got := Median(nil); !math.IsNaN(got) {
+		t.Errorf("Median(nil) = %v, want NaN", got)
+	}
+
+	vs := []float64{3, 1, 2}
+	Median(vs)
+	if !reflect.DeepEqual(vs, []float64{3, 1, 2}) {
+		t.Errorf("Median() modified values: %v", vs)
+	}
+}
+
+func TestOutliers(t *testing.T) {
+	tests := []struct {
+		name string
+		vs   []float64
+		want []bool
+	}{
+		{name: "too few", vs: []float64{10, 900}, want: []bool{false, false}},
+		{name: "none", vs: []float64{10, 12, 14, 11, 13}, want: []bool{false, false, false, false, false}},
+		{name: "broken sensor", vs: []float64{10, 12, 999, 11, 13}, want: []bool{false, false, true, false, false}},
+		{name: "zero MAD", vs: []float64{10, 10, 10, 10, 500}, want: []bool{false, false, false, false, true}},
+		{name: "equal", vs: []float64{10, 10, 10}, want: []bool{false, false, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Outliers(tt.vs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Outliers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
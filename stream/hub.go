@@ -0,0 +1,234 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stream
+
+import (
+	"sync"
+	"time"
+
+	"github.com/openairtech/api"
+
+	"github.com/openairtech/apiserver/db"
+)
+
+const (
+	// subscriptionBufferSize is the number of events buffered for subscriber
+	// before it is considered too slow and its subscription is closed
+	subscriptionBufferSize = 256
+)
+
+// Event is a live measurement event.
+type Event struct {
+	Id          uint64
+	StationId   int
+	Longitude   float64
+	Latitude    float64
+	IsPublic    bool
+	Measurement api.Measurement
+}
+
+// Filter defines events to deliver to subscriber.
+type Filter struct {
+	// Stations, if not empty, is a set of station IDs to get events of
+	Stations map[int]bool
+	// BBox, if not empty, defines a bounding box [min_long, min_lat, max_long, max_lat] to get events within it
+	BBox []float64
+	// Vars, if not empty, specifies measurement variable names to get events with
+	Vars []string
+	// All, if true, includes events of non-public stations
+	All bool
+}
+
+// Match checks whether event e satisfies filter.
+func (f Filter) Match(e Event) bool {
+	if !f.All && !e.IsPublic {
+		return false
+	}
+	if len(f.Stations) > 0 && !f.Stations[e.StationId] {
+		return false
+	}
+	if len(f.BBox) == 4 && (e.Longitude < f.BBox[0] || e.Longitude > f.BBox[2] ||
+		e.Latitude < f.BBox[1] || e.Latitude > f.BBox[3]) {
+		return false
+	}
+	if len(f.Vars) > 0 {
+		m := FilterVars(e.Measurement, f.Vars)
+		return m.Temperature != nil || m.Humidity != nil || m.Pressure != nil ||
+			m.Pm25 != nil || m.Pm10 != nil || m.Aqi != nil
+	}
+	return true
+}
+
+// FilterVars returns copy of measurement m with given variables only, timestamp is always kept.
+// All variables are kept if vars is empty.
+func FilterVars(m api.Measurement, vars []string) api.Measurement {
+	if len(vars) == 0 {
+		return m
+	}
+	fm := api.Measurement{Timestamp: m.Timestamp}
+	for _, v := range vars {
+		switch v {
+		case "temperature":
+			fm.Temperature = m.Temperature
+		case "humidity":
+			fm.Humidity = m.Humidity
+		case "pressure":
+			fm.Pressure = m.Pressure
+		case "pm25":
+			fm.Pm25 = m.Pm25
+		case "pm10":
+			fm.Pm10 = m.Pm10
+		case "aqi":
+			fm.Aqi = m.Aqi
+		}
+	}
+	return fm
+}
+
+// Subscription is a subscription to live measurement events.
+// Channel C is closed when subscription is closed by hub, e.g. if subscriber is too slow to receive events.
+type Subscription struct {
+	C      chan Event
+	filter Filter
+	hub    *Hub
+}
+
+// Close cancels subscription.
+func (s *Subscription) Close() {
+	s.hub.unsubscribe(s)
+}
+
+// Hub delivers live measurement events to subscribers keeping recent events in replay buffer,
+// so subscribers are able to resume receiving events after reconnection.
+type Hub struct {
+	mu     sync.Mutex
+	nextId uint64
+	// buf is a ring buffer of recent events
+	buf    []Event
+	head   int
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+// NewHub creates hub with replay buffer of given size.
+func NewHub(replaySize int) *Hub {
+	return &Hub{
+		// Start event IDs from current time to keep them increasing across restarts
+		nextId: uint64(time.Now().UnixMilli()) * 1000,
+		buf:    make([]Event, 0, replaySize),
+		subs:   make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish delivers measurements of station feed f to subscribers.
+func (h *Hub) Publish(f db.Feed) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return
+	}
+
+	for _, m := range f.Measurements {
+		e := Event{
+			Id:          h.nextId,
+			StationId:   f.StationId,
+			Longitude:   f.Location.X,
+			Latitude:    f.Location.Y,
+			IsPublic:    f.IsPublic,
+			Measurement: m.ApiMeasurement(),
+		}
+		h.nextId++
+
+		if cap(h.buf) > 0 {
+			if len(h.buf) < cap(h.buf) {
+				h.buf = append(h.buf, e)
+			} else {
+				h.buf[h.head] = e
+				h.head = (h.head + 1) % len(h.buf)
+			}
+		}
+
+		for s := range h.subs {
+			if !s.filter.Match(e) {
+				continue
+			}
+			select {
+			case s.C <- e:
+			default:
+				// Subscriber is too slow, so close its subscription to let it resume after reconnection
+				h.remove(s)
+			}
+		}
+	}
+}
+
+// Subscribe subscribes to events satisfying filter f. If lastId is not nil,
+// buffered events following event with that ID are delivered first.
+func (h *Hub) Subscribe(f Filter, lastId *uint64) *Subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var replay []Event
+	if lastId != nil {
+		for i := range h.buf {
+			e := h.buf[(h.head+i)%len(h.buf)]
+			if e.Id > *lastId && f.Match(e) {
+				replay = append(replay, e)
+			}
+		}
+	}
+
+	s := &Subscription{
+		C:      make(chan Event, len(replay)+subscriptionBufferSize),
+		filter: f,
+		hub:    h,
+	}
+	for _, e := range replay {
+		s.C <- e
+	}
+
+	if h.closed {
+		close(s.C)
+		return s
+	}
+
+	h.subs[s] = struct{}{}
+
+	return s
+}
+
+func (h *Hub) unsubscribe(s *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(s)
+}
+
+func (h *Hub) remove(s *Subscription) {
+	if _, ok := h.subs[s]; ok {
+		delete(h.subs, s)
+		close(s.C)
+	}
+}
+
+// Close closes all subscriptions, so subscribers are able to finish.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for s := range h.subs {
+		h.remove(s)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stream
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cridenour/go-postgis"
+
+	"github.com/openairtech/apiserver/db"
+)
+
+func testFeed(stationId int, x, y float64, public bool, pm25 ...float64) db.Feed {
+	now := time.Now()
+	f := db.Feed{
+		StationId: stationId,
+		Seen:      now,
+		Location:  postgis.PointS{SRID: 4326, X: x, Y: y},
+		IsPublic:  public,
+	}
+	for _, v := range pm25 {
+		f.Measurements = append(f.Measurements, db.Measurement{
+			Timestamp: &now,
+			Pm25:      sql.NullFloat64{Float64: v, Valid: true},
+		})
+	}
+	return f
+}
+
+func receive(s *Subscription) []Event {
+	var es []Event
+	for {
+		select {
+		case e, ok := <-s.C:
+			if !ok {
+				return es
+			}
+			es = append(es, e)
+		default:
+			return es
+		}
+	}
+}
+
+func eventStations(es []Event) []int {
+	ids := make([]int, 0, len(es))
+	for _, e := range es {
+		ids = append(ids, e.StationId)
+	}
+	return ids
+}
+
+func TestHub_Filter(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []int
+	}{
+		{name: "public", want: []int{1, 2}},
+		{name: "all", filter: Filter{All: true}, want: []int{1, 2, 3}},
+		{name: "stations", filter: Filter{Stations: map[int]bool{2: true, 3: true}}, want: []int{2}},
+		{name: "bbox", filter: Filter{BBox: []float64{44.0, 48.0, 45.0, 49.0}, All: true}, want: []int{1, 3}},
+		{name: "vars", filter: Filter{Vars: []string{"pm10"}}, want: []int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHub(10)
+			s := h.Subscribe(tt.filter, nil)
+			h.Publish(testFeed(1, 44.5, 48.7, true, 10))
+			h.Publish(testFeed(2, 37.6, 55.7, true, 20))
+			h.Publish(testFeed(3, 44.6, 48.8, false, 30))
+			if got := eventStations(receive(s)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("received events of stations %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHub_Replay(t *testing.T) {
+	h := NewHub(3)
+	h.Publish(testFeed(1, 44.5, 48.7, true, 1, 2, 3, 4))
+
+	es := receive(h.Subscribe(Filter{}, nil))
+	if len(es) != 0 {
+		t.Fatalf("received %d events without last event id, want none", len(es))
+	}
+
+	var none uint64
+	es = receive(h.Subscribe(Filter{}, &none))
+	if len(es) != 3 || *es[0].Measurement.Pm25 != 2 {
+		t.Fatalf("received %+v replayed events, want last 3", es)
+	}
+
+	es = receive(h.Subscribe(Filter{}, &es[1].Id))
+	if len(es) != 1 || *es[0].Measurement.Pm25 != 4 {
+		t.Errorf("received %+v replayed events, want the last one", es)
+	}
+}
+
+func TestHub_SlowSubscriber(t *testing.T) {
+	h := NewHub(0)
+	s := h.Subscribe(Filter{}, nil)
+	for i := 0; i <= subscriptionBufferSize; i++ {
+		h.Publish(testFeed(1, 44.5, 48.7, true, float64(i)))
+	}
+	if es := receive(s); len(es) != subscriptionBufferSize {
+		t.Errorf("received %d events, want %d", len(es), subscriptionBufferSize)
+	}
+	if _, ok := <-s.C; ok {
+		t.Errorf("slow subscription is not closed")
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -58,6 +59,57 @@ func ParseBBox(sbb string) ([]float64, error) {
 	return bbvs, nil
 }
 
+// ParseLatLon parses given point in comma-delimited "latitude,longitude" string format, like "48.7,44.5".
+// It returns nil for empty string, and error if point string is invalid.
+func ParseLatLon(sll string) ([]float64, error) {
+	if sll == "" {
+		return nil, nil
+	}
+
+	sllvs := strings.Split(sll, ",")
+	if len(sllvs) != 2 {
+		return nil, fmt.Errorf("invalid point: [%s]", sll)
+	}
+
+	lat, err := strconv.ParseFloat(strings.TrimSpace(sllvs[0]), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return nil, fmt.Errorf("invalid point [%s] latitude: %s", sll, sllvs[0])
+	}
+
+	lon, err := strconv.ParseFloat(strings.TrimSpace(sllvs[1]), 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return nil, fmt.Errorf("invalid point [%s] longitude: %s", sll, sllvs[1])
+	}
+
+	return []float64{lat, lon}, nil
+}
+
+// ParseDistance parses given distance string ds in meters or kilometers, like "500", "500m" or "2km".
+// It returns distance in meters or nil for empty string, and error if distance string is invalid.
+func ParseDistance(ds string) (*float64, error) {
+	if ds == "" {
+		return nil, nil
+	}
+
+	m := 1.0
+	s := ds
+	if strings.HasSuffix(s, "km") {
+		m = 1000
+		s = strings.TrimSuffix(s, "km")
+	} else {
+		s = strings.TrimSuffix(s, "m")
+	}
+
+	d, err := strconv.ParseFloat(s, 64)
+	if err != nil || d < 0 {
+		return nil, fmt.Errorf("invalid distance: %s", ds)
+	}
+
+	d *= m
+
+	return &d, nil
+}
+
 // ParseDuration parses given duration string ds into duration.
 // It returns parsed duration value or nil for empty string, and error if duration string is invalid.
 func ParseDuration(ds string) (*time.Duration, error) {
@@ -90,6 +142,35 @@ func ParseUnixTime(uts string) (*time.Time, error) {
 	return &t, nil
 }
 
+// ParseTimezone parses given IANA time zone name tz into location.
+// It returns location or nil for empty string, and error if there is no such time zone.
+func ParseTimezone(tz string) (*time.Location, error) {
+	if tz == "" {
+		return nil, nil
+	}
+	if tz == "Local" {
+		return nil, fmt.Errorf("unknown time zone %s", tz)
+	}
+
+	return time.LoadLocation(tz)
+}
+
+// LongitudeTimezone returns nautical time zone of given longitude, i.e. fixed whole hours UTC offset
+// zone named like IANA Etc/GMT zones. It is an approximation of local time zone when it is not known.
+func LongitudeTimezone(lon float64) *time.Location {
+	h := int(math.Round(math.Mod(lon, 360) / 15))
+	if h > 12 {
+		h -= 24
+	} else if h < -12 {
+		h += 24
+	}
+	if h == 0 {
+		return time.FixedZone("Etc/GMT", 0)
+	}
+	// Etc/GMT zone offsets have inverted sign
+	return time.FixedZone(fmt.Sprintf("Etc/GMT%+d", -h), h*60*60)
+}
+
 // StringInSlice checks string s is in slice list.
 func StringInSlice(s string, list []interface{}) bool {
 	for _, l := range list {
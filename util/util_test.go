@@ -0,0 +1,109 @@
+// Copyright © 2019 Victor Antonovich <victor@antonovich.me>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseLatLon(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    []float64
+		wantErr bool
+	}{
+		{s: "", want: nil},
+		{s: "48.7,44.5", want: []float64{48.7, 44.5}},
+		{s: "48.7, 44.5", want: []float64{48.7, 44.5}},
+		{s: "48.7", wantErr: true},
+		{s: "91,44.5", wantErr: true},
+		{s: "48.7,x", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := ParseLatLon(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLatLon() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseLatLon() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDistance(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    float64
+		wantErr bool
+	}{
+		{s: "500", want: 500},
+		{s: "500m", want: 500},
+		{s: "2km", want: 2000},
+		{s: "1.5km", want: 1500},
+		{s: "-1", wantErr: true},
+		{s: "2mi", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := ParseDistance(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseDistance() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && *got != tt.want {
+				t.Errorf("ParseDistance() = %v, want %v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLongitudeTimezone(t *testing.T) {
+	tests := []struct {
+		lon    float64
+		name   string
+		offset int
+	}{
+		{lon: 0, name: "Etc/GMT", offset: 0},
+		{lon: 37.6, name: "Etc/GMT-3", offset: 3 * 60 * 60},
+		{lon: -74, name: "Etc/GMT+5", offset: -5 * 60 * 60},
+		{lon: 179.9, name: "Etc/GMT-12", offset: 12 * 60 * 60},
+		{lon: -180, name: "Etc/GMT+12", offset: -12 * 60 * 60},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loc := LongitudeTimezone(tt.lon)
+			if _, offset := time.Unix(0, 0).In(loc).Zone(); loc.String() != tt.name || offset != tt.offset {
+				t.Errorf("LongitudeTimezone(%v) = %s (%d), want %s (%d)", tt.lon, loc, offset, tt.name, tt.offset)
+			}
+		})
+	}
+}
+
+func TestParseTimezone(t *testing.T) {
+	if loc, err := ParseTimezone(""); loc != nil || err != nil {
+		t.Errorf("ParseTimezone() = %v, %v, want nil", loc, err)
+	}
+	if loc, err := ParseTimezone("Europe/Moscow"); err != nil || loc.String() != "Europe/Moscow" {
+		t.Errorf("ParseTimezone() = %v, %v, want Europe/Moscow", loc, err)
+	}
+	for _, tz := range []string{"Local", "Mars/Olympus"} {
+		if _, err := ParseTimezone(tz); err == nil {
+			t.Errorf("ParseTimezone(%s) succeeded", tz)
+		}
+	}
+}